@@ -1,17 +1,32 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"example.com/cursorrules-golang/internal/apikeys"
+	"example.com/cursorrules-golang/internal/auth"
+	"example.com/cursorrules-golang/internal/authz"
 	"example.com/cursorrules-golang/internal/cache"
 	"example.com/cursorrules-golang/internal/database"
 	"example.com/cursorrules-golang/internal/handlers"
+	"example.com/cursorrules-golang/internal/mailer"
 	"example.com/cursorrules-golang/internal/metrics"
 	"example.com/cursorrules-golang/internal/middleware"
+	"example.com/cursorrules-golang/internal/models"
+	"example.com/cursorrules-golang/internal/quota"
+	"example.com/cursorrules-golang/internal/ratelimit"
+	"example.com/cursorrules-golang/internal/resp"
 )
 
 func main() {
@@ -19,56 +34,358 @@ func main() {
 	db := database.InitDB()
 	defer db.Close()
 
-	// Initialize cache with configuration
-	cacheConfig := cache.Config{
-		MaxItems:        10000,
-		CleanupInterval: 5 * time.Minute,
-	}
-	cache := cache.New(cacheConfig)
+	// Initialize cache, shared between replicas when a store is configured
+	searchCache := searchCacheFromEnv()
 
 	// Initialize metrics
 	metrics := metrics.GetMetrics()
 
-	// Initialize rate limiter
-	rateLimiter := middleware.NewRateLimiter(100, 1000) // 100 requests per second, bucket size 1000
+	// Initialize rate limiter with per-route budgets
+	rateLimiter, err := middleware.NewPolicyRateLimiter(rateLimitConfigFromEnv(db))
+	if err != nil {
+		log.Fatalf("Invalid rate limit configuration: %v", err)
+	}
+	rateLimiter.StartJanitor(time.Minute)
+	defer rateLimiter.Stop()
+
+	// Every request except health probes is first counted against its client
+	// address, so that requests failing authentication cannot be sent without limit
+	preAuthLimiter, err := middleware.NewPolicyRateLimiter(middleware.RateLimitConfig{
+		Default:     middleware.RateLimitRule{Rate: 100, Burst: 1000},
+		ExemptCIDRs: strings.Split(os.Getenv("RATE_LIMIT_EXEMPT"), ","),
+		ExemptPaths: []string{"/health"},
+	})
+	if err != nil {
+		log.Fatalf("Invalid RATE_LIMIT_EXEMPT: %v", err)
+	}
+	preAuthLimiter.WithKeyFunc(middleware.IPKey).StartJanitor(time.Minute)
+	defer preAuthLimiter.Stop()
+
+	// Slow queries shrink the number of requests served at once; the excess
+	// waits briefly and is then shed, except for health probes
+	concurrencyLimiter := middleware.NewConcurrencyLimiter(middleware.ConcurrencyConfig{
+		InitialLimit:  32,
+		MinLimit:      4,
+		MaxLimit:      128,
+		QueueSize:     256,
+		QueueTimeout:  2 * time.Second,
+		TargetLatency: 500 * time.Millisecond,
+	})
+
+	// Paid plans get larger daily and monthly quotas, counted in the database
+	quotas, err := quota.NewManager(db, quotaPlansFromEnv())
+	if err != nil {
+		log.Fatalf("Invalid quota plans: %v", err)
+	}
+	if err := quotas.Prune(context.Background()); err != nil {
+		log.Printf("Failed to prune quota usage: %v", err)
+	}
+
+	// Token validation settings come from the environment
+	middleware.ConfigureJWT(jwtConfigFromEnv())
+
+	// Callers authenticate with a bearer JWT or an X-API-Key
+	apiKeys := apikeys.NewStore(db)
+	authenticator := middleware.NewAuthenticator(apiKeys)
+
+	// Repeated failed logins lock out the account and, more leniently, the client
+	loginGuard := auth.NewGuard(
+		auth.LockoutConfig{Threshold: 5, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 15 * time.Minute},
+		auth.LockoutConfig{Threshold: 20, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 15 * time.Minute},
+	)
+
+	// Name shown next to enrolled accounts in authenticator apps
+	totpIssuer := os.Getenv("TOTP_ISSUER")
+	if totpIssuer == "" {
+		totpIssuer = "User Management API"
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	// Verification and password reset links are signed and sent by email
+	baseURL := os.Getenv("PUBLIC_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + port
+	}
+	accountMail := handlers.AccountMail{
+		Tokens:  auth.NewActionTokens(db, actionTokenSecret()),
+		Mailer:  mailerFromEnv(),
+		BaseURL: baseURL,
+	}
 
-	mux := http.NewServeMux()
+	// Authentication is configured per route at registration; rate,
+	// concurrency and quota limiting run after it so that callers are known
+	router := middleware.NewRouter(authenticator.Middleware)
+	router.Use(rateLimiter.RateLimit, concurrencyLimiter.Limit, middleware.Quota(quotas, "/me/usage"))
 
 	// API endpoints
-	mux.HandleFunc("/users", handlers.UsersHandler(db))
-	mux.HandleFunc("/users/", handlers.UserHandler(db))
-	mux.HandleFunc("/users/search", handlers.SearchUsersHandler(db, cache))
-	mux.HandleFunc("/health", handlers.HealthCheckHandler(db))
-	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/users", handlers.UsersHandler(db,
+		handlers.InvalidateOnCreate(searchCache), accountMail.VerificationOnCreate()), middleware.Scoped(middleware.Policy{
+		http.MethodGet:  {authz.Admin, authz.UsersRead},
+		http.MethodPost: {authz.Admin, authz.UsersWrite},
+	}))
+	router.Handle("/users/", handlers.UserHandler(db,
+		handlers.InvalidateOnChange(searchCache), accountMail.RevokeOnEmailChange(),
+		handlers.RevokeKeysOnDelete(apiKeys)), middleware.Scoped(middleware.Policy{
+		http.MethodGet:    {authz.UsersRead},
+		http.MethodPut:    {authz.UsersWrite},
+		http.MethodPatch:  {authz.UsersWrite},
+		http.MethodDelete: {authz.Admin, authz.MFA},
+	}))
+	router.Handle("/users/search", handlers.SearchUsersHandler(db, searchCache), middleware.Scoped(middleware.Policy{
+		http.MethodGet: {authz.Admin, authz.UsersRead},
+	}))
+	router.Handle("/auth/login", handlers.LoginHandler(db, loginGuard), middleware.Public(http.MethodPost))
+	router.Handle("/auth/unlock", handlers.UnlockHandler(loginGuard), middleware.Scoped(middleware.Policy{
+		http.MethodPost: {authz.Admin},
+	}))
+	router.Handle("/auth/forgot", handlers.ForgotPasswordHandler(db, accountMail), middleware.Public(http.MethodPost))
+	router.Handle("/auth/reset", handlers.ResetPasswordHandler(db, accountMail.Tokens, loginGuard),
+		middleware.Public(http.MethodPost))
+	router.Handle("/auth/verify", handlers.VerifyEmailHandler(db, accountMail.Tokens), middleware.Public(http.MethodGet))
+	router.Handle("/auth/verify/resend", handlers.ResendVerificationHandler(db, accountMail),
+		middleware.Authenticated(http.MethodPost))
+	router.Handle("/auth/totp/enroll", handlers.TOTPEnrollHandler(db, totpIssuer),
+		middleware.Authenticated(http.MethodPost))
+	router.Handle("/auth/totp/confirm", handlers.TOTPConfirmHandler(db),
+		middleware.Authenticated(http.MethodPost))
+	router.Handle("/api-keys", handlers.APIKeysHandler(apiKeys),
+		middleware.Authenticated(http.MethodGet, http.MethodPost))
+	router.Handle("/api-keys/", handlers.APIKeyHandler(apiKeys),
+		middleware.Authenticated(http.MethodDelete))
+	router.Handle("/me/usage", handlers.UsageHandler(quotas), middleware.Authenticated(http.MethodGet))
+	router.Handle("/quota/plan", handlers.PlanHandler(quotas), middleware.Scoped(middleware.Policy{
+		http.MethodPost: {authz.Admin},
+	}))
+	router.Handle("/health", handlers.HealthCheckHandler(db), middleware.Public(http.MethodGet))
+	router.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
 		// Combine application metrics with cache stats
 		stats := metrics.GetSnapshot()
-		cacheStats := cache.GetStats()
+		cacheStats := searchCache.GetStats()
 
 		// Merge metrics
 		response := map[string]interface{}{
 			"app_metrics": stats,
 			"cache_stats": cacheStats,
+			"concurrency": concurrencyLimiter.Stats(),
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
-	})
+	}, middleware.Scoped(middleware.Policy{
+		http.MethodGet: {authz.Admin},
+	}))
 
-	// Apply middleware chain
-	handler := middleware.Logging(
-		rateLimiter.RateLimit(
-			middleware.AuthMiddleware(mux),
-		),
-	)
+	// Forwarding headers are only believed from our own load balancers
+	realIP, err := middleware.NewRealIP(strings.Split(os.Getenv("TRUSTED_PROXIES"), ","))
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Apply middleware chain; the client address is resolved before anything logs it
+	handler := realIP.Middleware(middleware.Logging(preAuthLimiter.RateLimit(router)))
+
+	server := &http.Server{Addr: ":" + port, Handler: handler}
+
+	// Stop accepting requests on SIGINT or SIGTERM, let in-flight ones finish,
+	// then release background resources
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Printf("Starting server on :%s", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Graceful shutdown failed: %v", err)
 	}
+	searchCache.Close()
+}
+
+// searchCache is a SearchCache that also reports statistics and holds resources
+type searchCache interface {
+	handlers.SearchCache
+	GetStats() map[string]interface{}
+	Close() error
+}
+
+// searchCacheFromEnv keeps search results in process by default, or in the
+// Redis compatible server at CACHE_REDIS_ADDR when CACHE_STORE is "redis",
+// authenticating with CACHE_REDIS_PASSWORD when set. That server must be
+// Redis 7.0 or later, or Valkey; older versions cannot store anything. An
+// in-process cache is saved to CACHE_SNAPSHOT when closed and reloaded from
+// it on startup.
+func searchCacheFromEnv() searchCache {
+	switch store := os.Getenv("CACHE_STORE"); store {
+	case "", "memory":
+		c := cache.NewTyped[string, models.PaginatedResponse](cache.Config{
+			MaxItems:        10000,
+			CleanupInterval: 5 * time.Minute,
+			Shards:          16,
+			// Large pages cost more than small ones, and one-off searches must
+			// not flush popular ones
+			MaxBytes:  64 << 20,
+			Admission: true,
+		}).WithSize(handlers.SearchResponseSize)
+
+		path := os.Getenv("CACHE_SNAPSHOT")
+		if path == "" {
+			return c
+		}
+		cache.RegisterCodec[models.PaginatedResponse]("users-search/v1", handlers.SearchResponseCodec{})
+		// A missing or unreadable snapshot only means a cold start
+		n, err := c.LoadSnapshot(path)
+		switch {
+		case err == nil:
+			log.Printf("Restored %d cached searches from %s", n, path)
+		case !errors.Is(err, fs.ErrNotExist):
+			log.Printf("Ignoring cache snapshot %s: %v", path, err)
+		}
+		return snapshotCache{TypedCache: c, path: path}
+	case "redis":
+		addr := os.Getenv("CACHE_REDIS_ADDR")
+		if addr == "" {
+			log.Fatal("CACHE_STORE=redis requires CACHE_REDIS_ADDR")
+		}
+		client := resp.NewClient(addr, resp.Options{Password: os.Getenv("CACHE_REDIS_PASSWORD")})
+		type item = cache.Item[models.PaginatedResponse]
+		return cache.NewShared(cache.NewRemote(client, cache.JSONCodec[item]{}, "search:"))
+	default:
+		log.Fatalf("Unknown CACHE_STORE %q", store)
+		return nil
+	}
+}
 
-	log.Printf("Starting server on :%s", port)
-	err := http.ListenAndServe(":"+port, handler)
+// snapshotCache saves a snapshot of the search cache when it is closed
+type snapshotCache struct {
+	*cache.TypedCache[string, models.PaginatedResponse]
+	path string
+}
+
+func (c snapshotCache) Close() error {
+	if n, err := c.SaveSnapshot(c.path); err != nil {
+		log.Printf("Failed to save cache snapshot %s: %v", c.path, err)
+	} else {
+		log.Printf("Saved %d cached searches to %s", n, c.path)
+	}
+	return c.TypedCache.Close()
+}
+
+// jwtConfigFromEnv reads JWT_SECRET, JWT_ISSUER, JWT_AUDIENCE and JWT_LEEWAY
+func jwtConfigFromEnv() middleware.JWTConfig {
+	cfg := middleware.JWTConfig{
+		Secret:   []byte(os.Getenv("JWT_SECRET")),
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+		Leeway:   30 * time.Second,
+	}
+	if len(cfg.Secret) == 0 {
+		log.Printf("JWT_SECRET is not set, using the insecure development secret")
+	}
+	if leeway := os.Getenv("JWT_LEEWAY"); leeway != "" {
+		d, err := time.ParseDuration(leeway)
+		if err != nil {
+			log.Fatalf("Invalid JWT_LEEWAY %q: %v", leeway, err)
+		}
+		cfg.Leeway = d
+	}
+	return cfg
+}
+
+// rateLimitConfigFromEnv loads the policy table from RATE_LIMIT_CONFIG, or
+// uses the built-in defaults: probes are exempt, searches and writes get
+// tighter budgets than reads. RATE_LIMIT_EXEMPT lists internal callers in
+// either case, added to any exempt_cidrs in the file.
+// RATE_LIMIT_ALGORITHM overrides the algorithm, and RATE_LIMIT_STORE=sqlite
+// shares limits with every replica using the same database.
+func rateLimitConfigFromEnv(db *sql.DB) middleware.RateLimitConfig {
+	cfg := middleware.RateLimitConfig{
+		Default: middleware.RateLimitRule{Rate: 100, Burst: 1000},
+		Rules: []middleware.RateLimitRule{
+			{Pattern: "/health", Exempt: true},
+			{Pattern: "/auth/login", Rate: 1, Burst: 10},
+			{Pattern: "/auth/forgot", Rate: 0.1, Burst: 5},
+			{Pattern: "/users/search", Role: authz.RoleAdmin, Rate: 20, Burst: 100},
+			{Pattern: "/users/search", Rate: 5, Burst: 20},
+			{Method: middleware.MethodClassWrite, Rate: 10, Burst: 50},
+		},
+		ExemptCIDRs: strings.Split(os.Getenv("RATE_LIMIT_EXEMPT"), ","),
+	}
+	if path := os.Getenv("RATE_LIMIT_CONFIG"); path != "" {
+		var err error
+		exempt := cfg.ExemptCIDRs
+		if cfg, err = middleware.LoadRateLimitConfig(path); err != nil {
+			log.Fatalf("Failed to load RATE_LIMIT_CONFIG: %v", err)
+		}
+		cfg.ExemptCIDRs = append(cfg.ExemptCIDRs, exempt...)
+	}
+
+	if algorithm := os.Getenv("RATE_LIMIT_ALGORITHM"); algorithm != "" {
+		cfg.Algorithm = ratelimit.Algorithm(algorithm)
+	}
+	switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+	case "", "memory":
+	case "sqlite":
+		cfg.Store = ratelimit.NewSQLiteStore(db)
+	default:
+		log.Fatalf("Unknown RATE_LIMIT_STORE %q", store)
+	}
+	return cfg
+}
+
+// quotaPlansFromEnv loads plans from the JSON file in QUOTA_PLANS, or uses the defaults
+func quotaPlansFromEnv() map[string]quota.Plan {
+	path := os.Getenv("QUOTA_PLANS")
+	if path == "" {
+		return quota.DefaultPlans()
+	}
+	plans, err := quota.LoadPlans(path)
 	if err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+		log.Fatalf("Failed to load QUOTA_PLANS: %v", err)
+	}
+	return plans
+}
+
+// actionTokenSecret reads ACTION_TOKEN_SECRET, falling back to JWT_SECRET
+func actionTokenSecret() []byte {
+	if secret := os.Getenv("ACTION_TOKEN_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	log.Printf("ACTION_TOKEN_SECRET is not set, using the insecure development secret")
+	return []byte("your-action-token-secret")
+}
+
+// mailerFromEnv sends through SMTP_ADDR when set, appends to MAIL_FILE when
+// set, and otherwise writes messages to the log
+func mailerFromEnv() mailer.Mailer {
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		return mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Addr:     addr,
+			From:     os.Getenv("SMTP_FROM"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		})
+	}
+	if path := os.Getenv("MAIL_FILE"); path != "" {
+		m, err := mailer.NewFileMailer(path)
+		if err != nil {
+			log.Fatalf("Failed to open MAIL_FILE %q: %v", path, err)
+		}
+		return m
 	}
+	return mailer.NewLogMailer()
 }
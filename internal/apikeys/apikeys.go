@@ -0,0 +1,304 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"example.com/cursorrules-golang/internal/authz"
+)
+
+// keyPrefix marks a credential as one of our API keys
+const keyPrefix = "ak_"
+
+var (
+	// ErrInvalidKey is returned for malformed, unknown or mismatched keys
+	ErrInvalidKey = errors.New("apikeys: invalid key")
+	// ErrExpiredKey is returned for keys past their expiry
+	ErrExpiredKey = errors.New("apikeys: key expired")
+	// ErrRevokedKey is returned for keys that have been revoked
+	ErrRevokedKey = errors.New("apikeys: key revoked")
+	// ErrNotFound is returned when a key does not exist or belongs to someone else
+	ErrNotFound = errors.New("apikeys: key not found")
+	// ErrOwnerNotFound is returned for keys whose owner has been deleted
+	ErrOwnerNotFound = errors.New("apikeys: key owner not found")
+)
+
+// Key represents a stored API key. The plaintext is never persisted.
+type Key struct {
+	ID         int64              `json:"id"`
+	Prefix     string             `json:"prefix"`
+	OwnerID    string             `json:"owner_id"`
+	Name       string             `json:"name"`
+	Scopes     []authz.Permission `json:"scopes"`
+	ExpiresAt  *time.Time         `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"`
+}
+
+// Store persists API keys in the api_keys table
+type Store struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewStore creates a store backed by db
+func NewStore(db *sql.DB) *Store {
+	return &Store{
+		db:  db,
+		now: time.Now,
+	}
+}
+
+// Create generates a new key and returns its plaintext alongside the stored record.
+// The plaintext cannot be recovered later.
+func (s *Store) Create(ctx context.Context, ownerID, name string, scopes []authz.Permission, expiresAt *time.Time) (string, *Key, error) {
+	prefix, err := randomHex(4)
+	if err != nil {
+		return "", nil, err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	plaintext := keyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := &Key{
+		Prefix:    prefix,
+		OwnerID:   ownerID,
+		Name:      name,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: s.now().UTC(),
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		`INSERT INTO api_keys (prefix, key_hash, owner_id, name, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		key.Prefix, hashKey(plaintext), key.OwnerID, key.Name, joinScopes(scopes), nullTime(expiresAt), key.CreatedAt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if key.ID, err = result.LastInsertId(); err != nil {
+		return "", nil, err
+	}
+	return plaintext, key, nil
+}
+
+// List returns the keys owned by ownerID, or every key when ownerID is empty
+func (s *Store) List(ctx context.Context, ownerID string) ([]Key, error) {
+	query := `SELECT id, prefix, owner_id, name, scopes, expires_at, last_used_at, revoked_at, created_at
+		FROM api_keys`
+	var args []interface{}
+	if ownerID != "" {
+		query += " WHERE owner_id = ?"
+		args = append(args, ownerID)
+	}
+	query += " ORDER BY id"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []Key{}
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke disables a key. When ownerID is non-empty the key must belong to it.
+func (s *Store) Revoke(ctx context.Context, id int64, ownerID string) error {
+	query := "UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL"
+	args := []interface{}{s.now().UTC(), id}
+	if ownerID != "" {
+		query += " AND owner_id = ?"
+		args = append(args, ownerID)
+	}
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeOwner disables every key of ownerID that is not already revoked
+func (s *Store) RevokeOwner(ctx context.Context, ownerID string) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = ? WHERE owner_id = ? AND revoked_at IS NULL",
+		s.now().UTC(), ownerID)
+	return err
+}
+
+// Authenticate looks up a plaintext key, checks that it is usable and records its use
+func (s *Store) Authenticate(ctx context.Context, plaintext string) (*Key, error) {
+	prefix, ok := parsePrefix(plaintext)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+
+	row := s.db.QueryRowContext(ctx,
+		`SELECT id, prefix, owner_id, name, scopes, expires_at, last_used_at, revoked_at, created_at, key_hash
+		FROM api_keys WHERE prefix = ?`, prefix)
+
+	var storedHash string
+	key, err := scanKey(row, &storedHash)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(storedHash), []byte(hashKey(plaintext))) != 1 {
+		return nil, ErrInvalidKey
+	}
+
+	now := s.now().UTC()
+	if key.RevokedAt != nil {
+		return nil, ErrRevokedKey
+	}
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return nil, ErrExpiredKey
+	}
+
+	if _, err := s.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, key.ID); err != nil {
+		return nil, err
+	}
+	key.LastUsedAt = &now
+	return key, nil
+}
+
+// Verify authenticates a plaintext key and returns the principal it acts as.
+// The key's scopes are limited to what its owner's role grants now, so a key
+// loses what its owner loses.
+func (s *Store) Verify(ctx context.Context, plaintext string) (*authz.Principal, error) {
+	key, err := s.Authenticate(ctx, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	var role string
+	err = s.db.QueryRowContext(ctx, "SELECT role FROM users WHERE id = ?", key.OwnerID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return nil, ErrOwnerNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	owner := authz.NewPrincipal(key.OwnerID, role)
+	permissions := []authz.Permission{}
+	for _, scope := range key.Scopes {
+		if owner.Has(scope) {
+			permissions = append(permissions, scope)
+		}
+	}
+	return &authz.Principal{
+		ID:          key.OwnerID,
+		Role:        role,
+		Permissions: permissions,
+		KeyID:       key.ID,
+	}, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanKey(row scanner, extra ...interface{}) (*Key, error) {
+	var key Key
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	dest := append([]interface{}{&key.ID, &key.Prefix, &key.OwnerID, &key.Name, &scopes,
+		&expiresAt, &lastUsedAt, &revokedAt, &key.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	key.Scopes = splitScopes(scopes)
+	key.ExpiresAt = timePtr(expiresAt)
+	key.LastUsedAt = timePtr(lastUsedAt)
+	key.RevokedAt = timePtr(revokedAt)
+	return &key, nil
+}
+
+// parsePrefix extracts the lookup prefix from "ak_<prefix>_<secret>"
+func parsePrefix(plaintext string) (string, bool) {
+	if !strings.HasPrefix(plaintext, keyPrefix) {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(plaintext[len(keyPrefix):], "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// hashKey hashes a plaintext key. Keys carry 256 bits of entropy,
+// so a fast hash is sufficient.
+func hashKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func joinScopes(scopes []authz.Permission) string {
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		names[i] = string(scope)
+	}
+	return strings.Join(names, ",")
+}
+
+func splitScopes(s string) []authz.Permission {
+	scopes := []authz.Permission{}
+	for _, name := range strings.Split(s, ",") {
+		if name != "" {
+			scopes = append(scopes, authz.Permission(name))
+		}
+	}
+	return scopes
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	v := t.Time
+	return &v
+}
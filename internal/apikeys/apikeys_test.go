@@ -0,0 +1,150 @@
+package apikeys
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/cursorrules-golang/internal/authz"
+	"example.com/cursorrules-golang/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestStore(t *testing.T) *Store {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, database.Migrate(db))
+	return NewStore(db)
+}
+
+// createOwner adds a user with the given ID and role for keys to belong to
+func createOwner(t *testing.T, store *Store, id, role string) {
+	_, err := store.db.Exec("INSERT INTO users (id, name, email, age, role) VALUES (?, ?, ?, 30, ?)",
+		id, "owner"+id, "owner"+id+"@example.com", role)
+	require.NoError(t, err)
+}
+
+func TestCreateAndVerify(t *testing.T) {
+	store := createTestStore(t)
+	ctx := context.Background()
+	createOwner(t, store, "7", authz.RoleUser)
+
+	plaintext, key, err := store.Create(ctx, "7", "batch job", []authz.Permission{authz.UsersRead}, nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(plaintext, "ak_"+key.Prefix+"_"))
+
+	principal, err := store.Verify(ctx, plaintext)
+	require.NoError(t, err)
+	assert.Equal(t, "7", principal.ID)
+	assert.Equal(t, key.ID, principal.KeyID)
+	assert.True(t, principal.Has(authz.UsersRead))
+	assert.False(t, principal.Has(authz.UsersWrite))
+
+	keys, err := store.List(ctx, "7")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.NotNil(t, keys[0].LastUsedAt)
+}
+
+func TestAuthenticateRejectsUnusableKeys(t *testing.T) {
+	store := createTestStore(t)
+	ctx := context.Background()
+	scopes := []authz.Permission{authz.UsersRead}
+
+	valid, _, err := store.Create(ctx, "1", "valid", scopes, nil)
+	require.NoError(t, err)
+
+	past := time.Now().Add(-time.Hour)
+	expired, _, err := store.Create(ctx, "1", "expired", scopes, &past)
+	require.NoError(t, err)
+
+	revoked, revokedKey, err := store.Create(ctx, "1", "revoked", scopes, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Revoke(ctx, revokedKey.ID, "1"))
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr error
+	}{
+		{name: "malformed", key: "not-a-key", wantErr: ErrInvalidKey},
+		{name: "wrong secret", key: valid[:len(valid)-4] + "AAAA", wantErr: ErrInvalidKey},
+		{name: "unknown prefix", key: "ak_00000000_secret", wantErr: ErrInvalidKey},
+		{name: "expired", key: expired, wantErr: ErrExpiredKey},
+		{name: "revoked", key: revoked, wantErr: ErrRevokedKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := store.Authenticate(ctx, tt.key)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRevokeRequiresOwnership(t *testing.T) {
+	store := createTestStore(t)
+	ctx := context.Background()
+
+	_, key, err := store.Create(ctx, "1", "mine", []authz.Permission{authz.UsersRead}, nil)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, store.Revoke(ctx, key.ID, "2"), ErrNotFound)
+	assert.NoError(t, store.Revoke(ctx, key.ID, ""))
+	assert.ErrorIs(t, store.Revoke(ctx, key.ID, ""), ErrNotFound)
+}
+
+func TestVerifyFollowsOwner(t *testing.T) {
+	store := createTestStore(t)
+	ctx := context.Background()
+	createOwner(t, store, "1", authz.RoleAdmin)
+
+	plaintext, _, err := store.Create(ctx, "1", "ops", []authz.Permission{authz.Admin, authz.UsersRead}, nil)
+	require.NoError(t, err)
+	principal, err := store.Verify(ctx, plaintext)
+	require.NoError(t, err)
+	assert.True(t, principal.Has(authz.Admin))
+	assert.Equal(t, authz.RoleAdmin, principal.Role)
+
+	// A demoted owner's key keeps only what the new role grants
+	_, err = store.db.Exec("UPDATE users SET role = ? WHERE id = 1", authz.RoleUser)
+	require.NoError(t, err)
+	principal, err = store.Verify(ctx, plaintext)
+	require.NoError(t, err)
+	assert.False(t, principal.Has(authz.Admin))
+	assert.True(t, principal.Has(authz.UsersRead))
+	assert.Equal(t, authz.RoleUser, principal.Role)
+
+	// A deleted owner's key no longer works
+	_, err = store.db.Exec("DELETE FROM users WHERE id = 1")
+	require.NoError(t, err)
+	_, err = store.Verify(ctx, plaintext)
+	assert.ErrorIs(t, err, ErrOwnerNotFound)
+}
+
+func TestRevokeOwner(t *testing.T) {
+	store := createTestStore(t)
+	ctx := context.Background()
+	scopes := []authz.Permission{authz.UsersRead}
+
+	first, _, err := store.Create(ctx, "1", "first", scopes, nil)
+	require.NoError(t, err)
+	second, _, err := store.Create(ctx, "1", "second", scopes, nil)
+	require.NoError(t, err)
+	other, _, err := store.Create(ctx, "2", "other", scopes, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, store.RevokeOwner(ctx, "1"))
+	for _, key := range []string{first, second} {
+		_, err := store.Authenticate(ctx, key)
+		assert.ErrorIs(t, err, ErrRevokedKey)
+	}
+	_, err = store.Authenticate(ctx, other)
+	assert.NoError(t, err)
+}
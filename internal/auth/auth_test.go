@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/cursorrules-golang/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordHashing(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	require.NoError(t, err)
+
+	ok, err := CheckPassword(hash, "correct horse")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = CheckPassword(hash, "wrong horse")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	other, err := HashPassword("correct horse")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "hashes must be salted")
+
+	_, err = CheckPassword("plaintext", "plaintext")
+	assert.ErrorIs(t, err, ErrMalformedHash)
+
+	ok, err = CheckPasswordOrDummy("", "anything")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestPBKDF2KnownAnswer(t *testing.T) {
+	// RFC 7914 section 11 test vector for PBKDF2-HMAC-SHA256
+	got := pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64)
+	assert.Equal(t,
+		"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"+
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		hex.EncodeToString(got))
+}
+
+func TestLockoutBackoff(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	lockout := NewLockout(LockoutConfig{Threshold: 3, BaseDelay: time.Minute, MaxDelay: 5 * time.Minute, Window: time.Hour})
+	lockout.now = func() time.Time { return now }
+
+	assert.Zero(t, lockout.Fail("a"))
+	assert.Zero(t, lockout.Fail("a"))
+	assert.Equal(t, time.Minute, lockout.Fail("a"))
+	assert.Equal(t, time.Minute, lockout.Locked("a"))
+	assert.Equal(t, 2*time.Minute, lockout.Fail("a"))
+	assert.Equal(t, 4*time.Minute, lockout.Fail("a"))
+	assert.Equal(t, 5*time.Minute, lockout.Fail("a"), "delay is capped")
+	assert.Zero(t, lockout.Locked("b"), "keys are independent")
+
+	now = now.Add(5 * time.Minute)
+	assert.Zero(t, lockout.Locked("a"), "lock expires")
+
+	lockout.Reset("a")
+	assert.Zero(t, lockout.Fail("a"), "reset forgets failures")
+}
+
+func TestLockoutForgetsAfterWindow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	lockout := NewLockout(LockoutConfig{Threshold: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 10 * time.Minute})
+	lockout.now = func() time.Time { return now }
+
+	lockout.Fail("a")
+	now = now.Add(11 * time.Minute)
+	assert.Zero(t, lockout.Fail("a"), "stale failures are not counted")
+}
+
+func TestLockoutEvictsWhenFull(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	lockout := NewLockout(LockoutConfig{Threshold: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 10 * time.Minute})
+	lockout.now = func() time.Time { return now }
+	lockout.maxEntries = 3
+
+	lockout.Fail("locked")
+	lockout.Fail("locked")
+	lockout.Fail("locked")
+	for _, key := range []string{"old", "newer", "newest", "another"} {
+		now = now.Add(time.Second)
+		lockout.Fail(key)
+	}
+
+	assert.Len(t, lockout.entries, 3, "nothing is stale, so entries are evicted")
+	assert.Positive(t, lockout.Locked("locked"), "locked entries outlive unlocked ones")
+	assert.NotContains(t, lockout.entries, "old")
+	assert.NotContains(t, lockout.entries, "newer")
+	assert.Contains(t, lockout.entries, "another")
+}
+
+func TestGuardSucceedKeepsClientFailures(t *testing.T) {
+	guard := NewGuard(
+		LockoutConfig{Threshold: 2, BaseDelay: time.Minute, MaxDelay: time.Hour},
+		LockoutConfig{Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour},
+	)
+
+	guard.Fail("alice", "10.0.0.1")
+	guard.Fail("bob", "10.0.0.1")
+	guard.Succeed("alice")
+	guard.Fail("carol", "10.0.0.1")
+
+	assert.Positive(t, guard.Locked("dave", "10.0.0.1"), "client is locked across accounts")
+	assert.Zero(t, guard.Locked("dave", "10.0.0.2"))
+
+	guard.UnlockClient("10.0.0.1")
+	assert.Zero(t, guard.Locked("dave", "10.0.0.1"))
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B uses the ASCII secret "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	code, err := TOTPCode(secret, time.Unix(59, 0))
+	require.NoError(t, err)
+	assert.Equal(t, "287082", code)
+
+	code, err = TOTPCode(secret, time.Unix(1111111109, 0))
+	require.NoError(t, err)
+	assert.Equal(t, "081804", code)
+
+	now := time.Unix(1111111109, 0)
+	step, ok := ValidateTOTP(secret, "081804", now, 0)
+	assert.True(t, ok)
+	_, ok = ValidateTOTP(secret, "081804", now.Add(30*time.Second), 0)
+	assert.True(t, ok, "previous period is accepted for clock skew")
+	_, ok = ValidateTOTP(secret, "081804", now, step)
+	assert.False(t, ok, "a used period cannot be replayed")
+	_, ok = ValidateTOTP(secret, "000000", now, 0)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Users API", "ann@example.com", "ABC")
+	assert.Equal(t, "otpauth://totp/Users%20API:ann@example.com?algorithm=SHA1&digits=6&issuer=Users+API&period=30&secret=ABC", uri)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(3)
+	require.NoError(t, err)
+	require.Len(t, codes, 3)
+	assert.Regexp(t, `^[0-9a-f]{5}-[0-9a-f]{5}$`, codes[0])
+	assert.Equal(t, HashRecoveryCode(codes[0]), HashRecoveryCode(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+}
+
+func TestActionTokens(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, database.Migrate(db))
+
+	now := time.Unix(1700000000, 0)
+	tokens := NewActionTokens(db, []byte("secret"))
+	tokens.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	token, err := tokens.Issue(ctx, PurposeVerifyEmail, 7, "user@example.com", time.Hour)
+	require.NoError(t, err)
+
+	_, err = tokens.Consume(ctx, PurposePasswordReset, token)
+	assert.ErrorIs(t, err, ErrInvalidToken, "purpose is bound into the signature")
+
+	forged := NewActionTokens(db, []byte("other-secret"))
+	_, err = forged.Consume(ctx, PurposeVerifyEmail, token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	claims, err := tokens.Consume(ctx, PurposeVerifyEmail, token)
+	require.NoError(t, err)
+	assert.Equal(t, ActionClaims{UserID: 7, Email: "user@example.com"}, claims)
+
+	_, err = tokens.Consume(ctx, PurposeVerifyEmail, token)
+	assert.ErrorIs(t, err, ErrTokenUsed)
+
+	expiring, err := tokens.Issue(ctx, PurposePasswordReset, 7, "user@example.com", time.Minute)
+	require.NoError(t, err)
+	now = now.Add(2 * time.Minute)
+	_, err = tokens.Consume(ctx, PurposePasswordReset, expiring)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	revoked, err := tokens.Issue(ctx, PurposePasswordReset, 7, "user@example.com", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, tokens.Revoke(ctx, PurposePasswordReset, 7))
+	_, err = tokens.Consume(ctx, PurposePasswordReset, revoked)
+	assert.ErrorIs(t, err, ErrTokenUsed)
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// LockoutConfig controls progressive backoff after repeated failures
+type LockoutConfig struct {
+	// Threshold is the number of failures allowed before locking
+	Threshold int
+	// BaseDelay is the first lock duration; it doubles with every further failure
+	BaseDelay time.Duration
+	// MaxDelay caps the lock duration
+	MaxDelay time.Duration
+	// Window is how long failures are remembered after the last one
+	Window time.Duration
+}
+
+// maxLockoutEntries bounds memory. Stale entries are swept when it is reached,
+// and if none are stale the least recently failed entry is evicted.
+const maxLockoutEntries = 10000
+
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Lockout tracks failures per key and locks keys out with exponential backoff
+type Lockout struct {
+	mu      sync.Mutex
+	config  LockoutConfig
+	entries map[string]*lockoutEntry
+	// maxEntries is maxLockoutEntries outside tests
+	maxEntries int
+	now        func() time.Time
+}
+
+// NewLockout creates a lockout tracker
+func NewLockout(config LockoutConfig) *Lockout {
+	if config.Threshold <= 0 {
+		config.Threshold = 5
+	}
+	if config.BaseDelay <= 0 {
+		config.BaseDelay = time.Minute
+	}
+	if config.MaxDelay < config.BaseDelay {
+		config.MaxDelay = time.Hour
+	}
+	if config.Window <= 0 {
+		config.Window = 15 * time.Minute
+	}
+
+	return &Lockout{
+		config:     config,
+		entries:    make(map[string]*lockoutEntry),
+		maxEntries: maxLockoutEntries,
+		now:        time.Now,
+	}
+}
+
+// Locked returns how long the key remains locked, or zero when it is not
+func (l *Lockout) Locked(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+	if remaining := entry.lockedUntil.Sub(l.now()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Fail records a failure and returns the lock duration it triggered, if any
+func (l *Lockout) Fail(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	entry, ok := l.entries[key]
+	if !ok || l.stale(entry, now) {
+		if len(l.entries) >= l.maxEntries {
+			l.sweep(now)
+		}
+		if len(l.entries) >= l.maxEntries {
+			l.evict(now)
+		}
+		entry = &lockoutEntry{}
+		l.entries[key] = entry
+	}
+
+	entry.failures++
+	entry.lastFailure = now
+
+	over := entry.failures - l.config.Threshold
+	if over < 0 {
+		return 0
+	}
+
+	delay := l.config.BaseDelay
+	for i := 0; i < over && delay < l.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.config.MaxDelay {
+		delay = l.config.MaxDelay
+	}
+	entry.lockedUntil = now.Add(delay)
+	return delay
+}
+
+// Reset forgets all failures for the key, unlocking it
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// stale reports whether the entry has been quiet long enough to be forgotten
+func (l *Lockout) stale(entry *lockoutEntry, now time.Time) bool {
+	return now.After(entry.lockedUntil) && now.Sub(entry.lastFailure) > l.config.Window
+}
+
+func (l *Lockout) sweep(now time.Time) {
+	for key, entry := range l.entries {
+		if l.stale(entry, now) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// evict drops the entry with the oldest failure. Unlocked entries go first so
+// that flooding the map with new keys cannot lift an active lock.
+func (l *Lockout) evict(now time.Time) {
+	var victim string
+	var oldest *lockoutEntry
+	for key, entry := range l.entries {
+		if oldest == nil || l.evictsBefore(entry, oldest, now) {
+			victim, oldest = key, entry
+		}
+	}
+	delete(l.entries, victim)
+}
+
+// evictsBefore reports whether a should be evicted before b
+func (l *Lockout) evictsBefore(a, b *lockoutEntry, now time.Time) bool {
+	aLocked, bLocked := now.Before(a.lockedUntil), now.Before(b.lockedUntil)
+	if aLocked != bLocked {
+		return bLocked
+	}
+	return a.lastFailure.Before(b.lastFailure)
+}
+
+// Guard applies lockouts to both the targeted account and the client address
+type Guard struct {
+	accounts *Lockout
+	clients  *Lockout
+}
+
+// NewGuard creates a guard. Client limits should be looser than account limits
+// because many users can share an address.
+func NewGuard(accounts, clients LockoutConfig) *Guard {
+	return &Guard{
+		accounts: NewLockout(accounts),
+		clients:  NewLockout(clients),
+	}
+}
+
+// Locked returns how long a login for the account from the client must wait
+func (g *Guard) Locked(account, clientIP string) time.Duration {
+	accountWait := g.accounts.Locked(account)
+	if clientWait := g.clients.Locked(clientIP); clientWait > accountWait {
+		return clientWait
+	}
+	return accountWait
+}
+
+// Fail records a failed login and returns the resulting lock duration, if any
+func (g *Guard) Fail(account, clientIP string) time.Duration {
+	accountWait := g.accounts.Fail(account)
+	if clientWait := g.clients.Fail(clientIP); clientWait > accountWait {
+		return clientWait
+	}
+	return accountWait
+}
+
+// Succeed clears the account's failures after a successful login. The client's
+// failures are kept so that one valid account cannot mask guessing against others.
+func (g *Guard) Succeed(account string) {
+	g.accounts.Reset(account)
+}
+
+// UnlockAccount clears an account lock
+func (g *Guard) UnlockAccount(account string) {
+	g.accounts.Reset(account)
+}
+
+// UnlockClient clears a client address lock
+func (g *Guard) UnlockClient(clientIP string) {
+	g.clients.Reset(clientIP)
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Purpose names what an action token may be used for
+type Purpose string
+
+// Action token purposes
+const (
+	PurposeVerifyEmail   Purpose = "verify_email"
+	PurposePasswordReset Purpose = "password_reset"
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed, forged or meant for another purpose
+	ErrInvalidToken = errors.New("auth: invalid token")
+	// ErrTokenExpired is returned for tokens past their expiry
+	ErrTokenExpired = errors.New("auth: token expired")
+	// ErrTokenUsed is returned for tokens that were already consumed or superseded
+	ErrTokenUsed = errors.New("auth: token already used")
+)
+
+// actionPayload is the signed content of an action token
+type actionPayload struct {
+	Purpose Purpose `json:"p"`
+	UserID  int64   `json:"u"`
+	Email   string  `json:"m"`
+	Expires int64   `json:"e"`
+	Nonce   string  `json:"n"`
+}
+
+// ActionClaims identifies the user and the address a token was sent to.
+// Callers must check that the address is still the user's before acting on
+// it, since it may have changed after the token was issued.
+type ActionClaims struct {
+	UserID int64
+	Email  string
+}
+
+// ActionTokens issues signed, expiring, single-use tokens for emailed links.
+// The signature makes tokens tamper-proof; the auth_tokens table makes them single-use.
+type ActionTokens struct {
+	db     *sql.DB
+	secret []byte
+	now    func() time.Time
+}
+
+// NewActionTokens creates an issuer signing with secret
+func NewActionTokens(db *sql.DB, secret []byte) *ActionTokens {
+	return &ActionTokens{
+		db:     db,
+		secret: secret,
+		now:    time.Now,
+	}
+}
+
+// Issue creates a token for the user valid for ttl, bound to the address it is sent to
+func (t *ActionTokens) Issue(ctx context.Context, purpose Purpose, userID int64, email string, ttl time.Duration) (string, error) {
+	nonce, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	// Expired rows can never be consumed, so drop them while we are here
+	if _, err := t.db.ExecContext(ctx, "DELETE FROM auth_tokens WHERE user_id = ? AND expires_at < ?",
+		userID, t.now().UTC()); err != nil {
+		return "", err
+	}
+
+	expires := t.now().Add(ttl).UTC()
+	if _, err := t.db.ExecContext(ctx,
+		"INSERT INTO auth_tokens (nonce, user_id, purpose, expires_at) VALUES (?, ?, ?, ?)",
+		nonce, userID, string(purpose), expires); err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(actionPayload{Purpose: purpose, UserID: userID, Email: email, Expires: expires.Unix(), Nonce: nonce})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + t.sign(encoded), nil
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Consume validates a token for purpose, marks it used and returns its claims
+func (t *ActionTokens) Consume(ctx context.Context, purpose Purpose, token string) (ActionClaims, error) {
+	return t.consume(ctx, t.db, purpose, token)
+}
+
+// ConsumeTx is Consume within tx, so that the token is only used up if the
+// change it authorizes is committed
+func (t *ActionTokens) ConsumeTx(ctx context.Context, tx *sql.Tx, purpose Purpose, token string) (ActionClaims, error) {
+	return t.consume(ctx, tx, purpose, token)
+}
+
+func (t *ActionTokens) consume(ctx context.Context, db execer, purpose Purpose, token string) (ActionClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(t.sign(encoded))) {
+		return ActionClaims{}, ErrInvalidToken
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ActionClaims{}, ErrInvalidToken
+	}
+	var payload actionPayload
+	if err := json.Unmarshal(raw, &payload); err != nil || payload.Purpose != purpose || payload.Email == "" {
+		return ActionClaims{}, ErrInvalidToken
+	}
+	if !t.now().Before(time.Unix(payload.Expires, 0)) {
+		return ActionClaims{}, ErrTokenExpired
+	}
+
+	result, err := db.ExecContext(ctx,
+		"UPDATE auth_tokens SET used_at = ? WHERE nonce = ? AND purpose = ? AND used_at IS NULL",
+		t.now().UTC(), payload.Nonce, string(purpose))
+	if err != nil {
+		return ActionClaims{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return ActionClaims{}, err
+	} else if n == 0 {
+		return ActionClaims{}, ErrTokenUsed
+	}
+	return ActionClaims{UserID: payload.UserID, Email: payload.Email}, nil
+}
+
+// Revoke invalidates every outstanding token of the purpose for the user
+func (t *ActionTokens) Revoke(ctx context.Context, purpose Purpose, userID int64) error {
+	_, err := t.db.ExecContext(ctx,
+		"UPDATE auth_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL",
+		t.now().UTC(), userID, string(purpose))
+	return err
+}
+
+func (t *ActionTokens) sign(encoded string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// randomToken returns n random bytes encoded for use in URLs
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
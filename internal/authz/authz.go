@@ -0,0 +1,93 @@
+package authz
+
+import (
+	"context"
+)
+
+// Permission represents a named capability that can be granted to a principal
+type Permission string
+
+// Known permissions
+const (
+	UsersRead  Permission = "users:read"
+	UsersWrite Permission = "users:write"
+	Admin      Permission = "admin"
+	// MFA is held only by principals that completed multi-factor authentication.
+	// It is not implied by Admin, so routes can demand it of admins too.
+	MFA Permission = "mfa"
+)
+
+// Known roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// rolePermissions maps each role to the permissions it grants
+var rolePermissions = map[string][]Permission{
+	RoleUser:  {UsersRead, UsersWrite},
+	RoleAdmin: {UsersRead, UsersWrite, Admin},
+}
+
+// PermissionsForRole returns the permissions granted by a role
+func PermissionsForRole(role string) []Permission {
+	return rolePermissions[role]
+}
+
+// Principal represents an authenticated caller, whether it presented a
+// token or an API key
+type Principal struct {
+	ID          string
+	Role        string
+	Permissions []Permission
+	// KeyID is set when the caller authenticated with an API key
+	KeyID int64
+	// MFA is set when the caller authenticated with a second factor
+	MFA bool
+}
+
+// NewPrincipal creates a principal with the permissions of its role
+func NewPrincipal(id, role string) *Principal {
+	return &Principal{
+		ID:          id,
+		Role:        role,
+		Permissions: PermissionsForRole(role),
+	}
+}
+
+// Has reports whether the principal holds the given permission.
+// The admin permission implies every other permission.
+func (p *Principal) Has(perm Permission) bool {
+	if perm == MFA {
+		return p.MFA
+	}
+	for _, granted := range p.Permissions {
+		if granted == perm || granted == Admin {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAll reports whether the principal holds every given permission
+func (p *Principal) HasAll(perms ...Permission) bool {
+	for _, perm := range perms {
+		if !p.Has(perm) {
+			return false
+		}
+	}
+	return true
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// PrincipalFromContext returns the principal stored in ctx, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok && p != nil
+}
@@ -0,0 +1,73 @@
+package authz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrincipalHas(t *testing.T) {
+	tests := []struct {
+		name string
+		role string
+		perm Permission
+		want bool
+	}{
+		{name: "user can read", role: RoleUser, perm: UsersRead, want: true},
+		{name: "user can write", role: RoleUser, perm: UsersWrite, want: true},
+		{name: "user is not admin", role: RoleUser, perm: Admin, want: false},
+		{name: "admin implies everything", role: RoleAdmin, perm: Permission("reports:read"), want: true},
+		{name: "unknown role has nothing", role: "guest", perm: UsersRead, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPrincipal("1", tt.role)
+			assert.Equal(t, tt.want, p.Has(tt.perm))
+		})
+	}
+}
+
+func TestPrincipalContext(t *testing.T) {
+	_, ok := PrincipalFromContext(context.Background())
+	assert.False(t, ok)
+
+	ctx := WithPrincipal(context.Background(), NewPrincipal("42", RoleAdmin))
+	p, ok := PrincipalFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "42", p.ID)
+	assert.True(t, p.HasAll(UsersRead, Admin))
+}
+
+func TestEngineAuthorize(t *testing.T) {
+	engine := NewEngine().Register("doc", AllowAdmin, AllowOwner(ActionRead, ActionUpdate))
+	owned := Resource{Type: "doc", ID: "7", OwnerID: "1"}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		action    Action
+		resource  Resource
+		wantErr   bool
+	}{
+		{name: "owner reads", principal: NewPrincipal("1", RoleUser), action: ActionRead, resource: owned},
+		{name: "owner updates", principal: NewPrincipal("1", RoleUser), action: ActionUpdate, resource: owned},
+		{name: "owner cannot delete", principal: NewPrincipal("1", RoleUser), action: ActionDelete, resource: owned, wantErr: true},
+		{name: "stranger cannot read", principal: NewPrincipal("2", RoleUser), action: ActionRead, resource: owned, wantErr: true},
+		{name: "admin deletes", principal: NewPrincipal("2", RoleAdmin), action: ActionDelete, resource: owned},
+		{name: "unregistered type denied", principal: NewPrincipal("2", RoleAdmin), action: ActionRead, resource: Resource{Type: "other"}, wantErr: true},
+		{name: "nil principal denied", principal: nil, action: ActionRead, resource: owned, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := engine.Authorize(tt.principal, tt.action, tt.resource)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrDenied)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
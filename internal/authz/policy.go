@@ -0,0 +1,90 @@
+package authz
+
+import (
+	"errors"
+	"sync"
+)
+
+// Action names an operation performed on a resource
+type Action string
+
+// Common actions
+const (
+	ActionCreate Action = "create"
+	ActionRead   Action = "read"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// ErrDenied is returned when no rule allows the requested action
+var ErrDenied = errors.New("authz: access denied")
+
+// Resource identifies the object a principal wants to act on
+type Resource struct {
+	Type    string
+	ID      string
+	OwnerID string
+}
+
+// Rule reports whether the principal may perform the action on the resource
+type Rule func(p *Principal, action Action, res Resource) bool
+
+// Engine evaluates rules registered per resource type.
+// Access is denied unless at least one rule allows it.
+type Engine struct {
+	mu    sync.RWMutex
+	rules map[string][]Rule
+}
+
+// NewEngine creates an engine with no rules
+func NewEngine() *Engine {
+	return &Engine{
+		rules: make(map[string][]Rule),
+	}
+}
+
+// Register adds rules for a resource type and returns the engine for chaining
+func (e *Engine) Register(resourceType string, rules ...Rule) *Engine {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules[resourceType] = append(e.rules[resourceType], rules...)
+	return e
+}
+
+// Authorize returns ErrDenied unless a rule for the resource type allows the action
+func (e *Engine) Authorize(p *Principal, action Action, res Resource) error {
+	if p == nil {
+		return ErrDenied
+	}
+
+	e.mu.RLock()
+	rules := e.rules[res.Type]
+	e.mu.RUnlock()
+
+	for _, rule := range rules {
+		if rule(p, action, res) {
+			return nil
+		}
+	}
+	return ErrDenied
+}
+
+// AllowAdmin allows any action to principals holding the admin permission
+func AllowAdmin(p *Principal, _ Action, _ Resource) bool {
+	return p.Has(Admin)
+}
+
+// AllowOwner allows the listed actions when the principal owns the resource
+func AllowOwner(actions ...Action) Rule {
+	return func(p *Principal, action Action, res Resource) bool {
+		if p.ID == "" || p.ID != res.OwnerID {
+			return false
+		}
+		for _, allowed := range actions {
+			if allowed == action {
+				return true
+			}
+		}
+		return false
+	}
+}
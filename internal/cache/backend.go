@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Backend stores cached values for a Shared cache. Implementations may keep
+// them in process or in a store shared by every replica.
+type Backend[V any] interface {
+	// Get returns the value stored under key, reporting false if there is none
+	Get(ctx context.Context, key string) (V, bool, error)
+	// TagVersions returns the current versions of tags, to be taken before
+	// loading a value and passed to Set
+	TagVersions(ctx context.Context, tags ...string) (TagVersions, error)
+	// Set stores value under key for ttl, tagged with every tag in versions,
+	// unless one of those tags has been invalidated since versions were
+	// taken. It reports whether the value was stored.
+	Set(ctx context.Context, key string, value V, ttl time.Duration, versions TagVersions) (bool, error)
+	// Delete removes key
+	Delete(ctx context.Context, key string) error
+	// InvalidateTag removes every value tagged with tag and returns how many
+	// were removed
+	InvalidateTag(ctx context.Context, tag string) (int, error)
+}
+
+// Codec serializes values for backends that store bytes
+type Codec[V any] interface {
+	Marshal(value V) ([]byte, error)
+	Unmarshal(data []byte, value *V) error
+}
+
+// JSONCodec encodes values as JSON
+type JSONCodec[V any] struct{}
+
+// Marshal encodes value as JSON
+func (JSONCodec[V]) Marshal(value V) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// Unmarshal decodes JSON into value
+func (JSONCodec[V]) Unmarshal(data []byte, value *V) error {
+	return json.Unmarshal(data, value)
+}
+
+// Local is a Backend keeping values in an in-process cache, for running a
+// Shared cache without a server, e.g. in tests. Values are not shared
+// between replicas.
+type Local[V any] struct {
+	cache *TypedCache[string, V]
+}
+
+// NewLocal creates a backend storing values in c
+func NewLocal[V any](c *TypedCache[string, V]) *Local[V] {
+	return &Local[V]{cache: c}
+}
+
+// Get returns the value stored under key
+func (l *Local[V]) Get(_ context.Context, key string) (V, bool, error) {
+	value, ok := l.cache.Get(key)
+	return value, ok, nil
+}
+
+// TagVersions returns the current versions of tags
+func (l *Local[V]) TagVersions(_ context.Context, tags ...string) (TagVersions, error) {
+	return l.cache.TagVersions(tags...), nil
+}
+
+// Set stores value under key like TypedCache.SetTagged
+func (l *Local[V]) Set(_ context.Context, key string, value V, ttl time.Duration, versions TagVersions) (bool, error) {
+	return l.cache.SetTagged(key, value, ttl, versions), nil
+}
+
+// Delete removes key
+func (l *Local[V]) Delete(_ context.Context, key string) error {
+	l.cache.Delete(key)
+	return nil
+}
+
+// InvalidateTag removes every value tagged with tag
+func (l *Local[V]) InvalidateTag(_ context.Context, tag string) (int, error) {
+	return l.cache.InvalidateTag(tag), nil
+}
+
+// GetStats returns the statistics of the underlying cache
+func (l *Local[V]) GetStats() map[string]interface{} {
+	return l.cache.GetStats()
+}
+
+// Close closes the underlying cache
+func (l *Local[V]) Close() error {
+	return l.cache.Close()
+}
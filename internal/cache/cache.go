@@ -1,150 +1,345 @@
 package cache
 
 import (
-	"math"
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
 	"sync"
 	"time"
 )
 
 // Item represents a cached item
-type Item struct {
-	Value      interface{}
+type Item[V any] struct {
+	Value      V
 	Expiration int64
 	LastAccess int64
 }
 
-// Cache represents an in-memory cache with LRU eviction
-type Cache struct {
-	items     map[string]Item
-	mu        sync.RWMutex
-	maxItems  int
-	hitCount  uint64
-	missCount uint64
+// SizeFunc estimates the memory an item takes, in bytes
+type SizeFunc[K comparable, V any] func(key K, value V) int64
+
+// TagVersions records how many times each tag had been invalidated when it
+// was taken. SetTagged uses it to refuse values loaded before an invalidation.
+type TagVersions map[string]uint64
+
+// TypedCache represents an in-memory cache with LRU eviction. Keys are
+// spread over shards by hash, and each shard keeps its items in a list
+// ordered from most to least recently used, so Get, Set and eviction are
+// O(1) and only contend with operations on the same shard. With more than
+// one shard, eviction is least recently used within a shard.
+//
+// Besides MaxItems, a cache may be bounded by MaxBytes as measured by the
+// function set with WithSize, and may refuse new items that are used less
+// often than those they would evict (see Config.Admission).
+//
+// Items may be tagged with the data they were derived from, so that a write
+// can drop every item it makes stale with InvalidateTag.
+//
+// A cache runs a cleanup goroutine until Close is called. After Close, Set
+// and Delete do nothing and Get always misses.
+type TypedCache[K comparable, V any] struct {
+	shards     []*shard[K, V]
+	seed       maphash.Seed
+	hashed     bool
+	maxItems   int
+	maxBytes   int64
+	versionsMu sync.RWMutex
+	versions   map[string]uint64
+	closeOnce  sync.Once
+	stop       chan struct{}
+	done       chan struct{}
 }
 
+// Cache is the untyped cache used by existing callers
+type Cache = TypedCache[string, interface{}]
+
 // Config represents cache configuration
 type Config struct {
 	MaxItems        int
 	CleanupInterval time.Duration
+	// Shards is the number of independently locked shards MaxItems is split
+	// over. One shard, the default, keeps eviction in exact LRU order.
+	Shards int
+	// MaxBytes bounds the total size of the items, as measured by the
+	// function set with WithSize. Zero means no byte budget.
+	MaxBytes int64
+	// Admission enables a TinyLFU admission policy: once the cache is full, a
+	// new item is only stored if it has been accessed more often than the
+	// items it would evict. Frequencies are estimated in a count-min sketch
+	// that is periodically aged.
+	Admission bool
 }
 
-// New creates a new cache instance with configuration
+// New creates a new untyped cache instance with configuration
 func New(config Config) *Cache {
+	return NewTyped[string, interface{}](config)
+}
+
+// NewTyped creates a new cache instance with configuration
+func NewTyped[K comparable, V any](config Config) *TypedCache[K, V] {
 	if config.MaxItems <= 0 {
 		config.MaxItems = 1000 // default size
 	}
 	if config.CleanupInterval <= 0 {
 		config.CleanupInterval = time.Minute
 	}
+	if config.Shards <= 0 {
+		config.Shards = 1
+	}
+	if config.Shards > config.MaxItems {
+		config.Shards = config.MaxItems
+	}
 
-	cache := &Cache{
-		items:    make(map[string]Item),
+	cache := &TypedCache[K, V]{
+		shards:   make([]*shard[K, V], config.Shards),
+		seed:     maphash.MakeSeed(),
+		hashed:   config.Shards > 1 || config.Admission,
 		maxItems: config.MaxItems,
+		maxBytes: config.MaxBytes,
+		versions: make(map[string]uint64),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	// The first shards take the remainders, so that the shards add up to
+	// MaxItems and MaxBytes exactly
+	shards := int64(config.Shards)
+	for i := range cache.shards {
+		maxItems := config.MaxItems / config.Shards
+		if i < config.MaxItems%config.Shards {
+			maxItems++
+		}
+		maxBytes := config.MaxBytes / shards
+		if int64(i) < config.MaxBytes%shards {
+			maxBytes++
+		}
+		cache.shards[i] = newShard[K, V](maxItems, maxBytes, config.Admission)
 	}
 
 	go cache.cleanup(config.CleanupInterval)
 	return cache
 }
 
-// Set adds an item to the cache
-func (c *Cache) Set(key string, value interface{}, duration time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	// Check if we need to evict items
-	if len(c.items) >= c.maxItems {
-		c.evictLRU()
+// WithSize sets the function measuring items against MaxBytes. Call it
+// before the cache is used.
+func (c *TypedCache[K, V]) WithSize(size SizeFunc[K, V]) *TypedCache[K, V] {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.size = size
+		s.mu.Unlock()
 	}
+	return c
+}
+
+// Close stops the cleanup goroutine, waits for it to exit and drops every
+// item. It is safe to call more than once.
+func (c *TypedCache[K, V]) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+
+		for _, s := range c.shards {
+			s.mu.Lock()
+			s.closed = true
+			s.clear()
+			s.mu.Unlock()
+		}
+	})
+	return nil
+}
 
-	c.items[key] = Item{
-		Value:      value,
-		Expiration: time.Now().Add(duration).UnixNano(),
-		LastAccess: time.Now().UnixNano(),
+// Set adds an item to the cache. With a byte budget or admission, the item
+// may be refused.
+func (c *TypedCache[K, V]) Set(key K, value V, duration time.Duration) {
+	s, hash := c.locate(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(key, hash, value, duration, 0, nil)
+}
+
+// TagVersions returns the current versions of tags. Take them before loading
+// a value and pass them to SetTagged.
+func (c *TypedCache[K, V]) TagVersions(tags ...string) TagVersions {
+	c.versionsMu.RLock()
+	defer c.versionsMu.RUnlock()
+
+	versions := make(TagVersions, len(tags))
+	for _, tag := range tags {
+		versions[tag] = c.versions[tag]
 	}
+	return versions
 }
 
-// Get retrieves an item from the cache
-func (c *Cache) Get(key string) (interface{}, bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// SetTagged adds an item tagged with every tag in versions. The item is not
+// stored if any of those tags has been invalidated since versions were
+// taken, because the value may have been loaded from data that has since
+// changed, or if it is refused like in Set. It reports whether the item was
+// stored.
+func (c *TypedCache[K, V]) SetTagged(key K, value V, duration time.Duration, versions TagVersions) bool {
+	s, hash := c.locate(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	item, exists := c.items[key]
-	if !exists {
-		c.missCount++
-		return nil, false
+	if !c.current(versions) {
+		return false
+	}
+	tags := make([]string, 0, len(versions))
+	for tag := range versions {
+		tags = append(tags, tag)
 	}
+	return s.set(key, hash, value, duration, 0, tags)
+}
+
+// InvalidateTag removes every item tagged with tag and returns how many were
+// removed. Values loaded before the call can no longer be stored with
+// SetTagged.
+func (c *TypedCache[K, V]) InvalidateTag(tag string) int {
+	// The version is bumped before any shard is swept. A SetTagged that saw
+	// the old version holds its shard's lock until it has stored, so the
+	// sweep below still finds its item.
+	c.versionsMu.Lock()
+	c.versions[tag]++
+	c.versionsMu.Unlock()
 
-	if time.Now().UnixNano() > item.Expiration {
-		delete(c.items, key)
-		c.missCount++
-		return nil, false
+	n := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		n += s.invalidate(tag)
+		s.mu.Unlock()
 	}
+	return n
+}
 
-	// Update last access time
-	item.LastAccess = time.Now().UnixNano()
-	c.items[key] = item
-	c.hitCount++
+// current reports whether none of the tags in versions has been invalidated
+// since they were taken
+func (c *TypedCache[K, V]) current(versions TagVersions) bool {
+	c.versionsMu.RLock()
+	defer c.versionsMu.RUnlock()
+
+	for tag, version := range versions {
+		if c.versions[tag] != version {
+			return false
+		}
+	}
+	return true
+}
 
-	return item.Value, true
+// Get retrieves an item from the cache
+func (c *TypedCache[K, V]) Get(key K) (V, bool) {
+	s, hash := c.locate(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(key, hash)
 }
 
 // Delete removes an item from the cache
-func (c *Cache) Delete(key string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	delete(c.items, key)
+func (c *TypedCache[K, V]) Delete(key K) {
+	s, _ := c.locate(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, exists := s.items[key]; exists {
+		s.removeElement(element, evictNone)
+	}
 }
 
-// GetStats returns cache statistics
-func (c *Cache) GetStats() map[string]interface{} {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+// GetStats returns cache statistics summed over every shard
+func (c *TypedCache[K, V]) GetStats() map[string]interface{} {
+	var size int
+	var bytes int64
+	var total shardStats
+	for _, s := range c.shards {
+		s.mu.Lock()
+		size += len(s.items)
+		bytes += s.bytes
+		total.add(s.stats)
+		s.mu.Unlock()
+	}
 
-	total := float64(c.hitCount + c.missCount)
+	lookups := float64(total.hits + total.misses)
 	hitRate := float64(0)
-	if total > 0 {
-		hitRate = float64(c.hitCount) / total
+	if lookups > 0 {
+		hitRate = float64(total.hits) / lookups
+	}
+
+	evictions := make(map[string]uint64, len(evictReasonNames))
+	for reason, name := range evictReasonNames {
+		if name != "" {
+			evictions[name] = total.evictions[reason]
+		}
 	}
 
 	return map[string]interface{}{
-		"size":       len(c.items),
+		"size":       size,
 		"max_size":   c.maxItems,
-		"hit_count":  c.hitCount,
-		"miss_count": c.missCount,
+		"bytes_used": bytes,
+		"max_bytes":  c.maxBytes,
+		"shards":     len(c.shards),
+		"hit_count":  total.hits,
+		"miss_count": total.misses,
 		"hit_rate":   hitRate,
+		// Loads run by GetOrLoad, callers that waited on another caller's
+		// load, stale values served while refreshing and failed loads
+		"loads":           total.loads,
+		"coalesced_loads": total.coalesced,
+		"stale_hits":      total.staleHits,
+		"load_errors":     total.loadErrs,
+		// Items removed by reason, and new items refused by the byte budget
+		// or the admission policy
+		"evictions":            evictions,
+		"admission_rejections": total.rejected,
 	}
 }
 
 // cleanup removes expired items from the cache
-func (c *Cache) cleanup(interval time.Duration) {
+func (c *TypedCache[K, V]) cleanup(interval time.Duration) {
+	defer close(c.done)
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		c.mu.Lock()
-		now := time.Now().UnixNano()
-		for key, item := range c.items {
-			if now > item.Expiration {
-				delete(c.items, key)
-			}
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.stop:
+			return
+		}
+
+		// Shards are swept one at a time so that readers of the others carry on
+		for _, s := range c.shards {
+			s.mu.Lock()
+			s.sweep(time.Now().UnixNano())
+			s.mu.Unlock()
 		}
-		c.mu.Unlock()
 	}
 }
 
-// evictLRU removes the least recently used item
-func (c *Cache) evictLRU() {
-	var oldestKey string
-	var oldestAccess int64 = math.MaxInt64
-
-	for key, item := range c.items {
-		if item.LastAccess < oldestAccess {
-			oldestAccess = item.LastAccess
-			oldestKey = key
-		}
+// locate returns the shard holding key and the key's hash. Keys are only
+// hashed when there are several shards or admission needs their frequency.
+func (c *TypedCache[K, V]) locate(key K) (*shard[K, V], uint64) {
+	if !c.hashed {
+		return c.shards[0], 0
 	}
+	hash := hashKey(c.seed, key)
+	return c.shards[hash%uint64(len(c.shards))], hash
+}
 
-	if oldestKey != "" {
-		delete(c.items, oldestKey)
+// hashKey hashes string and integer keys directly and any other key by its
+// printed form
+func hashKey[K comparable](seed maphash.Seed, key K) uint64 {
+	switch k := any(key).(type) {
+	case string:
+		return maphash.String(seed, k)
+	case int:
+		return hashUint64(seed, uint64(k))
+	case int64:
+		return hashUint64(seed, uint64(k))
+	case uint64:
+		return hashUint64(seed, k)
+	default:
+		return maphash.String(seed, fmt.Sprint(key))
 	}
 }
+
+func hashUint64(seed maphash.Seed, k uint64) uint64 {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], k)
+	return maphash.Bytes(seed, b[:])
+}
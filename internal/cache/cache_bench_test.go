@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// legacyCache is the map-scanning implementation the cache replaced, kept
+// to benchmark against
+type legacyCache struct {
+	items     map[string]Item[interface{}]
+	mu        sync.RWMutex
+	maxItems  int
+	hitCount  uint64
+	missCount uint64
+}
+
+func newLegacy(config Config) *legacyCache {
+	return &legacyCache{
+		items:    make(map[string]Item[interface{}]),
+		maxItems: config.MaxItems,
+	}
+}
+
+// Set adds an item to the cache
+func (c *legacyCache) Set(key string, value interface{}, duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Check if we need to evict items
+	if len(c.items) >= c.maxItems {
+		c.evictLRU()
+	}
+
+	c.items[key] = Item[interface{}]{
+		Value:      value,
+		Expiration: time.Now().Add(duration).UnixNano(),
+		LastAccess: time.Now().UnixNano(),
+	}
+}
+
+// Get retrieves an item from the cache
+func (c *legacyCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, exists := c.items[key]
+	if !exists {
+		c.missCount++
+		return nil, false
+	}
+
+	if time.Now().UnixNano() > item.Expiration {
+		delete(c.items, key)
+		c.missCount++
+		return nil, false
+	}
+
+	// Update last access time
+	item.LastAccess = time.Now().UnixNano()
+	c.items[key] = item
+	c.hitCount++
+
+	return item.Value, true
+}
+
+// evictLRU removes the least recently used item
+func (c *legacyCache) evictLRU() {
+	var oldestKey string
+	var oldestAccess int64 = math.MaxInt64
+
+	for key, item := range c.items {
+		if item.LastAccess < oldestAccess {
+			oldestAccess = item.LastAccess
+			oldestKey = key
+		}
+	}
+
+	if oldestKey != "" {
+		delete(c.items, oldestKey)
+	}
+}
+
+const benchmarkItems = 10000
+
+type benchmarkCache interface {
+	Set(key string, value interface{}, duration time.Duration)
+	Get(key string) (interface{}, bool)
+}
+
+func benchmarkKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+// benchmarkSetFull inserts new keys into a full cache, evicting on every Set
+func benchmarkSetFull(b *testing.B, c benchmarkCache) {
+	keys := benchmarkKeys(2 * benchmarkItems)
+	for _, key := range keys[:benchmarkItems] {
+		c.Set(key, key, time.Hour)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := keys[i%len(keys)]
+		c.Set(key, key, time.Hour)
+	}
+}
+
+func benchmarkGet(b *testing.B, c benchmarkCache) {
+	keys := benchmarkKeys(benchmarkItems)
+	for _, key := range keys {
+		c.Set(key, key, time.Hour)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkSetFull(b *testing.B) {
+	c := New(Config{MaxItems: benchmarkItems})
+	defer c.Close()
+	benchmarkSetFull(b, c)
+}
+
+func BenchmarkSetFullLegacy(b *testing.B) {
+	benchmarkSetFull(b, newLegacy(Config{MaxItems: benchmarkItems}))
+}
+
+func BenchmarkGet(b *testing.B) {
+	c := New(Config{MaxItems: benchmarkItems})
+	defer c.Close()
+	benchmarkGet(b, c)
+}
+
+func BenchmarkGetLegacy(b *testing.B) {
+	benchmarkGet(b, newLegacy(Config{MaxItems: benchmarkItems}))
+}
+
+// benchmarkGetParallel reads a full cache from every P at once
+func benchmarkGetParallel(b *testing.B, shards int) {
+	c := New(Config{MaxItems: benchmarkItems, Shards: shards})
+	defer c.Close()
+	keys := benchmarkKeys(benchmarkItems)
+	for _, key := range keys {
+		c.Set(key, key, time.Hour)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Get(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+// benchmarkMixedParallel reads and writes from every P at once, one write in ten
+func benchmarkMixedParallel(b *testing.B, shards int) {
+	c := New(Config{MaxItems: benchmarkItems, Shards: shards})
+	defer c.Close()
+	keys := benchmarkKeys(2 * benchmarkItems)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if i%10 == 0 {
+				c.Set(key, key, time.Hour)
+			} else {
+				c.Get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkGetParallel(b *testing.B) {
+	for _, shards := range []int{1, 16, 64} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) { benchmarkGetParallel(b, shards) })
+	}
+}
+
+func BenchmarkMixedParallel(b *testing.B) {
+	for _, shards := range []int{1, 16, 64} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) { benchmarkMixedParallel(b, shards) })
+	}
+}
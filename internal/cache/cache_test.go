@@ -2,6 +2,9 @@ package cache
 
 import (
 	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -36,6 +39,7 @@ func TestCacheOperations(t *testing.T) {
 				MaxItems:        100,
 				CleanupInterval: time.Minute,
 			})
+			defer cache.Close()
 
 			// Test Set
 			cache.Set(tt.key, tt.value, tt.ttl)
@@ -65,6 +69,7 @@ func TestCacheEviction(t *testing.T) {
 		MaxItems:        maxItems,
 		CleanupInterval: time.Minute,
 	})
+	defer cache.Close()
 
 	// Test cache eviction policy
 	for i := 0; i < maxItems+3; i++ {
@@ -84,6 +89,7 @@ func TestCacheStats(t *testing.T) {
 		MaxItems:        100,
 		CleanupInterval: time.Minute,
 	})
+	defer cache.Close()
 
 	// Add some items and perform operations
 	cache.Set("key1", "value1", time.Minute)
@@ -104,4 +110,325 @@ func TestCacheStats(t *testing.T) {
 	}
 }
 
-// ... additional test cases for cache optimization strategies ...
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := New(Config{MaxItems: 3, CleanupInterval: time.Minute})
+	defer cache.Close()
+
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, time.Minute)
+	cache.Set("c", 3, time.Minute)
+
+	// Touching "a" makes "b" the least recently used
+	cache.Get("a")
+	cache.Set("d", 4, time.Minute)
+
+	if _, exists := cache.Get("b"); exists {
+		t.Errorf("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, exists := cache.Get(key); !exists {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+}
+
+func TestCacheOverwriteDoesNotEvict(t *testing.T) {
+	cache := New(Config{MaxItems: 2, CleanupInterval: time.Minute})
+	defer cache.Close()
+
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, time.Minute)
+	cache.Set("a", 10, time.Minute)
+
+	if got, _ := cache.Get("a"); got != 10 {
+		t.Errorf("Get(a) = %v, want 10", got)
+	}
+	if _, exists := cache.Get("b"); !exists {
+		t.Errorf("overwriting a should not evict b")
+	}
+	if size := cache.GetStats()["size"].(int); size != 2 {
+		t.Errorf("size = %d, want 2", size)
+	}
+}
+
+func TestCacheDelete(t *testing.T) {
+	cache := New(Config{MaxItems: 2, CleanupInterval: time.Minute})
+	defer cache.Close()
+
+	cache.Set("a", 1, time.Minute)
+	cache.Delete("a")
+	cache.Delete("missing")
+
+	if _, exists := cache.Get("a"); exists {
+		t.Errorf("expected a to be deleted")
+	}
+	if size := cache.GetStats()["size"].(int); size != 0 {
+		t.Errorf("size = %d, want 0", size)
+	}
+}
+
+func TestTypedCache(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+	cache := NewTyped[int, user](Config{MaxItems: 2, CleanupInterval: time.Minute})
+	defer cache.Close()
+
+	cache.Set(1, user{Name: "alice", Age: 30}, time.Minute)
+	got, exists := cache.Get(1)
+	if !exists || got.Name != "alice" || got.Age != 30 {
+		t.Errorf("Get(1) = %+v, %v", got, exists)
+	}
+
+	got, exists = cache.Get(2)
+	if exists || got != (user{}) {
+		t.Errorf("Get(2) = %+v, %v, want zero value and false", got, exists)
+	}
+}
+
+func TestCacheClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	cache := New(Config{MaxItems: 10, CleanupInterval: time.Millisecond})
+	cache.Set("a", 1, time.Minute)
+
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+
+	// Close waits for the cleanup goroutine, so none is left behind
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("goroutines = %d after Close, want at most %d", after, before)
+	}
+
+	// Operations after Close are no-ops that never panic
+	cache.Set("b", 2, time.Minute)
+	cache.Delete("a")
+	if _, exists := cache.Get("a"); exists {
+		t.Errorf("Get after Close should miss")
+	}
+	if _, exists := cache.Get("b"); exists {
+		t.Errorf("Set after Close should not store")
+	}
+	if size := cache.GetStats()["size"].(int); size != 0 {
+		t.Errorf("size after Close = %d, want 0", size)
+	}
+}
+
+func TestCacheInvalidateTag(t *testing.T) {
+	cache := New(Config{MaxItems: 10})
+	defer cache.Close()
+
+	cache.SetTagged("users:1", 1, time.Minute, cache.TagVersions("users"))
+	cache.SetTagged("users:2", 2, time.Minute, cache.TagVersions("users", "admins"))
+	cache.SetTagged("posts:1", 3, time.Minute, cache.TagVersions("posts"))
+	cache.Set("plain", 4, time.Minute)
+
+	if n := cache.InvalidateTag("users"); n != 2 {
+		t.Errorf("InvalidateTag(users) = %d, want 2", n)
+	}
+	for _, key := range []string{"users:1", "users:2"} {
+		if _, exists := cache.Get(key); exists {
+			t.Errorf("%s should have been invalidated", key)
+		}
+	}
+	for _, key := range []string{"posts:1", "plain"} {
+		if _, exists := cache.Get(key); !exists {
+			t.Errorf("%s should still be cached", key)
+		}
+	}
+
+	// Removed items no longer count towards their other tags
+	if n := cache.InvalidateTag("admins"); n != 0 {
+		t.Errorf("InvalidateTag(admins) = %d, want 0", n)
+	}
+
+	// Overwriting an item replaces its tags
+	cache.Set("posts:1", 5, time.Minute)
+	if n := cache.InvalidateTag("posts"); n != 0 {
+		t.Errorf("InvalidateTag(posts) after untagged Set = %d, want 0", n)
+	}
+}
+
+func TestCacheSetTaggedAfterInvalidation(t *testing.T) {
+	cache := New(Config{MaxItems: 10})
+	defer cache.Close()
+
+	// A value loaded before a write must not be cached after it
+	versions := cache.TagVersions("users")
+	cache.InvalidateTag("users")
+	if cache.SetTagged("stale", 1, time.Minute, versions) {
+		t.Errorf("SetTagged with stale versions should not store")
+	}
+	if _, exists := cache.Get("stale"); exists {
+		t.Errorf("stale value should not be cached")
+	}
+
+	if !cache.SetTagged("fresh", 2, time.Minute, cache.TagVersions("users")) {
+		t.Errorf("SetTagged with current versions should store")
+	}
+	if _, exists := cache.Get("fresh"); !exists {
+		t.Errorf("fresh value should be cached")
+	}
+}
+
+func TestShardedCache(t *testing.T) {
+	cache := NewTyped[int, int](Config{MaxItems: 100, Shards: 8})
+	defer cache.Close()
+
+	for i := 0; i < 1000; i++ {
+		cache.Set(i, i, time.Minute)
+	}
+
+	// The shards' capacities add up to MaxItems
+	stats := cache.GetStats()
+	if size := stats["size"].(int); size != 100 {
+		t.Errorf("size = %d, want 100", size)
+	}
+	if shards := stats["shards"].(int); shards != 8 {
+		t.Errorf("shards = %d, want 8", shards)
+	}
+
+	// Stats are summed over every shard
+	hits := 0
+	for i := 0; i < 1000; i++ {
+		if value, exists := cache.Get(i); exists {
+			if value != i {
+				t.Errorf("Get(%d) = %d", i, value)
+			}
+			hits++
+		}
+	}
+	stats = cache.GetStats()
+	if n := stats["hit_count"].(uint64); n != uint64(hits) {
+		t.Errorf("hit_count = %d, want %d", n, hits)
+	}
+	if n := stats["miss_count"].(uint64); n != uint64(1000-hits) {
+		t.Errorf("miss_count = %d, want %d", n, 1000-hits)
+	}
+
+}
+
+func TestShardedCacheInvalidateTag(t *testing.T) {
+	cache := NewTyped[int, int](Config{MaxItems: 1000, Shards: 8})
+	defer cache.Close()
+
+	// Tags are invalidated in every shard
+	for i := 0; i < 50; i++ {
+		cache.SetTagged(i, i, time.Minute, cache.TagVersions("low"))
+	}
+	cache.Set(50, 50, time.Minute)
+	if n := cache.InvalidateTag("low"); n != 50 {
+		t.Errorf("InvalidateTag(low) = %d, want 50", n)
+	}
+	if size := cache.GetStats()["size"].(int); size != 1 {
+		t.Errorf("size = %d, want 1", size)
+	}
+}
+
+func TestShardsCappedByMaxItems(t *testing.T) {
+	cache := New(Config{MaxItems: 3, Shards: 16})
+	defer cache.Close()
+
+	if shards := cache.GetStats()["shards"].(int); shards != 3 {
+		t.Errorf("shards = %d, want 3", shards)
+	}
+}
+
+func TestCacheMaxBytes(t *testing.T) {
+	cache := New(Config{MaxItems: 100, MaxBytes: 100}).
+		WithSize(func(_ string, value interface{}) int64 { return int64(len(value.(string))) })
+	defer cache.Close()
+
+	cache.Set("a", strings.Repeat("a", 40), time.Minute)
+	cache.Set("b", strings.Repeat("b", 40), time.Minute)
+	cache.Set("c", strings.Repeat("c", 40), time.Minute)
+
+	// The least recently used item made room, although MaxItems was not reached
+	if _, exists := cache.Get("a"); exists {
+		t.Errorf("a should have been evicted to stay under MaxBytes")
+	}
+	stats := cache.GetStats()
+	if bytes := stats["bytes_used"].(int64); bytes != 80 {
+		t.Errorf("bytes_used = %d, want 80", bytes)
+	}
+	if n := stats["evictions"].(map[string]uint64)["bytes"]; n != 1 {
+		t.Errorf("evictions[bytes] = %d, want 1", n)
+	}
+
+	// An item larger than the whole budget is refused
+	cache.Set("huge", strings.Repeat("h", 101), time.Minute)
+	if _, exists := cache.Get("huge"); exists {
+		t.Errorf("item larger than MaxBytes should not be stored")
+	}
+
+	// Growing an item evicts others, never the item itself
+	cache.Set("b", strings.Repeat("b", 90), time.Minute)
+	if _, exists := cache.Get("b"); !exists {
+		t.Errorf("b should be stored")
+	}
+	if bytes := cache.GetStats()["bytes_used"].(int64); bytes != 90 {
+		t.Errorf("bytes_used = %d, want 90", bytes)
+	}
+}
+
+func TestCacheAdmissionProtectsHotKeys(t *testing.T) {
+	cache := New(Config{MaxItems: 10, Admission: true})
+	defer cache.Close()
+
+	hot := benchmarkKeys(10)
+	for _, key := range hot {
+		cache.Set(key, key, time.Minute)
+		for i := 0; i < 10; i++ {
+			cache.Get(key)
+		}
+	}
+
+	// A scan of keys read once does not flush the hot keys
+	for i := 0; i < 100; i++ {
+		cache.Set("scan-"+strconv.Itoa(i), i, time.Minute)
+	}
+	for _, key := range hot {
+		if _, exists := cache.Get(key); !exists {
+			t.Errorf("hot key %s was evicted by a scan", key)
+		}
+	}
+	if n := cache.GetStats()["admission_rejections"].(uint64); n != 100 {
+		t.Errorf("admission_rejections = %d, want 100", n)
+	}
+
+	// A key that becomes popular is admitted
+	for i := 0; i < 20; i++ {
+		cache.Get("new-hot")
+	}
+	cache.Set("new-hot", 1, time.Minute)
+	if _, exists := cache.Get("new-hot"); !exists {
+		t.Errorf("frequently requested key should be admitted")
+	}
+}
+
+func TestCacheEvictionReasons(t *testing.T) {
+	cache := New(Config{MaxItems: 2})
+	defer cache.Close()
+
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, time.Minute)
+	cache.Set("c", 3, time.Minute)
+	cache.Set("expired", 4, -time.Second)
+	cache.Get("expired")
+	cache.SetTagged("tagged", 5, time.Minute, cache.TagVersions("t"))
+	cache.InvalidateTag("t")
+	cache.Set("deleted", 6, time.Minute)
+	cache.Delete("deleted")
+
+	evictions := cache.GetStats()["evictions"].(map[string]uint64)
+	want := map[string]uint64{"capacity": 2, "bytes": 0, "expired": 1, "invalidated": 1}
+	for reason, n := range want {
+		if evictions[reason] != n {
+			t.Errorf("evictions[%s] = %d, want %d", reason, evictions[reason], n)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LoadFunc loads a value missing from the cache
+type LoadFunc[V any] func(ctx context.Context) (V, error)
+
+// LoadOptions controls how GetOrLoad caches loaded values
+type LoadOptions[V any] struct {
+	// TTL is how long a loaded value is fresh
+	TTL time.Duration
+	// StaleTTL is how long after expiring a value is still served while a
+	// single background load refreshes it. Expired values are never served
+	// when it is zero.
+	StaleTTL time.Duration
+	// NegativeTTL replaces TTL for values IsEmpty reports as empty, so that
+	// lookups of missing data are cached for less time than real results
+	NegativeTTL time.Duration
+	IsEmpty     func(V) bool
+	// Tags are attached to loaded values. A load racing an InvalidateTag of
+	// one of them is returned to its callers but not cached.
+	Tags []string
+}
+
+// call is a load in progress. val and err are set before done is closed.
+type call[V any] struct {
+	done     chan struct{}
+	val      V
+	err      error
+	versions TagVersions
+	tags     []string
+}
+
+// GetOrLoad returns the cached value for key, calling load when it is
+// missing. Concurrent callers missing the same key share a single load.
+// Load errors, including a panicking load, are returned to every waiting
+// caller and are not cached.
+//
+// The load runs detached from the cancellation of ctx, so that a caller
+// giving up does not fail the others waiting on it. A caller whose ctx is
+// done stops waiting and gets ctx's error.
+func (c *TypedCache[K, V]) GetOrLoad(ctx context.Context, key K, load LoadFunc[V], opts LoadOptions[V]) (V, error) {
+	s, hash := c.locate(key)
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return load(ctx)
+	}
+
+	s.record(hash)
+	now := time.Now().UnixNano()
+	if element, exists := s.items[key]; exists {
+		e := element.Value.(*entry[K, V])
+		if now <= e.staleUntil {
+			e.item.LastAccess = now
+			s.lru.MoveToFront(element)
+			s.stats.hits++
+			if now > e.item.Expiration {
+				// Serve the stale value and refresh it in the background
+				s.stats.staleHits++
+				if cl, loading := s.calls[key]; !loading || !c.current(cl.versions) {
+					c.startLoad(ctx, s, key, hash, load, opts)
+				}
+			}
+			value := e.item.Value
+			s.mu.Unlock()
+			return value, nil
+		}
+		s.removeElement(element, evictExpired)
+	}
+
+	s.stats.misses++
+	// A load that started before one of its tags was invalidated may return
+	// data older than the caller's own writes, so it is not joined
+	cl, loading := s.calls[key]
+	if loading && c.current(cl.versions) {
+		s.stats.coalesced++
+	} else {
+		cl = c.startLoad(ctx, s, key, hash, load, opts)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-cl.done:
+		return cl.val, cl.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// startLoad runs load in a new goroutine and registers it as the load of key
+// in s. The caller holds s.mu.
+func (c *TypedCache[K, V]) startLoad(ctx context.Context, s *shard[K, V], key K, hash uint64, load LoadFunc[V], opts LoadOptions[V]) *call[V] {
+	// Versions are taken before loading so that a write during the load wins
+	versions := c.TagVersions(opts.Tags...)
+	cl := &call[V]{done: make(chan struct{}), versions: versions}
+	s.calls[key] = cl
+	s.stats.loads++
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				cl.err = fmt.Errorf("cache: loader panicked: %v", r)
+			}
+
+			s.mu.Lock()
+			if s.calls[key] == cl {
+				delete(s.calls, key)
+			}
+			if cl.err != nil {
+				s.stats.loadErrs++
+			} else if c.current(versions) {
+				ttl := opts.TTL
+				if opts.NegativeTTL > 0 && opts.IsEmpty != nil && opts.IsEmpty(cl.val) {
+					ttl = opts.NegativeTTL
+				}
+				s.set(key, hash, cl.val, ttl, opts.StaleTTL, opts.Tags)
+			}
+			s.mu.Unlock()
+			close(cl.done)
+		}()
+		cl.val, cl.err = load(context.WithoutCancel(ctx))
+	}()
+	return cl
+}
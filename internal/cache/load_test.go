@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoadCoalescesConcurrentLoads(t *testing.T) {
+	cache := New(Config{MaxItems: 10})
+	defer cache.Close()
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(context.Context) (interface{}, error) {
+		loads.Add(1)
+		<-release
+		return "value", nil
+	}
+
+	const callers = 10
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := cache.GetOrLoad(context.Background(), "key", load, LoadOptions[interface{}]{TTL: time.Minute})
+			if err != nil || value != "value" {
+				t.Errorf("GetOrLoad() = %v, %v", value, err)
+			}
+		}()
+	}
+
+	// Let every caller queue up behind the first load
+	for cache.GetStats()["miss_count"].(uint64) < callers {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if n := loads.Load(); n != 1 {
+		t.Errorf("loads = %d, want 1", n)
+	}
+	stats := cache.GetStats()
+	if n := stats["coalesced_loads"].(uint64); n != callers-1 {
+		t.Errorf("coalesced_loads = %d, want %d", n, callers-1)
+	}
+
+	// Later calls are served from the cache
+	if _, err := cache.GetOrLoad(context.Background(), "key", load, LoadOptions[interface{}]{TTL: time.Minute}); err != nil {
+		t.Fatalf("GetOrLoad() error = %v", err)
+	}
+	if n := loads.Load(); n != 1 {
+		t.Errorf("loads after hit = %d, want 1", n)
+	}
+}
+
+func TestGetOrLoadServesStaleWhileRefreshing(t *testing.T) {
+	cache := New(Config{MaxItems: 10})
+	defer cache.Close()
+
+	opts := LoadOptions[interface{}]{TTL: 10 * time.Millisecond, StaleTTL: time.Hour}
+	if _, err := cache.GetOrLoad(context.Background(), "key",
+		func(context.Context) (interface{}, error) { return 1, nil }, opts); err != nil {
+		t.Fatalf("GetOrLoad() error = %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	refreshed := make(chan struct{})
+	value, err := cache.GetOrLoad(context.Background(), "key", func(context.Context) (interface{}, error) {
+		defer close(refreshed)
+		return 2, nil
+	}, opts)
+	if err != nil || value != 1 {
+		t.Errorf("GetOrLoad() on stale item = %v, %v, want the stale value 1", value, err)
+	}
+	if n := cache.GetStats()["stale_hits"].(uint64); n != 1 {
+		t.Errorf("stale_hits = %d, want 1", n)
+	}
+
+	<-refreshed
+	deadline := time.Now().Add(time.Second)
+	for {
+		if value, exists := cache.Get("key"); exists && value == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("background refresh was not stored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetOrLoadNegativeCaching(t *testing.T) {
+	cache := New(Config{MaxItems: 10})
+	defer cache.Close()
+
+	opts := LoadOptions[interface{}]{
+		TTL:         time.Hour,
+		NegativeTTL: 10 * time.Millisecond,
+		IsEmpty:     func(v interface{}) bool { return v == "" },
+	}
+	load := func(value string) LoadFunc[interface{}] {
+		return func(context.Context) (interface{}, error) { return value, nil }
+	}
+
+	cache.GetOrLoad(context.Background(), "empty", load(""), opts)
+	cache.GetOrLoad(context.Background(), "full", load("value"), opts)
+
+	// Empty results are cached, but only for NegativeTTL
+	if _, exists := cache.Get("empty"); !exists {
+		t.Errorf("empty result should be cached")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, exists := cache.Get("empty"); exists {
+		t.Errorf("empty result should expire after NegativeTTL")
+	}
+	if _, exists := cache.Get("full"); !exists {
+		t.Errorf("non-empty result should be cached for TTL")
+	}
+}
+
+func TestGetOrLoadDoesNotCacheErrors(t *testing.T) {
+	cache := New(Config{MaxItems: 10})
+	defer cache.Close()
+
+	errLoad := errors.New("database is down")
+	opts := LoadOptions[interface{}]{TTL: time.Minute}
+	if _, err := cache.GetOrLoad(context.Background(), "key",
+		func(context.Context) (interface{}, error) { return nil, errLoad }, opts); !errors.Is(err, errLoad) {
+		t.Errorf("GetOrLoad() error = %v, want %v", err, errLoad)
+	}
+	if _, err := cache.GetOrLoad(context.Background(), "panics",
+		func(context.Context) (interface{}, error) { panic("boom") }, opts); err == nil {
+		t.Errorf("GetOrLoad() with a panicking loader should return an error")
+	}
+	if n := cache.GetStats()["load_errors"].(uint64); n != 2 {
+		t.Errorf("load_errors = %d, want 2", n)
+	}
+
+	value, err := cache.GetOrLoad(context.Background(), "key",
+		func(context.Context) (interface{}, error) { return "value", nil }, opts)
+	if err != nil || value != "value" {
+		t.Errorf("GetOrLoad() after error = %v, %v, want a fresh load", value, err)
+	}
+}
+
+func TestGetOrLoadInvalidatedDuringLoad(t *testing.T) {
+	cache := New(Config{MaxItems: 10})
+	defer cache.Close()
+
+	opts := LoadOptions[interface{}]{TTL: time.Minute, Tags: []string{"users"}}
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan interface{})
+	go func() {
+		value, _ := cache.GetOrLoad(context.Background(), "key", func(context.Context) (interface{}, error) {
+			close(started)
+			<-release
+			return "before write", nil
+		}, opts)
+		done <- value
+	}()
+	<-started
+	cache.InvalidateTag("users")
+
+	// A caller arriving after the write does not join the older load
+	value, err := cache.GetOrLoad(context.Background(), "key",
+		func(context.Context) (interface{}, error) { return "after write", nil }, opts)
+	if err != nil || value != "after write" {
+		t.Errorf("GetOrLoad() after invalidation = %v, %v, want a new load", value, err)
+	}
+
+	close(release)
+	if value := <-done; value != "before write" {
+		t.Errorf("first caller got %v", value)
+	}
+	if value, _ := cache.Get("key"); value != "after write" {
+		t.Errorf("cached value = %v, want the load that started after the write", value)
+	}
+}
+
+func TestGetOrLoadCallerCancellation(t *testing.T) {
+	cache := New(Config{MaxItems: 10})
+	defer cache.Close()
+
+	release := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cache.GetOrLoad(ctx, "key", func(ctx context.Context) (interface{}, error) {
+		<-release
+		return "value", ctx.Err()
+	}, LoadOptions[interface{}]{TTL: time.Minute})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("GetOrLoad() error = %v, want context.Canceled", err)
+	}
+
+	// The load itself is not cancelled and is cached for later callers
+	close(release)
+	value, err := cache.GetOrLoad(context.Background(), "key", func(context.Context) (interface{}, error) {
+		return "second load", nil
+	}, LoadOptions[interface{}]{TTL: time.Minute})
+	if err != nil || value != "value" {
+		t.Errorf("GetOrLoad() = %v, %v, want the first load's value", value, err)
+	}
+}
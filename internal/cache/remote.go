@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/cursorrules-golang/internal/resp"
+)
+
+// Remote is a Backend keeping values in a Redis compatible server, so that
+// every replica shares them and sees the others' invalidations.
+//
+// Values are stored as prefix+key. Each tag is a set of keys stored as
+// prefix+"tag:"+tag, expiring with the longest lived of its keys, and a
+// counter of its invalidations stored as prefix+"tagver:"+tag. Values are
+// written in a transaction watching the counters, so that a value loaded
+// before another replica's write is never stored after its invalidation.
+//
+// Tag expiries are extended with PEXPIRE NX and GT, so the server must be
+// Redis 7.0 or later, or a compatible server such as Valkey. Older servers
+// reject every Set.
+type Remote[V any] struct {
+	client *resp.Client
+	codec  Codec[V]
+	prefix string
+}
+
+// NewRemote creates a backend storing values encoded with codec under prefix
+func NewRemote[V any](client *resp.Client, codec Codec[V], prefix string) *Remote[V] {
+	return &Remote[V]{client: client, codec: codec, prefix: prefix}
+}
+
+// Get returns the value stored under key
+func (r *Remote[V]) Get(ctx context.Context, key string) (V, bool, error) {
+	var value V
+	data, err := resp.Bytes(r.client.Do(ctx, "GET", r.prefix+key))
+	if errors.Is(err, resp.ErrNil) {
+		return value, false, nil
+	}
+	if err != nil {
+		return value, false, err
+	}
+	if err := r.codec.Unmarshal(data, &value); err != nil {
+		return value, false, err
+	}
+	return value, true, nil
+}
+
+// TagVersions returns the current invalidation counters of tags
+func (r *Remote[V]) TagVersions(ctx context.Context, tags ...string) (TagVersions, error) {
+	versions := make(TagVersions, len(tags))
+	if len(tags) == 0 {
+		return versions, nil
+	}
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = r.versionKey(tag)
+	}
+	reply, err := r.client.Do(ctx, append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseVersions(tags, reply)
+}
+
+// parseVersions reads the reply of MGET on the counters of tags. Missing
+// counters are zero.
+func parseVersions(tags []string, reply interface{}) (TagVersions, error) {
+	counters, ok := reply.([]interface{})
+	if !ok || len(counters) != len(tags) {
+		return nil, fmt.Errorf("cache: unexpected MGET reply %T", reply)
+	}
+	versions := make(TagVersions, len(tags))
+	for i, tag := range tags {
+		if counters[i] == nil {
+			versions[tag] = 0
+			continue
+		}
+		data, err := resp.Bytes(counters[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		if versions[tag], err = strconv.ParseUint(string(data), 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+// Set stores value under key for ttl, tagged with every tag in versions,
+// unless one of them has been invalidated since versions were taken
+func (r *Remote[V]) Set(ctx context.Context, key string, value V, ttl time.Duration, versions TagVersions) (bool, error) {
+	data, err := r.codec.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	ms := strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)
+
+	conn, err := r.client.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	tags := make([]string, 0, len(versions))
+	keys := make([]string, 0, len(versions))
+	for tag := range versions {
+		tags = append(tags, tag)
+		keys = append(keys, r.versionKey(tag))
+	}
+	if len(keys) > 0 {
+		// The transaction below fails if a counter changes after this check
+		if _, err := conn.Do(ctx, append([]string{"WATCH"}, keys...)...); err != nil {
+			return false, err
+		}
+		reply, err := conn.Do(ctx, append([]string{"MGET"}, keys...)...)
+		if err != nil {
+			conn.Do(ctx, "UNWATCH")
+			return false, err
+		}
+		current, err := parseVersions(tags, reply)
+		if err != nil || !sameVersions(current, versions) {
+			conn.Do(ctx, "UNWATCH")
+			return false, err
+		}
+	}
+
+	commands := [][]string{{"MULTI"}}
+	for _, tag := range tags {
+		// A new tag set gets the value's expiry, an existing one is only extended
+		tagKey := r.tagKey(tag)
+		commands = append(commands,
+			[]string{"SADD", tagKey, r.prefix + key},
+			[]string{"PEXPIRE", tagKey, ms, "NX"},
+			[]string{"PEXPIRE", tagKey, ms, "GT"})
+	}
+	commands = append(commands, []string{"SET", r.prefix + key, string(data), "PX", ms})
+	for _, command := range commands {
+		if _, err := conn.Do(ctx, command...); err != nil {
+			conn.Do(ctx, "DISCARD")
+			return false, err
+		}
+	}
+
+	// EXEC replies nil when a watched counter changed
+	reply, err := conn.Do(ctx, "EXEC")
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+func sameVersions(a, b TagVersions) bool {
+	for tag, version := range b {
+		if a[tag] != version {
+			return false
+		}
+	}
+	return true
+}
+
+// Delete removes key
+func (r *Remote[V]) Delete(ctx context.Context, key string) error {
+	_, err := r.client.Do(ctx, "DEL", r.prefix+key)
+	return err
+}
+
+// InvalidateTag removes every value tagged with tag. The tag's counter is
+// incremented first, so that values loaded before the call can no longer be
+// stored. The tag's set is then renamed so that values tagged while the
+// invalidation runs go to a new set and survive it.
+func (r *Remote[V]) InvalidateTag(ctx context.Context, tag string) (int, error) {
+	if _, err := r.client.Do(ctx, "INCR", r.versionKey(tag)); err != nil {
+		return 0, err
+	}
+
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return 0, err
+	}
+	tagKey := r.tagKey(tag)
+	pending := tagKey + ":invalidating:" + hex.EncodeToString(suffix[:])
+
+	if _, err := r.client.Do(ctx, "RENAME", tagKey, pending); err != nil {
+		var replyErr resp.Error
+		if errors.As(err, &replyErr) && strings.Contains(string(replyErr), "no such key") {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	keys, err := resp.Strings(r.client.Do(ctx, "SMEMBERS", pending))
+	if err != nil {
+		return 0, err
+	}
+	n := int64(0)
+	if len(keys) > 0 {
+		if n, err = resp.Int(r.client.Do(ctx, append([]string{"DEL"}, keys...)...)); err != nil {
+			return 0, err
+		}
+	}
+	_, err = r.client.Do(ctx, "DEL", pending)
+	return int(n), err
+}
+
+// Close closes the client's connections
+func (r *Remote[V]) Close() error {
+	return r.client.Close()
+}
+
+func (r *Remote[V]) tagKey(tag string) string {
+	return r.prefix + "tag:" + tag
+}
+
+func (r *Remote[V]) versionKey(tag string) string {
+	return r.prefix + "tagver:" + tag
+}
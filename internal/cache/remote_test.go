@@ -0,0 +1,242 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/cursorrules-golang/internal/resp"
+	"example.com/cursorrules-golang/internal/resp/resptest"
+)
+
+func newRemote[V any](t *testing.T, server *resptest.Server) *Remote[V] {
+	t.Helper()
+	remote := NewRemote(resp.NewClient(server.Addr, resp.Options{}), JSONCodec[V]{}, "test:")
+	t.Cleanup(func() { remote.Close() })
+	return remote
+}
+
+func newServer(t *testing.T) *resptest.Server {
+	t.Helper()
+	server, err := resptest.NewServer()
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRemoteBackend(t *testing.T) {
+	remote := newRemote[[]string](t, newServer(t))
+	ctx := context.Background()
+
+	versions, err := remote.TagVersions(ctx, "users")
+	if err != nil {
+		t.Fatalf("TagVersions() error = %v", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if ok, err := remote.Set(ctx, key, []string{key, key}, time.Minute, versions); !ok || err != nil {
+			t.Fatalf("Set(%q) = %v, %v", key, ok, err)
+		}
+	}
+	if ok, err := remote.Set(ctx, "untagged", []string{"c"}, time.Minute, nil); !ok || err != nil {
+		t.Fatalf("Set(untagged) = %v, %v", ok, err)
+	}
+
+	value, found, err := remote.Get(ctx, "a")
+	if err != nil || !found || len(value) != 2 || value[0] != "a" {
+		t.Errorf("Get(a) = %v, %v, %v", value, found, err)
+	}
+	if _, found, err := remote.Get(ctx, "missing"); found || err != nil {
+		t.Errorf("Get(missing) = %v, %v", found, err)
+	}
+
+	if err := remote.Delete(ctx, "b"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, found, _ := remote.Get(ctx, "b"); found {
+		t.Error("Get(b) found a deleted value")
+	}
+
+	// b is already gone, so only a is removed
+	if n, err := remote.InvalidateTag(ctx, "users"); n != 1 || err != nil {
+		t.Errorf("InvalidateTag() = %d, %v, want 1", n, err)
+	}
+	if _, found, _ := remote.Get(ctx, "a"); found {
+		t.Error("Get(a) found an invalidated value")
+	}
+	if _, found, _ := remote.Get(ctx, "untagged"); !found {
+		t.Error("InvalidateTag() removed an untagged value")
+	}
+	if n, err := remote.InvalidateTag(ctx, "users"); n != 0 || err != nil {
+		t.Errorf("second InvalidateTag() = %d, %v, want 0", n, err)
+	}
+}
+
+func TestRemoteSetAfterInvalidation(t *testing.T) {
+	remote := newRemote[string](t, newServer(t))
+	ctx := context.Background()
+
+	// A write between taking the versions and storing the value must win
+	versions, _ := remote.TagVersions(ctx, "users")
+	remote.InvalidateTag(ctx, "users")
+	if ok, err := remote.Set(ctx, "key", "stale", time.Minute, versions); ok || err != nil {
+		t.Errorf("Set() = %v, %v, want false", ok, err)
+	}
+	if _, found, _ := remote.Get(ctx, "key"); found {
+		t.Error("Get() found a value stored after invalidation")
+	}
+
+	versions, _ = remote.TagVersions(ctx, "users")
+	if ok, err := remote.Set(ctx, "key", "fresh", time.Minute, versions); !ok || err != nil {
+		t.Errorf("Set() with current versions = %v, %v", ok, err)
+	}
+}
+
+func TestRemoteExpiry(t *testing.T) {
+	server := newServer(t)
+	remote := newRemote[string](t, server)
+	ctx := context.Background()
+
+	versions, _ := remote.TagVersions(ctx, "users")
+	remote.Set(ctx, "key", "value", 20*time.Millisecond, versions)
+	time.Sleep(50 * time.Millisecond)
+
+	if _, found, _ := remote.Get(ctx, "key"); found {
+		t.Error("Get() found an expired value")
+	}
+	// The tag set expires with its values; only the counter remains
+	if n := server.Keys(); n != 0 {
+		t.Errorf("server holds %d keys after expiry, want 0", n)
+	}
+}
+
+func TestSharedReplicasSeeInvalidation(t *testing.T) {
+	server := newServer(t)
+	replicas := []*Shared[string]{
+		NewShared(newRemote[Item[string]](t, server)),
+		NewShared(newRemote[Item[string]](t, server)),
+	}
+	ctx := context.Background()
+
+	var loads atomic.Int32
+	version := "v1"
+	load := func(context.Context) (string, error) {
+		loads.Add(1)
+		return version, nil
+	}
+	opts := LoadOptions[string]{TTL: time.Minute, Tags: []string{"users"}}
+
+	if value, err := replicas[0].GetOrLoad(ctx, "key", load, opts); value != "v1" || err != nil {
+		t.Fatalf("GetOrLoad() = %q, %v", value, err)
+	}
+	// The second replica is served the value loaded by the first
+	if value, _ := replicas[1].GetOrLoad(ctx, "key", load, opts); value != "v1" || loads.Load() != 1 {
+		t.Errorf("second replica got %q after %d loads, want v1 after 1", value, loads.Load())
+	}
+
+	// A write on one replica invalidates the value for both
+	version = "v2"
+	if n := replicas[1].InvalidateTag("users"); n != 1 {
+		t.Errorf("InvalidateTag() = %d, want 1", n)
+	}
+	if value, _ := replicas[0].GetOrLoad(ctx, "key", load, opts); value != "v2" {
+		t.Errorf("first replica got %q after invalidation, want v2", value)
+	}
+}
+
+func TestSharedLoadRacingInvalidation(t *testing.T) {
+	server := newServer(t)
+	replicas := []*Shared[string]{
+		NewShared(newRemote[Item[string]](t, server)),
+		NewShared(newRemote[Item[string]](t, server)),
+	}
+	ctx := context.Background()
+	opts := LoadOptions[string]{TTL: time.Minute, Tags: []string{"users"}}
+
+	// Another replica's write lands while this one is loading
+	value, err := replicas[0].GetOrLoad(ctx, "key", func(context.Context) (string, error) {
+		replicas[1].InvalidateTag("users")
+		return "stale", nil
+	}, opts)
+	if value != "stale" || err != nil {
+		t.Fatalf("GetOrLoad() = %q, %v", value, err)
+	}
+
+	value, _ = replicas[1].GetOrLoad(ctx, "key", func(context.Context) (string, error) {
+		return "fresh", nil
+	}, opts)
+	if value != "fresh" {
+		t.Errorf("GetOrLoad() = %q, want the value loaded after the write", value)
+	}
+}
+
+func TestSharedOverLocal(t *testing.T) {
+	local := NewLocal(NewTyped[string, Item[string]](Config{MaxItems: 10}))
+	shared := NewShared(local)
+	defer shared.Close()
+	ctx := context.Background()
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(context.Context) (string, error) {
+		loads.Add(1)
+		<-release
+		return "value", nil
+	}
+	opts := LoadOptions[string]{TTL: 20 * time.Millisecond, StaleTTL: time.Minute}
+
+	const callers = 5
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := shared.GetOrLoad(ctx, "key", load, opts); value != "value" || err != nil {
+				t.Errorf("GetOrLoad() = %q, %v", value, err)
+			}
+		}()
+	}
+	for shared.GetStats()["miss_count"].(uint64) < callers {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if n := loads.Load(); n != 1 {
+		t.Errorf("loads = %d, want 1", n)
+	}
+
+	// Once expired the value is served stale while it is refreshed
+	time.Sleep(30 * time.Millisecond)
+	if value, err := shared.GetOrLoad(ctx, "key", load, opts); value != "value" || err != nil {
+		t.Errorf("stale GetOrLoad() = %q, %v", value, err)
+	}
+	for loads.Load() < 2 || shared.GetStats()["loads"].(uint64) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if stale := shared.GetStats()["stale_hits"].(uint64); stale != 1 {
+		t.Errorf("stale_hits = %d, want 1", stale)
+	}
+}
+
+func TestSharedBackendUnavailable(t *testing.T) {
+	server := newServer(t)
+	shared := NewShared(newRemote[Item[string]](t, server))
+	server.Close()
+
+	// Requests still succeed, loading every time
+	for i := 0; i < 2; i++ {
+		value, err := shared.GetOrLoad(context.Background(), "key", func(context.Context) (string, error) {
+			return "value", nil
+		}, LoadOptions[string]{TTL: time.Minute})
+		if value != "value" || err != nil {
+			t.Errorf("GetOrLoad() = %q, %v", value, err)
+		}
+	}
+	stats := shared.GetStats()
+	if stats["loads"].(uint64) != 2 || stats["backend_errors"].(uint64) == 0 {
+		t.Errorf("stats = %v, want 2 loads and backend errors", stats)
+	}
+}
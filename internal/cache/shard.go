@@ -0,0 +1,309 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// entry is the value of an element in the recency list
+type entry[K comparable, V any] struct {
+	key  K
+	hash uint64
+	item Item[V]
+	tags []string
+	size int64
+	// staleUntil is when the item is removed. Between its expiration and
+	// staleUntil it may still be served by GetOrLoad while it is refreshed.
+	staleUntil int64
+}
+
+// evictReason says why an item was removed without being deleted
+type evictReason int
+
+const (
+	// evictNone is used for explicit deletes, which are not evictions
+	evictNone evictReason = iota
+	// evictCapacity makes room under MaxItems
+	evictCapacity
+	// evictBytes makes room under MaxBytes
+	evictBytes
+	// evictExpired removes items past their expiration
+	evictExpired
+	// evictInvalidated removes items whose tag was invalidated
+	evictInvalidated
+	evictReasons
+)
+
+// evictReasonNames are the keys of the evictions reported by GetStats
+var evictReasonNames = [evictReasons]string{
+	evictCapacity:    "capacity",
+	evictBytes:       "bytes",
+	evictExpired:     "expired",
+	evictInvalidated: "invalidated",
+}
+
+// shardStats counts lookups, loads and evictions in a shard
+type shardStats struct {
+	hits      uint64
+	misses    uint64
+	loads     uint64
+	coalesced uint64
+	staleHits uint64
+	loadErrs  uint64
+	rejected  uint64
+	evictions [evictReasons]uint64
+}
+
+func (s *shardStats) add(other shardStats) {
+	s.hits += other.hits
+	s.misses += other.misses
+	s.loads += other.loads
+	s.coalesced += other.coalesced
+	s.staleHits += other.staleHits
+	s.loadErrs += other.loadErrs
+	s.rejected += other.rejected
+	for reason, n := range other.evictions {
+		s.evictions[reason] += n
+	}
+}
+
+// shard is an independently locked part of a cache with its own LRU list.
+// Every method requires the caller to hold mu.
+type shard[K comparable, V any] struct {
+	mu       sync.Mutex
+	items    map[K]*list.Element
+	lru      *list.List
+	tags     map[string]map[K]struct{}
+	calls    map[K]*call[V]
+	maxItems int
+	maxBytes int64
+	bytes    int64
+	size     SizeFunc[K, V]
+	// sketch counts accesses for admission, nil when admission is disabled
+	sketch *sketch
+	stats  shardStats
+	closed bool
+}
+
+func newShard[K comparable, V any](maxItems int, maxBytes int64, admission bool) *shard[K, V] {
+	s := &shard[K, V]{
+		lru:      list.New(),
+		calls:    make(map[K]*call[V]),
+		maxItems: maxItems,
+		maxBytes: maxBytes,
+	}
+	if admission {
+		s.sketch = newSketch(maxItems)
+	}
+	s.clear()
+	return s
+}
+
+// clear drops every item
+func (s *shard[K, V]) clear() {
+	s.items = make(map[K]*list.Element)
+	s.tags = make(map[string]map[K]struct{})
+	s.lru.Init()
+	s.bytes = 0
+}
+
+// record counts an access for admission
+func (s *shard[K, V]) record(hash uint64) {
+	if s.sketch != nil {
+		s.sketch.increment(hash)
+	}
+}
+
+// set stores an item that may be served stale for up to stale after it
+// expires, replacing any previous tags. It reports false when the item was
+// refused, because it does not fit or is accessed less often than the items
+// it would evict.
+func (s *shard[K, V]) set(key K, hash uint64, value V, duration, stale time.Duration, tags []string) bool {
+	if s.closed {
+		return false
+	}
+	s.record(hash)
+
+	var size int64
+	if s.size != nil {
+		size = s.size(key, value)
+	}
+	element, exists := s.items[key]
+	if s.maxBytes > 0 && size > s.maxBytes {
+		// The old value is out of date, so it must not be served instead
+		if exists {
+			s.removeElement(element, evictNone)
+		}
+		s.stats.rejected++
+		return false
+	}
+
+	now := time.Now().UnixNano()
+	item := Item[V]{
+		Value:      value,
+		Expiration: now + duration.Nanoseconds(),
+		LastAccess: now,
+	}
+	staleUntil := item.Expiration + stale.Nanoseconds()
+
+	if exists {
+		e := element.Value.(*entry[K, V])
+		s.untag(e)
+		s.bytes += size - e.size
+		e.item = item
+		e.tags = tags
+		e.size = size
+		e.staleUntil = staleUntil
+		s.tag(e)
+		s.lru.MoveToFront(element)
+		s.evictBytes(element)
+		return true
+	}
+
+	if !s.admit(hash, size) {
+		s.stats.rejected++
+		return false
+	}
+
+	// Check if we need to evict items
+	for len(s.items) >= s.maxItems {
+		s.removeElement(s.lru.Back(), evictCapacity)
+	}
+
+	e := &entry[K, V]{key: key, hash: hash, item: item, tags: tags, size: size, staleUntil: staleUntil}
+	element = s.lru.PushFront(e)
+	s.items[key] = element
+	s.bytes += size
+	s.tag(e)
+	s.evictBytes(element)
+	return true
+}
+
+// admit reports whether a new item should be stored. With admission enabled
+// it is refused if any item it would evict is used at least as often, so
+// that a scan of keys read once does not flush the keys read all the time.
+func (s *shard[K, V]) admit(hash uint64, size int64) bool {
+	if s.sketch == nil {
+		return true
+	}
+
+	frequency := s.sketch.estimate(hash)
+	evictCount := len(s.items) + 1 - s.maxItems
+	evictBytes := s.bytes + size - s.maxBytes
+	if s.maxBytes <= 0 {
+		evictBytes = 0
+	}
+	for element := s.lru.Back(); element != nil && (evictCount > 0 || evictBytes > 0); element = element.Prev() {
+		victim := element.Value.(*entry[K, V])
+		if s.sketch.estimate(victim.hash) >= frequency {
+			return false
+		}
+		evictCount--
+		evictBytes -= victim.size
+	}
+	return true
+}
+
+// evictBytes evicts the least recently used items other than keep until the
+// shard is within its byte budget
+func (s *shard[K, V]) evictBytes(keep *list.Element) {
+	if s.maxBytes <= 0 {
+		return
+	}
+	for s.bytes > s.maxBytes {
+		oldest := s.lru.Back()
+		if oldest == keep {
+			oldest = oldest.Prev()
+		}
+		if oldest == nil {
+			return
+		}
+		s.removeElement(oldest, evictBytes)
+	}
+}
+
+// get returns a fresh item and marks it as recently used
+func (s *shard[K, V]) get(key K, hash uint64) (V, bool) {
+	var zero V
+	if s.closed {
+		return zero, false
+	}
+	s.record(hash)
+	element, exists := s.items[key]
+	if !exists {
+		s.stats.misses++
+		return zero, false
+	}
+
+	e := element.Value.(*entry[K, V])
+	now := time.Now().UnixNano()
+	if now > e.item.Expiration {
+		// Stale items are kept for GetOrLoad to serve while refreshing
+		if now > e.staleUntil {
+			s.removeElement(element, evictExpired)
+		}
+		s.stats.misses++
+		return zero, false
+	}
+
+	// Update last access time
+	e.item.LastAccess = now
+	s.lru.MoveToFront(element)
+	s.stats.hits++
+
+	return e.item.Value, true
+}
+
+// invalidate removes every item tagged with tag and returns how many were removed
+func (s *shard[K, V]) invalidate(tag string) int {
+	keys := s.tags[tag]
+	n := len(keys)
+	for key := range keys {
+		s.removeElement(s.items[key], evictInvalidated)
+	}
+	return n
+}
+
+// sweep removes items that can no longer be served, even stale
+func (s *shard[K, V]) sweep(now int64) {
+	for _, element := range s.items {
+		if now > element.Value.(*entry[K, V]).staleUntil {
+			s.removeElement(element, evictExpired)
+		}
+	}
+}
+
+// removeElement unlinks an item from the map, the recency list and its
+// tags, counting it as an eviction for reason
+func (s *shard[K, V]) removeElement(element *list.Element, reason evictReason) {
+	e := s.lru.Remove(element).(*entry[K, V])
+	delete(s.items, e.key)
+	s.untag(e)
+	s.bytes -= e.size
+	if reason != evictNone {
+		s.stats.evictions[reason]++
+	}
+}
+
+// tag indexes an item under its tags
+func (s *shard[K, V]) tag(e *entry[K, V]) {
+	for _, tag := range e.tags {
+		keys, ok := s.tags[tag]
+		if !ok {
+			keys = make(map[K]struct{})
+			s.tags[tag] = keys
+		}
+		keys[e.key] = struct{}{}
+	}
+}
+
+// untag removes an item from the tag index
+func (s *shard[K, V]) untag(e *entry[K, V]) {
+	for _, tag := range e.tags {
+		delete(s.tags[tag], e.key)
+		if len(s.tags[tag]) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
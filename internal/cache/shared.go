@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Shared is a loading cache over a Backend. Values are stored with their
+// expiration as Items, so that stale values can be served while they are
+// refreshed. Loads are coalesced within the process only.
+//
+// Backend errors are logged and treated as misses, so that an unavailable
+// store slows requests down instead of failing them.
+type Shared[V any] struct {
+	backend Backend[Item[V]]
+	timeout time.Duration
+	mu      sync.Mutex
+	calls   map[string]*call[V]
+	stats   sharedStats
+}
+
+type sharedStats struct {
+	hits        uint64
+	misses      uint64
+	loads       uint64
+	coalesced   uint64
+	staleHits   uint64
+	loadErrs    uint64
+	backendErrs uint64
+}
+
+// NewShared creates a cache over backend
+func NewShared[V any](backend Backend[Item[V]]) *Shared[V] {
+	return &Shared[V]{
+		backend: backend,
+		timeout: 5 * time.Second,
+		calls:   make(map[string]*call[V]),
+	}
+}
+
+// GetOrLoad returns the value for key, calling load when it is missing,
+// like TypedCache.GetOrLoad
+func (s *Shared[V]) GetOrLoad(ctx context.Context, key string, load LoadFunc[V], opts LoadOptions[V]) (V, error) {
+	item, found, err := s.backend.Get(ctx, key)
+	if err != nil {
+		s.backendError("get", err)
+		found = false
+	}
+
+	s.mu.Lock()
+	if found {
+		s.stats.hits++
+		if time.Now().UnixNano() > item.Expiration {
+			// Serve the stale value and refresh it in the background
+			s.stats.staleHits++
+			if _, loading := s.calls[key]; !loading {
+				s.startLoad(ctx, key, load, opts)
+			}
+		}
+		s.mu.Unlock()
+		return item.Value, nil
+	}
+
+	s.stats.misses++
+	cl, loading := s.calls[key]
+	if loading {
+		s.stats.coalesced++
+	} else {
+		cl = s.startLoad(ctx, key, load, opts)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-cl.done:
+		return cl.val, cl.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// startLoad runs load in a new goroutine and registers it as the load of
+// key. The caller holds s.mu.
+func (s *Shared[V]) startLoad(ctx context.Context, key string, load LoadFunc[V], opts LoadOptions[V]) *call[V] {
+	cl := &call[V]{done: make(chan struct{}), tags: opts.Tags}
+	s.calls[key] = cl
+	s.stats.loads++
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.timeout)
+		defer cancel()
+		defer func() {
+			if r := recover(); r != nil {
+				cl.err = fmt.Errorf("cache: loader panicked: %v", r)
+			}
+			s.mu.Lock()
+			if s.calls[key] == cl {
+				delete(s.calls, key)
+			}
+			if cl.err != nil {
+				s.stats.loadErrs++
+			}
+			s.mu.Unlock()
+			close(cl.done)
+		}()
+
+		// Versions are taken before loading so that a write during the load
+		// wins. Without them the value is still returned but not stored.
+		versions, err := s.backend.TagVersions(ctx, opts.Tags...)
+		if err != nil {
+			s.backendError("tag versions", err)
+		}
+
+		cl.val, cl.err = load(ctx)
+		if cl.err != nil || versions == nil {
+			return
+		}
+
+		ttl := opts.TTL
+		if opts.NegativeTTL > 0 && opts.IsEmpty != nil && opts.IsEmpty(cl.val) {
+			ttl = opts.NegativeTTL
+		}
+		item := Item[V]{Value: cl.val, Expiration: time.Now().Add(ttl).UnixNano()}
+		if _, err := s.backend.Set(ctx, key, item, ttl+opts.StaleTTL, versions); err != nil {
+			s.backendError("set", err)
+		}
+	}()
+	return cl
+}
+
+// InvalidateTag removes every value tagged with tag from the backend and
+// returns how many were removed. Callers arriving afterwards do not join
+// loads of this process that started before it.
+func (s *Shared[V]) InvalidateTag(tag string) int {
+	s.mu.Lock()
+	for key, cl := range s.calls {
+		if slices.Contains(cl.tags, tag) {
+			delete(s.calls, key)
+		}
+	}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	n, err := s.backend.InvalidateTag(ctx, tag)
+	if err != nil {
+		s.backendError("invalidate", err)
+	}
+	return n
+}
+
+// GetStats returns statistics of this process's use of the cache, and those
+// of the backend if it reports any
+func (s *Shared[V]) GetStats() map[string]interface{} {
+	s.mu.Lock()
+	stats := map[string]interface{}{
+		"hit_count":       s.stats.hits,
+		"miss_count":      s.stats.misses,
+		"loads":           s.stats.loads,
+		"coalesced_loads": s.stats.coalesced,
+		"stale_hits":      s.stats.staleHits,
+		"load_errors":     s.stats.loadErrs,
+		"backend_errors":  s.stats.backendErrs,
+	}
+	s.mu.Unlock()
+
+	if b, ok := s.backend.(interface{ GetStats() map[string]interface{} }); ok {
+		stats["backend"] = b.GetStats()
+	}
+	return stats
+}
+
+// Close closes the backend if it holds resources
+func (s *Shared[V]) Close() error {
+	if c, ok := s.backend.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (s *Shared[V]) backendError(op string, err error) {
+	s.mu.Lock()
+	s.stats.backendErrs++
+	s.mu.Unlock()
+	log.Printf("cache backend %s failed: %v", op, err)
+}
@@ -0,0 +1,77 @@
+package cache
+
+// sketchDepth is the number of counters, one per row, a key is counted in
+const sketchDepth = 4
+
+// sketchMax is where 4-bit counters saturate
+const sketchMax = 15
+
+// sketch is a count-min sketch estimating how often keys were accessed
+// recently. Counters are halved once the sketch has counted ten times its
+// width, so that keys popular long ago lose their advantage.
+type sketch struct {
+	rows      [sketchDepth][]uint8
+	shift     uint
+	additions int
+	resetAt   int
+}
+
+// newSketch creates a sketch for a cache of capacity items. It is wide
+// enough to tell the cached keys apart from several times as many others.
+func newSketch(capacity int) *sketch {
+	width, bits := 1024, uint(10)
+	for width < 4*capacity {
+		width *= 2
+		bits++
+	}
+
+	s := &sketch{shift: 64 - bits, resetAt: 10 * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// rowSeeds are odd multipliers that make each row index on different bits
+var rowSeeds = [sketchDepth]uint64{0x9e3779b97f4a7c15, 0xbf58476d1ce4e5b9, 0x94d049bb133111eb, 0xd6e8feb86659fd93}
+
+// index returns the counter of a hash in row i
+func (s *sketch) index(hash uint64, i int) uint64 {
+	return (hash * rowSeeds[i]) >> s.shift
+}
+
+// increment counts an access to the key with hash
+func (s *sketch) increment(hash uint64) {
+	for i := range s.rows {
+		if counter := &s.rows[i][s.index(hash, i)]; *counter < sketchMax {
+			*counter++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.halve()
+	}
+}
+
+// estimate returns how often the key with hash was accessed, never less than
+// the true count since the last halving
+func (s *sketch) estimate(hash uint64) uint8 {
+	min := uint8(sketchMax)
+	for i := range s.rows {
+		if counter := s.rows[i][s.index(hash, i)]; counter < min {
+			min = counter
+		}
+	}
+	return min
+}
+
+// halve ages every counter
+func (s *sketch) halve() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] /= 2
+		}
+	}
+	s.additions /= 2
+}
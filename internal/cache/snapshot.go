@@ -0,0 +1,415 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Snapshots start with snapshotMagic and snapshotVersion, followed by the
+// names of the key and value codecs. Each item follows as a 1 byte, its
+// encoded key and value, its expiration and staleness deadline in Unix
+// nanoseconds and its tags, and a 0 byte ends the items. Strings and encoded
+// values are prefixed with their length as a uvarint. A CRC-32 of everything
+// before it closes the snapshot.
+const (
+	snapshotMagic   = "GCSNAP"
+	snapshotVersion = 1
+	// maxSnapshotField bounds a single string or value, so that a corrupt
+	// length cannot make the reader allocate without bound
+	maxSnapshotField = 64 << 20
+)
+
+var (
+	// ErrNoCodec is returned when a snapshot needs a codec for a type that
+	// has not been registered with RegisterCodec
+	ErrNoCodec = errors.New("cache: no codec registered")
+	// ErrSnapshotCorrupt is returned for snapshots that are truncated, fail
+	// their checksum or cannot be decoded
+	ErrSnapshotCorrupt = errors.New("cache: corrupt snapshot")
+	// ErrSnapshotVersion is returned for snapshots written in another format
+	// version or with other codecs than the cache's
+	ErrSnapshotVersion = errors.New("cache: snapshot version mismatch")
+)
+
+// registeredCodec is a codec and the name it was registered under
+type registeredCodec struct {
+	name  string
+	codec interface{}
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[reflect.Type]registeredCodec{
+		reflect.TypeFor[string](): {name: "string", codec: stringCodec{}},
+	}
+)
+
+// RegisterCodec sets the codec snapshots use for keys or values of type V.
+// The name is recorded in snapshots, and snapshots written under another
+// name are refused, so change it whenever the encoding changes. Strings are
+// registered by default.
+func RegisterCodec[V any](name string, codec Codec[V]) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[reflect.TypeFor[V]()] = registeredCodec{name: name, codec: codec}
+}
+
+// lookupCodec returns the codec registered for V and its name
+func lookupCodec[V any]() (string, Codec[V], error) {
+	codecsMu.RLock()
+	registered, ok := codecs[reflect.TypeFor[V]()]
+	codecsMu.RUnlock()
+	if !ok {
+		return "", nil, fmt.Errorf("%w for %v", ErrNoCodec, reflect.TypeFor[V]())
+	}
+	return registered.name, registered.codec.(Codec[V]), nil
+}
+
+// stringCodec stores strings as their bytes
+type stringCodec struct{}
+
+func (stringCodec) Marshal(value string) ([]byte, error) { return []byte(value), nil }
+
+func (stringCodec) Unmarshal(data []byte, value *string) error {
+	*value = string(data)
+	return nil
+}
+
+// snapshotEntry is an item as written to or read from a snapshot
+type snapshotEntry[K comparable, V any] struct {
+	key        K
+	value      V
+	expiration int64
+	staleUntil int64
+	tags       []string
+}
+
+// WriteSnapshot writes every unexpired item with its expiration and tags to
+// w, using the codecs registered for K and V, and returns how many were
+// written. Items are written from least to most recently used, so that
+// reading the snapshot restores their order.
+func (c *TypedCache[K, V]) WriteSnapshot(w io.Writer) (int, error) {
+	keyName, keyCodec, err := lookupCodec[K]()
+	if err != nil {
+		return 0, err
+	}
+	valueName, valueCodec, err := lookupCodec[V]()
+	if err != nil {
+		return 0, err
+	}
+
+	// Items are copied under each shard's lock and encoded outside of it
+	var entries []snapshotEntry[K, V]
+	now := time.Now().UnixNano()
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for element := s.lru.Back(); element != nil; element = element.Prev() {
+			e := element.Value.(*entry[K, V])
+			if now > e.item.Expiration {
+				continue
+			}
+			entries = append(entries, snapshotEntry[K, V]{
+				key:        e.key,
+				value:      e.item.Value,
+				expiration: e.item.Expiration,
+				staleUntil: e.staleUntil,
+				tags:       e.tags,
+			})
+		}
+		s.mu.Unlock()
+	}
+
+	sw := newSnapshotWriter(w)
+	sw.writeRaw([]byte(snapshotMagic))
+	sw.writeUvarint(snapshotVersion)
+	sw.writeBytes([]byte(keyName))
+	sw.writeBytes([]byte(valueName))
+	for _, e := range entries {
+		key, err := keyCodec.Marshal(e.key)
+		if err != nil {
+			return 0, fmt.Errorf("cache: encoding key %v: %w", e.key, err)
+		}
+		value, err := valueCodec.Marshal(e.value)
+		if err != nil {
+			return 0, fmt.Errorf("cache: encoding value of %v: %w", e.key, err)
+		}
+		sw.writeRaw([]byte{1})
+		sw.writeBytes(key)
+		sw.writeBytes(value)
+		sw.writeInt64(e.expiration)
+		sw.writeInt64(e.staleUntil)
+		sw.writeUvarint(uint64(len(e.tags)))
+		for _, tag := range e.tags {
+			sw.writeBytes([]byte(tag))
+		}
+	}
+	sw.writeRaw([]byte{0})
+	if err := sw.close(); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// ReadSnapshot adds the items of a snapshot written by WriteSnapshot and
+// returns how many were added. Items that have expired since are skipped,
+// and the others keep their expiration and tags. Nothing is added unless
+// the whole snapshot is valid. Call it before the cache is used, as items
+// are restored regardless of invalidations made meanwhile.
+func (c *TypedCache[K, V]) ReadSnapshot(r io.Reader) (int, error) {
+	keyName, keyCodec, err := lookupCodec[K]()
+	if err != nil {
+		return 0, err
+	}
+	valueName, valueCodec, err := lookupCodec[V]()
+	if err != nil {
+		return 0, err
+	}
+
+	sr := newSnapshotReader(r)
+	magic := sr.readRaw(len(snapshotMagic))
+	if sr.err == nil && string(magic) != snapshotMagic {
+		return 0, fmt.Errorf("%w: not a snapshot", ErrSnapshotCorrupt)
+	}
+	version := sr.readUvarint()
+	if sr.err == nil && version != snapshotVersion {
+		return 0, fmt.Errorf("%w: format version %d, want %d", ErrSnapshotVersion, version, snapshotVersion)
+	}
+	gotKeyName, gotValueName := string(sr.readBytes()), string(sr.readBytes())
+	if sr.err == nil && (gotKeyName != keyName || gotValueName != valueName) {
+		return 0, fmt.Errorf("%w: codecs %q and %q, want %q and %q",
+			ErrSnapshotVersion, gotKeyName, gotValueName, keyName, valueName)
+	}
+
+	var entries []snapshotEntry[K, V]
+	for sr.err == nil {
+		if more := sr.readRaw(1); sr.err != nil || more[0] == 0 {
+			break
+		}
+		var e snapshotEntry[K, V]
+		key, value := sr.readBytes(), sr.readBytes()
+		e.expiration = sr.readInt64()
+		e.staleUntil = sr.readInt64()
+		if n := sr.readUvarint(); sr.err == nil {
+			if n > uint64(maxSnapshotField) {
+				return 0, fmt.Errorf("%w: %d tags", ErrSnapshotCorrupt, n)
+			}
+			for i := uint64(0); i < n && sr.err == nil; i++ {
+				e.tags = append(e.tags, string(sr.readBytes()))
+			}
+		}
+		if sr.err != nil {
+			break
+		}
+		if err := keyCodec.Unmarshal(key, &e.key); err != nil {
+			return 0, fmt.Errorf("%w: decoding key: %v", ErrSnapshotCorrupt, err)
+		}
+		if err := valueCodec.Unmarshal(value, &e.value); err != nil {
+			return 0, fmt.Errorf("%w: decoding value of %v: %v", ErrSnapshotCorrupt, e.key, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := sr.close(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	now := time.Now()
+	for _, e := range entries {
+		expiration := time.Unix(0, e.expiration)
+		if !now.Before(expiration) {
+			continue
+		}
+		s, hash := c.locate(e.key)
+		s.mu.Lock()
+		if s.set(e.key, hash, e.value, expiration.Sub(now), time.Duration(e.staleUntil-e.expiration), e.tags) {
+			n++
+		}
+		s.mu.Unlock()
+	}
+	return n, nil
+}
+
+// SaveSnapshot writes a snapshot to path. It is written to a temporary file
+// that replaces path once complete, so that a crash never leaves a partial
+// snapshot behind.
+func (c *TypedCache[K, V]) SaveSnapshot(path string) (int, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := c.WriteSnapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, os.Rename(f.Name(), path)
+}
+
+// LoadSnapshot reads the snapshot at path like ReadSnapshot. A missing file
+// returns an error satisfying errors.Is(err, fs.ErrNotExist).
+func (c *TypedCache[K, V]) LoadSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return c.ReadSnapshot(f)
+}
+
+// snapshotWriter encodes snapshot fields while computing their checksum. The
+// first error is kept and returned by close.
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	err error
+}
+
+func newSnapshotWriter(w io.Writer) *snapshotWriter {
+	crc := crc32.NewIEEE()
+	return &snapshotWriter{w: bufio.NewWriter(io.MultiWriter(w, crc)), crc: crc}
+}
+
+func (sw *snapshotWriter) writeRaw(b []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(b)
+	}
+}
+
+func (sw *snapshotWriter) writeUvarint(n uint64) {
+	sw.writeRaw(binary.AppendUvarint(nil, n))
+}
+
+func (sw *snapshotWriter) writeInt64(n int64) {
+	sw.writeRaw(binary.BigEndian.AppendUint64(nil, uint64(n)))
+}
+
+func (sw *snapshotWriter) writeBytes(b []byte) {
+	sw.writeUvarint(uint64(len(b)))
+	sw.writeRaw(b)
+}
+
+// close flushes the fields and appends their checksum
+func (sw *snapshotWriter) close() error {
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	if sw.err == nil {
+		// Everything is flushed, so the checksum covers every field
+		_, sw.err = sw.w.Write(binary.BigEndian.AppendUint32(nil, sw.crc.Sum32()))
+	}
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	return sw.err
+}
+
+// snapshotReader decodes snapshot fields while computing their checksum.
+// After the first error every read returns zero values and close returns
+// the error, reported as corruption if the snapshot ended early.
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+func newSnapshotReader(r io.Reader) *snapshotReader {
+	return &snapshotReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+}
+
+func (sr *snapshotReader) readRaw(n int) []byte {
+	if sr.err != nil {
+		return make([]byte, n)
+	}
+	// Read through a limit so that only data actually present is allocated
+	b, err := io.ReadAll(io.LimitReader(sr.r, int64(n)))
+	if err == nil && len(b) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		sr.err = err
+		return make([]byte, n)
+	}
+	sr.crc.Write(b)
+	return b
+}
+
+func (sr *snapshotReader) readUvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(byteReader{sr})
+	if err != nil {
+		sr.err = err
+	}
+	return n
+}
+
+func (sr *snapshotReader) readInt64() int64 {
+	return int64(binary.BigEndian.Uint64(sr.readRaw(8)))
+}
+
+func (sr *snapshotReader) readBytes() []byte {
+	n := sr.readUvarint()
+	if sr.err == nil && n > maxSnapshotField {
+		sr.err = fmt.Errorf("field of %d bytes", n)
+	}
+	if sr.err != nil {
+		return nil
+	}
+	return sr.readRaw(int(n))
+}
+
+// close checks the checksum and that nothing follows it
+func (sr *snapshotReader) close() error {
+	sum := sr.crc.Sum32()
+	var trailer [4]byte
+	if sr.err == nil {
+		_, sr.err = io.ReadFull(sr.r, trailer[:])
+	}
+	if sr.err == nil && binary.BigEndian.Uint32(trailer[:]) != sum {
+		sr.err = errors.New("checksum mismatch")
+	}
+	if sr.err == nil {
+		if _, err := sr.r.ReadByte(); err != io.EOF {
+			sr.err = errors.New("data after checksum")
+		}
+	}
+	if sr.err != nil {
+		return fmt.Errorf("%w: %v", ErrSnapshotCorrupt, sr.err)
+	}
+	return nil
+}
+
+// byteReader reads single bytes into a snapshotReader's checksum for
+// binary.ReadUvarint
+type byteReader struct {
+	sr *snapshotReader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	c, err := b.sr.r.ReadByte()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		b.sr.crc.Write([]byte{c})
+	}
+	return c, err
+}
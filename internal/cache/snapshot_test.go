@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type snapshotValue struct {
+	Name  string
+	Count int
+}
+
+func init() {
+	RegisterCodec[snapshotValue]("snapshot-value/v1", JSONCodec[snapshotValue]{})
+}
+
+func newSnapshotCache(maxItems int) *TypedCache[string, snapshotValue] {
+	return NewTyped[string, snapshotValue](Config{MaxItems: maxItems, Shards: 4})
+}
+
+// writeSnapshot returns a snapshot of a cache holding a, b and tagged
+func writeSnapshot(t *testing.T) []byte {
+	t.Helper()
+	c := newSnapshotCache(100)
+	defer c.Close()
+	c.Set("a", snapshotValue{Name: "a", Count: 1}, time.Minute)
+	c.Set("b", snapshotValue{Name: "b", Count: 2}, time.Hour)
+	c.SetTagged("tagged", snapshotValue{Name: "tagged"}, time.Minute, c.TagVersions("users"))
+	c.Set("expired", snapshotValue{Name: "expired"}, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	var buf bytes.Buffer
+	if n, err := c.WriteSnapshot(&buf); n != 3 || err != nil {
+		t.Fatalf("WriteSnapshot() = %d, %v, want 3 items", n, err)
+	}
+	return buf.Bytes()
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	snapshot := writeSnapshot(t)
+
+	c := newSnapshotCache(100)
+	defer c.Close()
+	if n, err := c.ReadSnapshot(bytes.NewReader(snapshot)); n != 3 || err != nil {
+		t.Fatalf("ReadSnapshot() = %d, %v, want 3 items", n, err)
+	}
+
+	if value, ok := c.Get("b"); !ok || value != (snapshotValue{Name: "b", Count: 2}) {
+		t.Errorf("Get(b) = %v, %v", value, ok)
+	}
+	if _, ok := c.Get("expired"); ok {
+		t.Error("Get(expired) found an item that expired before the snapshot")
+	}
+
+	// Items keep their expiration rather than getting a new TTL
+	s, _ := c.locate("a")
+	s.mu.Lock()
+	remaining := time.Until(time.Unix(0, s.items["a"].Value.(*entry[string, snapshotValue]).item.Expiration))
+	s.mu.Unlock()
+	if remaining > time.Minute || remaining < 50*time.Second {
+		t.Errorf("a expires in %v, want about a minute", remaining)
+	}
+
+	// Items keep their tags
+	if n := c.InvalidateTag("users"); n != 1 {
+		t.Errorf("InvalidateTag() = %d, want 1", n)
+	}
+	if _, ok := c.Get("tagged"); ok {
+		t.Error("Get(tagged) found an invalidated item")
+	}
+}
+
+func TestSnapshotKeepsRecencyOrder(t *testing.T) {
+	c := NewTyped[string, snapshotValue](Config{MaxItems: 3})
+	defer c.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(key, snapshotValue{Name: key}, time.Minute)
+	}
+	c.Get("a")
+
+	var buf bytes.Buffer
+	c.WriteSnapshot(&buf)
+
+	// A smaller cache keeps the most recently used items
+	restored := NewTyped[string, snapshotValue](Config{MaxItems: 2})
+	defer restored.Close()
+	restored.ReadSnapshot(&buf)
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := restored.Get(key); ok != want {
+			t.Errorf("Get(%q) found = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestSnapshotSkipsItemsExpiredSince(t *testing.T) {
+	c := newSnapshotCache(100)
+	defer c.Close()
+	c.Set("short", snapshotValue{}, 20*time.Millisecond)
+	c.Set("long", snapshotValue{}, time.Minute)
+	var buf bytes.Buffer
+	c.WriteSnapshot(&buf)
+
+	time.Sleep(30 * time.Millisecond)
+	restored := newSnapshotCache(100)
+	defer restored.Close()
+	if n, err := restored.ReadSnapshot(&buf); n != 1 || err != nil {
+		t.Errorf("ReadSnapshot() = %d, %v, want 1 item", n, err)
+	}
+}
+
+func TestSnapshotRejectsCorruption(t *testing.T) {
+	snapshot := writeSnapshot(t)
+
+	tests := map[string][]byte{
+		"empty":          {},
+		"not a snapshot": []byte("definitely not a cache snapshot"),
+		"trailing data":  append(bytes.Clone(snapshot), 0),
+	}
+	for _, n := range []int{1, 10, len(snapshot) / 2, len(snapshot) - 1} {
+		tests[fmt.Sprintf("truncated to %d bytes", n)] = snapshot[:n]
+	}
+	for _, i := range []int{len(snapshot) / 3, len(snapshot) / 2, len(snapshot) - 5} {
+		flipped := bytes.Clone(snapshot)
+		flipped[i] ^= 0x40
+		tests[fmt.Sprintf("flipped byte %d", i)] = flipped
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := newSnapshotCache(100)
+			defer c.Close()
+			n, err := c.ReadSnapshot(bytes.NewReader(data))
+			if !errors.Is(err, ErrSnapshotCorrupt) {
+				t.Errorf("ReadSnapshot() error = %v, want ErrSnapshotCorrupt", err)
+			}
+			if size := c.GetStats()["size"].(int); n != 0 || size != 0 {
+				t.Errorf("ReadSnapshot() restored %d items, cache holds %d, want none", n, size)
+			}
+		})
+	}
+}
+
+func TestSnapshotRejectsVersionMismatch(t *testing.T) {
+	snapshot := writeSnapshot(t)
+
+	// A snapshot of another format version
+	other := bytes.Clone(snapshot)
+	other[len(snapshotMagic)] = snapshotVersion + 1
+	c := newSnapshotCache(100)
+	defer c.Close()
+	if _, err := c.ReadSnapshot(bytes.NewReader(other)); !errors.Is(err, ErrSnapshotVersion) {
+		t.Errorf("ReadSnapshot() of another format error = %v, want ErrSnapshotVersion", err)
+	}
+
+	// A snapshot written before the value codec changed
+	RegisterCodec[snapshotValue]("snapshot-value/v2", JSONCodec[snapshotValue]{})
+	defer RegisterCodec[snapshotValue]("snapshot-value/v1", JSONCodec[snapshotValue]{})
+	if _, err := c.ReadSnapshot(bytes.NewReader(snapshot)); !errors.Is(err, ErrSnapshotVersion) {
+		t.Errorf("ReadSnapshot() with another codec error = %v, want ErrSnapshotVersion", err)
+	}
+}
+
+func TestSnapshotRequiresCodec(t *testing.T) {
+	c := New(Config{MaxItems: 10})
+	defer c.Close()
+	if _, err := c.WriteSnapshot(&bytes.Buffer{}); !errors.Is(err, ErrNoCodec) {
+		t.Errorf("WriteSnapshot() error = %v, want ErrNoCodec", err)
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	c := newSnapshotCache(100)
+	if _, err := c.LoadSnapshot(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadSnapshot() of a missing file error = %v, want fs.ErrNotExist", err)
+	}
+	c.Set("key", snapshotValue{Name: "value"}, time.Minute)
+	if n, err := c.SaveSnapshot(path); n != 1 || err != nil {
+		t.Fatalf("SaveSnapshot() = %d, %v", n, err)
+	}
+	c.Close()
+
+	restored := newSnapshotCache(100)
+	defer restored.Close()
+	if n, err := restored.LoadSnapshot(path); n != 1 || err != nil {
+		t.Fatalf("LoadSnapshot() = %d, %v", n, err)
+	}
+	if value, ok := restored.Get("key"); !ok || value.Name != "value" {
+		t.Errorf("Get() = %v, %v", value, ok)
+	}
+
+	// No temporary files are left behind
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
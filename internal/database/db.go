@@ -2,31 +2,140 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// schema lists the statements that bring a database up to date.
+// Every statement must be idempotent.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT,
+		email TEXT,
+		age INTEGER
+	);`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		prefix TEXT NOT NULL UNIQUE,
+		key_hash TEXT NOT NULL,
+		owner_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		expires_at DATETIME,
+		last_used_at DATETIME,
+		revoked_at DATETIME,
+		created_at DATETIME NOT NULL
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_api_keys_owner_id ON api_keys(owner_id);`,
+	`CREATE TABLE IF NOT EXISTS recovery_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		code_hash TEXT NOT NULL,
+		used_at DATETIME
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id);`,
+	`CREATE TABLE IF NOT EXISTS auth_tokens (
+		nonce TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		purpose TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_auth_tokens_user_purpose ON auth_tokens(user_id, purpose);`,
+	`CREATE TABLE IF NOT EXISTS rate_limits (
+		key TEXT PRIMARY KEY,
+		state BLOB NOT NULL,
+		expires_at INTEGER NOT NULL
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_rate_limits_expires_at ON rate_limits(expires_at);`,
+	`CREATE TABLE IF NOT EXISTS quota_usage (
+		subject TEXT NOT NULL,
+		name TEXT NOT NULL,
+		period_start TEXT NOT NULL,
+		used INTEGER NOT NULL,
+		PRIMARY KEY (subject, name, period_start)
+	);`,
+}
+
+// column describes a column added to an existing table
+type column struct {
+	table      string
+	name       string
+	definition string
+}
+
+// columns lists columns added after their table was first created.
+// SQLite has no ADD COLUMN IF NOT EXISTS, so Migrate checks for them first.
+var columns = []column{
+	{table: "users", name: "password_hash", definition: "TEXT"},
+	{table: "users", name: "role", definition: "TEXT NOT NULL DEFAULT 'user'"},
+	{table: "users", name: "totp_secret", definition: "TEXT"},
+	{table: "users", name: "totp_enabled", definition: "INTEGER NOT NULL DEFAULT 0"},
+	{table: "users", name: "totp_last_step", definition: "INTEGER NOT NULL DEFAULT 0"},
+	{table: "users", name: "email_verified", definition: "INTEGER NOT NULL DEFAULT 0"},
+	{table: "users", name: "plan", definition: "TEXT NOT NULL DEFAULT 'free'"},
+}
+
 func InitDB() *sql.DB {
 	db, err := sql.Open("sqlite3", "./users.db")
 	if err != nil {
 		log.Fatalf("Failed to open database: %v", err)
 	}
 
-	createTable(db)
+	if err := Migrate(db); err != nil {
+		log.Fatalf("Failed to create tables: %v", err)
+	}
 	return db
 }
 
-func createTable(db *sql.DB) {
-	query := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT,
-		email TEXT,
-		age INTEGER
-	);`
-	_, err := db.Exec(query)
+// Migrate creates any missing tables, columns and indexes
+func Migrate(db *sql.DB) error {
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	for _, col := range columns {
+		exists, err := hasColumn(db, col.table, col.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.name, col.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasColumn(db *sql.DB, table, name string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
 	if err != nil {
-		log.Fatalf("Failed to create table: %v", err)
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid          int
+			colName      string
+			colType      string
+			notNull      int
+			defaultValue sql.NullString
+			primaryKey   int
+		)
+		if err := rows.Scan(&cid, &colName, &colType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return false, err
+		}
+		if colName == name {
+			return true, nil
+		}
 	}
+	return false, rows.Err()
 }
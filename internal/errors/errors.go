@@ -1,7 +1,9 @@
 package errors
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 )
 
 // AppError represents a custom application error
@@ -9,6 +11,8 @@ type AppError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 	Detail  string `json:"detail,omitempty"`
+	// Reason distinguishes errors that share a status code, e.g. "quota_exhausted"
+	Reason string `json:"reason,omitempty"`
 }
 
 // Error implements the error interface
@@ -22,6 +26,9 @@ const (
 	ErrUnauthorized       = 401
 	ErrForbidden          = 403
 	ErrNotFound           = 404
+	ErrMethodNotAllowed   = 405
+	ErrConflict           = 409
+	ErrTooManyRequests    = 429
 	ErrInternalServer     = 500
 	ErrServiceUnavailable = 503
 )
@@ -35,6 +42,12 @@ func New(code int, message string, detail string) *AppError {
 	}
 }
 
+// WithReason sets a machine-readable reason and returns the error
+func (e *AppError) WithReason(reason string) *AppError {
+	e.Reason = reason
+	return e
+}
+
 // NewBadRequest creates a new bad request error
 func NewBadRequest(message string, detail string) *AppError {
 	return New(ErrBadRequest, message, detail)
@@ -49,3 +62,30 @@ func NewUnauthorized(message string, detail string) *AppError {
 func NewInternalServer(message string, detail string) *AppError {
 	return New(ErrInternalServer, message, detail)
 }
+
+// NewForbidden creates a new forbidden error
+func NewForbidden(message string, detail string) *AppError {
+	return New(ErrForbidden, message, detail)
+}
+
+// NewMethodNotAllowed creates a new method not allowed error
+func NewMethodNotAllowed(message string, detail string) *AppError {
+	return New(ErrMethodNotAllowed, message, detail)
+}
+
+// NewTooManyRequests creates a new too many requests error
+func NewTooManyRequests(message string, detail string) *AppError {
+	return New(ErrTooManyRequests, message, detail)
+}
+
+// NewServiceUnavailable creates a new service unavailable error
+func NewServiceUnavailable(message string, detail string) *AppError {
+	return New(ErrServiceUnavailable, message, detail)
+}
+
+// Write sends the error to the client as a JSON response
+func Write(w http.ResponseWriter, err *AppError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.Code)
+	json.NewEncoder(w).Encode(err)
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/cursorrules-golang/internal/apikeys"
+	"example.com/cursorrules-golang/internal/authz"
+	apperrors "example.com/cursorrules-golang/internal/errors"
+)
+
+// CreateAPIKeyRequest represents the body of an API key creation request
+type CreateAPIKeyRequest struct {
+	Name      string             `json:"name"`
+	Scopes    []authz.Permission `json:"scopes"`
+	ExpiresAt *time.Time         `json:"expires_at,omitempty"`
+}
+
+// CreateAPIKeyResponse carries the plaintext key, which is only ever shown once
+type CreateAPIKeyResponse struct {
+	Key    string       `json:"key"`
+	APIKey *apikeys.Key `json:"api_key"`
+}
+
+// APIKeysHandler lists and creates API keys for the caller
+func APIKeysHandler(store *apikeys.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := keyManager(w, r)
+		if !ok {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			listAPIKeys(w, r, store, principal)
+		case http.MethodPost:
+			createAPIKey(w, r, store, principal)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// APIKeyHandler revokes a single API key
+func APIKeyHandler(store *apikeys.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.URL.Path[len("/api-keys/"):], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+			return
+		}
+
+		principal, ok := keyManager(w, r)
+		if !ok {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodDelete:
+			revokeAPIKey(w, r, store, principal, id)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func listAPIKeys(w http.ResponseWriter, r *http.Request, store *apikeys.Store, principal *authz.Principal) {
+	keys, err := store.List(r.Context(), ownerScope(principal))
+	if err != nil {
+		http.Error(w, "Failed to list API keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+func createAPIKey(w http.ResponseWriter, r *http.Request, store *apikeys.Store, principal *authz.Principal) {
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, apperrors.NewBadRequest("Invalid input", err.Error()))
+		return
+	}
+
+	if req.Name == "" || len(req.Scopes) == 0 {
+		apperrors.Write(w, apperrors.NewBadRequest("Invalid input", "name and scopes are required"))
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		apperrors.Write(w, apperrors.NewBadRequest("Invalid input", "expires_at must be in the future"))
+		return
+	}
+	// A key can never grant more than its creator holds
+	for _, scope := range req.Scopes {
+		if !principal.Has(scope) {
+			apperrors.Write(w, apperrors.NewForbidden("Insufficient permissions",
+				"cannot grant scope "+string(scope)))
+			return
+		}
+	}
+
+	plaintext, key, err := store.Create(r.Context(), principal.ID, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{Key: plaintext, APIKey: key})
+}
+
+func revokeAPIKey(w http.ResponseWriter, r *http.Request, store *apikeys.Store, principal *authz.Principal, id int64) {
+	err := store.Revoke(r.Context(), id, ownerScope(principal))
+	if errors.Is(err, apikeys.ErrNotFound) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeKeysOnDelete returns a UserHandler hook that revokes a deleted
+// user's API keys
+func RevokeKeysOnDelete(store *apikeys.Store) UserChangedFunc {
+	return func(ctx context.Context, change UserChange) {
+		if !change.Deleted {
+			return
+		}
+		if err := store.RevokeOwner(ctx, strconv.Itoa(change.ID)); err != nil {
+			log.Printf("Failed to revoke API keys of deleted user %d: %v", change.ID, err)
+		}
+	}
+}
+
+// keyManager returns the caller if they may manage API keys. Keys cannot
+// manage keys, so that a key cannot outlive its expiry or revocation by
+// minting a copy of itself.
+func keyManager(w http.ResponseWriter, r *http.Request) (*authz.Principal, bool) {
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		apperrors.Write(w, apperrors.NewUnauthorized("Authentication required", ""))
+		return nil, false
+	}
+	if principal.KeyID != 0 {
+		apperrors.Write(w, apperrors.NewForbidden("Access denied", "API keys cannot manage API keys"))
+		return nil, false
+	}
+	return principal, true
+}
+
+// ownerScope restricts key management to the caller's own keys unless they are an admin
+func ownerScope(principal *authz.Principal) string {
+	if principal.Has(authz.Admin) {
+		return ""
+	}
+	return principal.ID
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/cursorrules-golang/internal/apikeys"
+	"example.com/cursorrules-golang/internal/authz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeysRefuseKeyCallers(t *testing.T) {
+	db := createTestDB(t)
+	store := apikeys.NewStore(db)
+	user := authz.NewPrincipal("1", authz.RoleUser)
+
+	w := httptest.NewRecorder()
+	APIKeysHandler(store).ServeHTTP(w, newUserRequest(http.MethodPost, "/api-keys",
+		`{"name":"job","scopes":["users:read"]}`, user))
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created CreateAPIKeyResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+
+	// A caller holding only the key cannot mint, list or revoke keys with it
+	keyCaller := &authz.Principal{ID: "1", Permissions: created.APIKey.Scopes, KeyID: created.APIKey.ID}
+	tests := []struct {
+		name    string
+		handler http.Handler
+		method  string
+		target  string
+		body    string
+	}{
+		{name: "create", handler: APIKeysHandler(store), method: http.MethodPost, target: "/api-keys",
+			body: `{"name":"copy","scopes":["users:read"]}`},
+		{name: "list", handler: APIKeysHandler(store), method: http.MethodGet, target: "/api-keys"},
+		{name: "revoke", handler: APIKeyHandler(store), method: http.MethodDelete, target: "/api-keys/1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, newUserRequest(tt.method, tt.target, tt.body, keyCaller))
+			assert.Equal(t, http.StatusForbidden, w.Code)
+		})
+	}
+
+	keys, err := store.List(context.Background(), "1")
+	require.NoError(t, err)
+	assert.Len(t, keys, 1, "no key was minted")
+	assert.Nil(t, keys[0].RevokedAt)
+}
+
+func TestDeletingUserRevokesKeys(t *testing.T) {
+	db := createTestDB(t)
+	store := apikeys.NewStore(db)
+	ctx := context.Background()
+	plaintext, _, err := store.Create(ctx, "1", "job", []authz.Permission{authz.UsersRead}, nil)
+	require.NoError(t, err)
+
+	admin := authz.NewPrincipal("2", authz.RoleAdmin)
+	w := httptest.NewRecorder()
+	UserHandler(db, RevokeKeysOnDelete(store)).ServeHTTP(w, newUserRequest(http.MethodDelete, "/users/1", "", admin))
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	_, err = store.Authenticate(ctx, plaintext)
+	assert.ErrorIs(t, err, apikeys.ErrRevokedKey)
+}
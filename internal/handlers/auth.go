@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/cursorrules-golang/internal/auth"
+	apperrors "example.com/cursorrules-golang/internal/errors"
+	"example.com/cursorrules-golang/internal/metrics"
+	"example.com/cursorrules-golang/internal/middleware"
+)
+
+// Login failure reasons reported to metrics
+const (
+	loginReasonBadCredentials = "bad_credentials"
+	loginReasonLockedOut      = "locked_out"
+	loginReasonBadOTP         = "bad_otp"
+)
+
+// LoginRequest represents the body of a login request
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	// OTP or RecoveryCode is required for accounts with two-factor authentication
+	OTP          string `json:"otp,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// LoginResponse carries the issued access token
+type LoginResponse struct {
+	Token     string `json:"token"`
+	TokenType string `json:"token_type"`
+}
+
+// UnlockRequest names the account and/or client address to unlock
+type UnlockRequest struct {
+	Email string `json:"email,omitempty"`
+	IP    string `json:"ip,omitempty"`
+}
+
+// LoginHandler exchanges an email and password for a JWT, locking out
+// accounts and clients that fail repeatedly
+func LoginHandler(db *sql.DB, guard *auth.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req LoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" || req.Password == "" {
+			apperrors.Write(w, apperrors.NewBadRequest("Invalid input", "email and password are required"))
+			return
+		}
+
+		account := strings.ToLower(req.Email)
+		clientIP := middleware.ClientIP(r)
+
+		if wait := guard.Locked(account, clientIP); wait > 0 {
+			metrics.GetMetrics().RecordAuthFailureReason(loginReasonLockedOut)
+			middleware.Audit(r, "login_locked", "account="+account)
+			writeLockedOut(w, wait)
+			return
+		}
+
+		var user loginUser
+		err := db.QueryRow(`SELECT id, role, password_hash, totp_secret, totp_enabled, totp_last_step
+			FROM users WHERE lower(email) = ?`, account).
+			Scan(&user.id, &user.role, &user.passwordHash, &user.totpSecret, &user.totpEnabled, &user.totpLastStep)
+		if err != nil && err != sql.ErrNoRows {
+			http.Error(w, "Failed to query user", http.StatusInternalServerError)
+			return
+		}
+
+		// Unknown accounts still pay for a hash so that they cannot be told apart
+		ok, err := auth.CheckPasswordOrDummy(user.passwordHash.String, req.Password)
+		if err != nil {
+			http.Error(w, "Failed to verify password", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			failLogin(w, r, guard, account, clientIP, loginReasonBadCredentials, "Invalid email or password")
+			return
+		}
+
+		amr := []string{middleware.AMRPassword}
+		if user.totpEnabled {
+			if req.OTP == "" && req.RecoveryCode == "" {
+				apperrors.Write(w, apperrors.NewUnauthorized("Two-factor code required", "mfa_required"))
+				return
+			}
+
+			method, ok, err := verifySecondFactor(db, &user, req)
+			if err != nil {
+				http.Error(w, "Failed to verify two-factor code", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				failLogin(w, r, guard, account, clientIP, loginReasonBadOTP, "Invalid two-factor code")
+				return
+			}
+			if method != "" {
+				amr = append(amr, method)
+			}
+			amr = append(amr, middleware.AMRMFA)
+		}
+
+		guard.Succeed(account)
+		token, err := middleware.GenerateToken(strconv.Itoa(user.id), user.role, amr...)
+		if err != nil {
+			http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+			return
+		}
+
+		middleware.Audit(r, "login_success", "account="+account)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(LoginResponse{Token: token, TokenType: "Bearer"})
+	}
+}
+
+// loginUser holds the credentials loaded for a login attempt
+type loginUser struct {
+	id           int
+	role         string
+	passwordHash sql.NullString
+	totpSecret   sql.NullString
+	totpEnabled  bool
+	totpLastStep int64
+}
+
+// failLogin records a failed attempt against the lockout guard and metrics
+func failLogin(w http.ResponseWriter, r *http.Request, guard *auth.Guard, account, clientIP, reason, message string) {
+	metrics.GetMetrics().RecordAuthFailureReason(reason)
+	middleware.Audit(r, "login_failure", "account="+account+" reason="+reason)
+	if wait := guard.Fail(account, clientIP); wait > 0 {
+		middleware.Audit(r, "login_lockout", "account="+account+" duration="+wait.String())
+	}
+	apperrors.Write(w, apperrors.NewUnauthorized(message, ""))
+}
+
+// verifySecondFactor checks a TOTP code or consumes a recovery code and
+// returns the authentication method reference, if any, to record in the token
+func verifySecondFactor(db *sql.DB, user *loginUser, req LoginRequest) (string, bool, error) {
+	if req.OTP != "" {
+		step, ok := auth.ValidateTOTP(user.totpSecret.String, req.OTP, time.Now(), user.totpLastStep)
+		if !ok {
+			return "", false, nil
+		}
+		// Advancing the last step only if nobody beat us to it rejects replayed codes
+		result, err := db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?",
+			step, user.id, step)
+		if err != nil {
+			return "", false, err
+		}
+		n, err := result.RowsAffected()
+		return middleware.AMROTP, n == 1, err
+	}
+
+	result, err := db.Exec(`UPDATE recovery_codes SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		time.Now().UTC(), user.id, auth.HashRecoveryCode(req.RecoveryCode))
+	if err != nil {
+		return "", false, err
+	}
+	// Recovery codes have no RFC 8176 method; the token only records mfa
+	n, err := result.RowsAffected()
+	return "", n == 1, err
+}
+
+// UnlockHandler lets admins clear login lockouts for an account or client address
+func UnlockHandler(guard *auth.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req UnlockRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Email == "" && req.IP == "") {
+			apperrors.Write(w, apperrors.NewBadRequest("Invalid input", "email or ip is required"))
+			return
+		}
+
+		if req.Email != "" {
+			guard.UnlockAccount(strings.ToLower(req.Email))
+		}
+		if req.IP != "" {
+			guard.UnlockClient(req.IP)
+		}
+
+		middleware.Audit(r, "login_unlock", "account="+req.Email+" client="+req.IP)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeLockedOut(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	apperrors.Write(w, apperrors.NewTooManyRequests("Too many failed login attempts",
+		"try again in "+wait.Round(time.Second).String()))
+}
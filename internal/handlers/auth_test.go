@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/cursorrules-golang/internal/auth"
+	"example.com/cursorrules-golang/internal/authz"
+	"example.com/cursorrules-golang/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestGuard() *auth.Guard {
+	return auth.NewGuard(
+		auth.LockoutConfig{Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour},
+		auth.LockoutConfig{Threshold: 10, BaseDelay: time.Minute, MaxDelay: time.Hour},
+	)
+}
+
+// setPasswordIterations lowers the hashing cost so tests stay fast
+func setPasswordIterations(t *testing.T, n int) {
+	previous := auth.PasswordIterations
+	auth.PasswordIterations = n
+	t.Cleanup(func() { auth.PasswordIterations = previous })
+}
+
+func login(handler http.Handler, email, password, remoteAddr string) *httptest.ResponseRecorder {
+	return loginFrom(handler, LoginRequest{Email: email, Password: password}, remoteAddr)
+}
+
+func loginFrom(handler http.Handler, login LoginRequest, remoteAddr string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(login)
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(string(body)))
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestLoginHandler(t *testing.T) {
+	setPasswordIterations(t, 1000)
+	db := createTestDB(t)
+	hash, err := auth.HashPassword("s3cret")
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE users SET password_hash = ? WHERE id = 1", hash)
+	require.NoError(t, err)
+
+	handler := LoginHandler(db, newTestGuard())
+
+	w := login(handler, "TEST@example.com", "s3cret", "10.0.0.1:1234")
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp LoginResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.NotEmpty(t, resp.Token)
+	assert.Equal(t, "Bearer", resp.TokenType)
+
+	assert.Equal(t, http.StatusUnauthorized, login(handler, "test@example.com", "wrong", "10.0.0.1:1234").Code)
+	assert.Equal(t, http.StatusUnauthorized, login(handler, "nobody@example.com", "s3cret", "10.0.0.1:1234").Code)
+}
+
+func TestLoginLockout(t *testing.T) {
+	setPasswordIterations(t, 1000)
+	db := createTestDB(t)
+	hash, err := auth.HashPassword("s3cret")
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE users SET password_hash = ? WHERE id = 1", hash)
+	require.NoError(t, err)
+
+	guard := newTestGuard()
+	handler := LoginHandler(db, guard)
+	before := metrics.GetMetrics().GetSnapshot()
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login(handler, "test@example.com", "wrong", "10.0.0.1:1234").Code)
+	}
+
+	// The correct password is refused while the account is locked, from any address
+	w := login(handler, "test@example.com", "s3cret", "10.0.0.2:1234")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	snapshot := metrics.GetMetrics().GetSnapshot()
+	assert.Equal(t, before.AuthFailuresByReason[loginReasonBadCredentials]+3, snapshot.AuthFailuresByReason[loginReasonBadCredentials])
+	assert.Equal(t, before.AuthFailuresByReason[loginReasonLockedOut]+1, snapshot.AuthFailuresByReason[loginReasonLockedOut])
+
+	// An admin unlock restores access
+	unlock := UnlockHandler(guard)
+	req := httptest.NewRequest(http.MethodPost, "/auth/unlock", strings.NewReader(`{"email":"test@example.com"}`))
+	uw := httptest.NewRecorder()
+	unlock.ServeHTTP(uw, req)
+	require.Equal(t, http.StatusNoContent, uw.Code)
+
+	assert.Equal(t, http.StatusOK, login(handler, "test@example.com", "s3cret", "10.0.0.2:1234").Code)
+}
+
+func TestCreateUserRoleRequiresAdmin(t *testing.T) {
+	setPasswordIterations(t, 1000)
+	db := createTestDB(t)
+	handler := UsersHandler(db)
+	body := `{"name":"boss","email":"boss@example.com","age":50,"password":"pw","role":"admin"}`
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newUserRequest(http.MethodPost, "/users", body, authz.NewPrincipal("1", authz.RoleUser)))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newUserRequest(http.MethodPost, "/users", `{"name":"x","email":"x@example.com","age":1,"role":"root"}`,
+		authz.NewPrincipal("1", authz.RoleAdmin)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
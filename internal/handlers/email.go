@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/cursorrules-golang/internal/auth"
+	"example.com/cursorrules-golang/internal/authz"
+	apperrors "example.com/cursorrules-golang/internal/errors"
+	"example.com/cursorrules-golang/internal/mailer"
+	"example.com/cursorrules-golang/internal/middleware"
+	"example.com/cursorrules-golang/internal/models"
+)
+
+// Lifetimes of emailed links
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	passwordResetTTL = time.Hour
+)
+
+// minPasswordLength is enforced when a password is reset
+const minPasswordLength = 8
+
+// AccountMail bundles what the email-based account flows need
+type AccountMail struct {
+	Tokens *auth.ActionTokens
+	Mailer mailer.Mailer
+	// BaseURL is prepended to the links sent by email, e.g. https://api.example.com
+	BaseURL string
+	// Background runs work that must not delay the response, so that its
+	// duration cannot be measured by the caller. Nil starts a goroutine.
+	Background func(task func())
+}
+
+// ForgotPasswordRequest names the account that forgot its password
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest carries the emailed token and the new password
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// SendVerification emails the user a link that confirms they own their address
+func (m AccountMail) SendVerification(ctx context.Context, user models.User) error {
+	token, err := m.Tokens.Issue(ctx, auth.PurposeVerifyEmail, int64(user.ID), user.Email, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	link := m.BaseURL + "/auth/verify?token=" + url.QueryEscape(token)
+	return m.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening this link within %s:\n\n%s\n",
+			user.Name, verifyEmailTTL, link),
+	})
+}
+
+// sendPasswordReset emails the user a link that lets them choose a new password
+func (m AccountMail) sendPasswordReset(ctx context.Context, user models.User) error {
+	token, err := m.Tokens.Issue(ctx, auth.PurposePasswordReset, int64(user.ID), user.Email, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return m.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset your password. If it was you, submit this token "+
+			"to %s/auth/reset within %s:\n\n%s\n\nOtherwise you can ignore this email.\n",
+			user.Name, m.BaseURL, passwordResetTTL, token),
+	})
+}
+
+// background runs task without waiting for it
+func (m AccountMail) background(task func()) {
+	if m.Background != nil {
+		m.Background(task)
+		return
+	}
+	go task()
+}
+
+// VerificationOnCreate returns a UsersHandler hook that emails new users a
+// verification link in the background, so a slow mail server does not hold
+// up user creation
+func (m AccountMail) VerificationOnCreate() UserCreatedFunc {
+	return func(ctx context.Context, user models.User) {
+		// The request context is cancelled once the response is written
+		ctx = context.WithoutCancel(ctx)
+		m.background(func() {
+			if err := m.SendVerification(ctx, user); err != nil {
+				log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+			}
+		})
+	}
+}
+
+// ForgotPasswordHandler emails a reset link. It always answers 202, and sends
+// in the background, so that neither the status nor the response time tells
+// callers which addresses have accounts.
+func ForgotPasswordHandler(db *sql.DB, mail AccountMail) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ForgotPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			apperrors.Write(w, apperrors.NewBadRequest("Invalid input", "email is required"))
+			return
+		}
+
+		var user models.User
+		err := db.QueryRow("SELECT id, name, email, age FROM users WHERE lower(email) = ?", strings.ToLower(req.Email)).
+			Scan(&user.ID, &user.Name, &user.Email, &user.Age)
+		switch {
+		case err == sql.ErrNoRows:
+			middleware.Audit(r, "password_reset_unknown", "")
+		case err != nil:
+			log.Printf("Failed to look up user for password reset: %v", err)
+		default:
+			// The request context is cancelled once the response is written
+			ctx := context.WithoutCancel(r.Context())
+			mail.background(func() {
+				if err := mail.sendPasswordReset(ctx, user); err != nil {
+					log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+				}
+			})
+			middleware.Audit(r, "password_reset_requested", "user="+strconv.Itoa(user.ID))
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// ResetPasswordHandler sets a new password using an emailed reset token
+func ResetPasswordHandler(db *sql.DB, tokens *auth.ActionTokens, guard *auth.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ResetPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			apperrors.Write(w, apperrors.NewBadRequest("Invalid input", "token and password are required"))
+			return
+		}
+		if len(req.Password) < minPasswordLength {
+			apperrors.Write(w, apperrors.NewBadRequest("Invalid input",
+				fmt.Sprintf("password must be at least %d characters", minPasswordLength)))
+			return
+		}
+
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			return
+		}
+
+		// The token is only used up if the new password is stored, so a failed
+		// reset can be retried with the same link
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "Failed to update password", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		claims, ok := consumeActionToken(w, r, tokens, tx, auth.PurposePasswordReset, req.Token)
+		if !ok {
+			return
+		}
+		userID := claims.UserID
+
+		// Receiving the email also proves ownership of the address, as long as
+		// it is still the user's
+		var email string
+		err = tx.QueryRowContext(r.Context(), `UPDATE users SET password_hash = ?, email_verified = 1
+			WHERE id = ? AND lower(email) = lower(?) RETURNING email`,
+			hash, userID, claims.Email).Scan(&email)
+		if err == sql.ErrNoRows {
+			rejectStaleActionToken(w, r, auth.PurposePasswordReset)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to update password", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to update password", http.StatusInternalServerError)
+			return
+		}
+
+		// Older reset links must not outlive the password they were meant to replace
+		if err := tokens.Revoke(r.Context(), auth.PurposePasswordReset, userID); err != nil {
+			log.Printf("Failed to revoke reset tokens for user %d: %v", userID, err)
+		}
+		guard.UnlockAccount(strings.ToLower(email))
+
+		middleware.Audit(r, "password_reset", "user="+strconv.FormatInt(userID, 10))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// VerifyEmailHandler marks an address as verified using an emailed token
+func VerifyEmailHandler(db *sql.DB, tokens *auth.ActionTokens) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			apperrors.Write(w, apperrors.NewBadRequest("Invalid input", "token is required"))
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		claims, ok := consumeActionToken(w, r, tokens, tx, auth.PurposeVerifyEmail, token)
+		if !ok {
+			return
+		}
+
+		// Only the address the link was sent to is verified
+		result, err := tx.ExecContext(r.Context(),
+			"UPDATE users SET email_verified = 1 WHERE id = ? AND lower(email) = lower(?)",
+			claims.UserID, claims.Email)
+		if err != nil {
+			http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+			return
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+			return
+		} else if n == 0 {
+			rejectStaleActionToken(w, r, auth.PurposeVerifyEmail)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+			return
+		}
+
+		middleware.Audit(r, "email_verified", "user="+strconv.FormatInt(claims.UserID, 10))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "verified"})
+	}
+}
+
+// ResendVerificationHandler emails the caller a fresh verification link
+func ResendVerificationHandler(db *sql.DB, mail AccountMail) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		principal, ok := authz.PrincipalFromContext(r.Context())
+		if !ok {
+			apperrors.Write(w, apperrors.NewUnauthorized("Authentication required", ""))
+			return
+		}
+
+		var user models.User
+		var verified bool
+		err := db.QueryRow("SELECT id, name, email, age, email_verified FROM users WHERE id = ?", principal.ID).
+			Scan(&user.ID, &user.Name, &user.Email, &user.Age, &verified)
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to query user", http.StatusInternalServerError)
+			return
+		}
+		if verified {
+			apperrors.Write(w, apperrors.New(apperrors.ErrConflict, "Email already verified", ""))
+			return
+		}
+
+		if err := mail.SendVerification(r.Context(), user); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+			http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// consumeActionToken uses up the token within tx. It writes the error response
+// and reports false when the token is unusable.
+func consumeActionToken(w http.ResponseWriter, r *http.Request, tokens *auth.ActionTokens, tx *sql.Tx, purpose auth.Purpose, token string) (auth.ActionClaims, bool) {
+	claims, err := tokens.ConsumeTx(r.Context(), tx, purpose, token)
+	switch {
+	case errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrTokenExpired), errors.Is(err, auth.ErrTokenUsed):
+		middleware.Audit(r, "action_token_rejected", "purpose="+string(purpose)+" error="+err.Error())
+		apperrors.Write(w, apperrors.NewBadRequest("Invalid or expired token", err.Error()))
+		return auth.ActionClaims{}, false
+	case err != nil:
+		http.Error(w, "Failed to verify token", http.StatusInternalServerError)
+		return auth.ActionClaims{}, false
+	}
+	return claims, true
+}
+
+// rejectStaleActionToken answers a valid token whose user is gone or whose
+// address has changed since it was sent
+func rejectStaleActionToken(w http.ResponseWriter, r *http.Request, purpose auth.Purpose) {
+	middleware.Audit(r, "action_token_rejected", "purpose="+string(purpose)+" error=address changed")
+	apperrors.Write(w, apperrors.NewBadRequest("Invalid or expired token",
+		"the email address has changed since the token was sent"))
+}
+
+// RevokeOnEmailChange returns a UserHandler hook that voids the links sent
+// to a user's previous address when it changes
+func (m AccountMail) RevokeOnEmailChange() UserChangedFunc {
+	return func(ctx context.Context, change UserChange) {
+		if !change.EmailChanged {
+			return
+		}
+		for _, purpose := range []auth.Purpose{auth.PurposeVerifyEmail, auth.PurposePasswordReset} {
+			if err := m.Tokens.Revoke(ctx, purpose, int64(change.ID)); err != nil {
+				log.Printf("Failed to revoke %s tokens for user %d: %v", purpose, change.ID, err)
+			}
+		}
+	}
+}
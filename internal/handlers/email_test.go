@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/cursorrules-golang/internal/auth"
+	"example.com/cursorrules-golang/internal/authz"
+	"example.com/cursorrules-golang/internal/mailer"
+	"example.com/cursorrules-golang/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	verifyLinkPattern = regexp.MustCompile(`/auth/verify\?token=(\S+)`)
+	resetTokenPattern = regexp.MustCompile(`(?m)^([A-Za-z0-9_-]+\.[A-Za-z0-9_-]+)$`)
+)
+
+func newTestAccountMail(db *sql.DB) (AccountMail, *bytes.Buffer) {
+	outbox := &bytes.Buffer{}
+	return AccountMail{
+		Tokens:  auth.NewActionTokens(db, []byte("test-secret")),
+		Mailer:  mailer.NewSinkMailer(outbox),
+		BaseURL: "http://api.test",
+		// Send synchronously so that tests can read the outbox straight away
+		Background: func(task func()) { task() },
+	}, outbox
+}
+
+func TestEmailVerificationFlow(t *testing.T) {
+	setPasswordIterations(t, 1000)
+	db := createTestDB(t)
+	mail, outbox := newTestAccountMail(db)
+
+	w := httptest.NewRecorder()
+	UsersHandler(db, mail.VerificationOnCreate()).ServeHTTP(w, newUserRequest(http.MethodPost, "/users",
+		`{"name":"new_user","email":"new@example.com","age":20}`, authz.NewPrincipal("1", authz.RoleAdmin)))
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, outbox.String(), "To: new@example.com")
+
+	match := verifyLinkPattern.FindStringSubmatch(outbox.String())
+	require.NotNil(t, match, "verification email must contain a link")
+	token, err := url.QueryUnescape(match[1])
+	require.NoError(t, err)
+
+	verify := VerifyEmailHandler(db, mail.Tokens)
+	w = httptest.NewRecorder()
+	verify.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/verify?token="+url.QueryEscape(token), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var verified bool
+	require.NoError(t, db.QueryRow("SELECT email_verified FROM users WHERE email = 'new@example.com'").Scan(&verified))
+	assert.True(t, verified)
+
+	// Tokens are single-use
+	w = httptest.NewRecorder()
+	verify.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/verify?token="+url.QueryEscape(token), nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	setPasswordIterations(t, 1000)
+	db := createTestDB(t)
+	mail, outbox := newTestAccountMail(db)
+	guard := newTestGuard()
+
+	// Unknown addresses get the same answer and no email
+	w := httptest.NewRecorder()
+	ForgotPasswordHandler(db, mail).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/forgot",
+		strings.NewReader(`{"email":"nobody@example.com"}`)))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, outbox.String())
+
+	w = httptest.NewRecorder()
+	ForgotPasswordHandler(db, mail).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/forgot",
+		strings.NewReader(`{"email":"TEST@example.com"}`)))
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	match := resetTokenPattern.FindStringSubmatch(outbox.String())
+	require.NotNil(t, match, "reset email must contain a token")
+	token := match[1]
+
+	reset := ResetPasswordHandler(db, mail.Tokens, guard)
+	resetWith := func(token, password string) int {
+		w := httptest.NewRecorder()
+		reset.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/reset",
+			strings.NewReader(`{"token":"`+token+`","password":"`+password+`"}`)))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, resetWith(token, "short"))
+	assert.Equal(t, http.StatusBadRequest, resetWith(token+"x", "new-password"), "tampered token")
+	assert.Equal(t, http.StatusNoContent, resetWith(token, "new-password"))
+	assert.Equal(t, http.StatusBadRequest, resetWith(token, "another-password"), "token is single-use")
+
+	assert.Equal(t, http.StatusOK, login(LoginHandler(db, guard), "test@example.com", "new-password", "10.0.0.1:1").Code)
+}
+
+func TestPasswordResetRetryableAfterFailure(t *testing.T) {
+	setPasswordIterations(t, 1000)
+	db := createTestDB(t)
+	mail, outbox := newTestAccountMail(db)
+
+	w := httptest.NewRecorder()
+	ForgotPasswordHandler(db, mail).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/forgot",
+		strings.NewReader(`{"email":"test@example.com"}`)))
+	require.Equal(t, http.StatusAccepted, w.Code)
+	match := resetTokenPattern.FindStringSubmatch(outbox.String())
+	require.NotNil(t, match, "reset email must contain a token")
+
+	reset := ResetPasswordHandler(db, mail.Tokens, newTestGuard())
+	resetWith := func() int {
+		w := httptest.NewRecorder()
+		reset.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/reset",
+			strings.NewReader(`{"token":"`+match[1]+`","password":"new-password"}`)))
+		return w.Code
+	}
+
+	// Make storing the password fail once
+	_, err := db.Exec(`CREATE TRIGGER fail_reset BEFORE UPDATE OF password_hash ON users
+		BEGIN SELECT RAISE(ABORT, 'unavailable'); END`)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resetWith())
+
+	_, err = db.Exec("DROP TRIGGER fail_reset")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resetWith(), "the failed attempt must not use up the token")
+	assert.Equal(t, http.StatusBadRequest, resetWith(), "token is single-use")
+}
+
+// mailerFunc adapts a function to mailer.Mailer
+type mailerFunc func(ctx context.Context, msg mailer.Message) error
+
+func (f mailerFunc) Send(ctx context.Context, msg mailer.Message) error { return f(ctx, msg) }
+
+func TestAccountMailSentInBackground(t *testing.T) {
+	tests := []struct {
+		name           string
+		handler        func(db *sql.DB, mail AccountMail) http.Handler
+		req            *http.Request
+		expectedStatus int
+		expectedTo     string
+	}{
+		{
+			name:           "forgot password",
+			handler:        func(db *sql.DB, mail AccountMail) http.Handler { return ForgotPasswordHandler(db, mail) },
+			req:            httptest.NewRequest(http.MethodPost, "/auth/forgot", strings.NewReader(`{"email":"test@example.com"}`)),
+			expectedStatus: http.StatusAccepted,
+			expectedTo:     "test@example.com",
+		},
+		{
+			name: "verification on create",
+			handler: func(db *sql.DB, mail AccountMail) http.Handler {
+				return UsersHandler(db, mail.VerificationOnCreate())
+			},
+			req: newUserRequest(http.MethodPost, "/users", `{"name":"new_user","email":"new@example.com","age":20}`,
+				authz.NewPrincipal("1", authz.RoleAdmin)),
+			expectedStatus: http.StatusCreated,
+			expectedTo:     "new@example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := createTestDB(t)
+			mail, _ := newTestAccountMail(db)
+
+			release := make(chan struct{})
+			sent := make(chan mailer.Message, 1)
+			mail.Mailer = mailerFunc(func(ctx context.Context, msg mailer.Message) error {
+				<-release
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				sent <- msg
+				return nil
+			})
+			var pending sync.WaitGroup
+			mail.Background = func(task func()) {
+				pending.Add(1)
+				go func() {
+					defer pending.Done()
+					task()
+				}()
+			}
+
+			ctx, cancel := context.WithCancel(tt.req.Context())
+			w := httptest.NewRecorder()
+			answered := make(chan struct{})
+			go func() {
+				tt.handler(db, mail).ServeHTTP(w, tt.req.WithContext(ctx))
+				close(answered)
+			}()
+
+			select {
+			case <-answered:
+			case <-time.After(5 * time.Second):
+				t.Fatal("handler waited for the email to be sent")
+			}
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			// The server cancels the request context after responding; the send must survive it
+			cancel()
+			close(release)
+			pending.Wait()
+
+			require.Len(t, sent, 1)
+			assert.Equal(t, tt.expectedTo, (<-sent).To)
+		})
+	}
+}
+
+func TestEmailLinksVoidedByAddressChange(t *testing.T) {
+	owner := authz.NewPrincipal("1", authz.RoleUser)
+	tests := []struct {
+		name   string
+		revoke bool
+	}{
+		{name: "tokens revoked on change", revoke: true},
+		// The address bound into the token protects even if revocation fails
+		{name: "address checked on use", revoke: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setPasswordIterations(t, 1000)
+			db := createTestDB(t)
+			mail, outbox := newTestAccountMail(db)
+			guard := newTestGuard()
+			var onChange []UserChangedFunc
+			if tt.revoke {
+				onChange = append(onChange, mail.RevokeOnEmailChange())
+			}
+
+			// Links are sent to the old address
+			user := models.User{ID: 1, Name: "test_user", Email: "test@example.com"}
+			require.NoError(t, mail.SendVerification(context.Background(), user))
+			require.NoError(t, mail.sendPasswordReset(context.Background(), user))
+			verifyMatch := verifyLinkPattern.FindStringSubmatch(outbox.String())
+			resetMatch := resetTokenPattern.FindStringSubmatch(outbox.String())
+			require.NotNil(t, verifyMatch)
+			require.NotNil(t, resetMatch)
+
+			w := httptest.NewRecorder()
+			UserHandler(db, onChange...).ServeHTTP(w, newUserRequest(http.MethodPatch, "/users/1",
+				`{"email":"new@example.com"}`, owner))
+			require.Equal(t, http.StatusOK, w.Code)
+
+			// Neither link may verify the new address, which was never mailed
+			w = httptest.NewRecorder()
+			VerifyEmailHandler(db, mail.Tokens).ServeHTTP(w, httptest.NewRequest(http.MethodGet,
+				"/auth/verify?token="+verifyMatch[1], nil))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			w = httptest.NewRecorder()
+			ResetPasswordHandler(db, mail.Tokens, guard).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/reset",
+				strings.NewReader(`{"token":"`+resetMatch[1]+`","password":"new-password"}`)))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			var verified bool
+			require.NoError(t, db.QueryRow("SELECT email_verified FROM users WHERE id = 1").Scan(&verified))
+			assert.False(t, verified)
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"example.com/cursorrules-golang/internal/auth"
+	"example.com/cursorrules-golang/internal/authz"
+	apperrors "example.com/cursorrules-golang/internal/errors"
+	"example.com/cursorrules-golang/internal/middleware"
+)
+
+// recoveryCodeCount is the number of recovery codes issued on enrollment
+const recoveryCodeCount = 10
+
+// TOTPEnrollResponse carries the secret to load into an authenticator app
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// TOTPConfirmRequest carries the first code generated from a new secret
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+// TOTPConfirmResponse carries recovery codes, which are only ever shown once
+type TOTPConfirmResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TOTPEnrollHandler generates a pending TOTP secret for the caller.
+// Two-factor login is not required until the secret is confirmed.
+func TOTPEnrollHandler(db *sql.DB, issuer string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		principal, ok := totpPrincipal(w, r)
+		if !ok {
+			return
+		}
+
+		var email string
+		var enabled bool
+		err := db.QueryRow("SELECT email, totp_enabled FROM users WHERE id = ?", principal.ID).Scan(&email, &enabled)
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to query user", http.StatusInternalServerError)
+			return
+		}
+		if enabled {
+			apperrors.Write(w, apperrors.New(apperrors.ErrConflict, "Two-factor authentication already enabled", ""))
+			return
+		}
+
+		secret, err := auth.GenerateTOTPSecret()
+		if err != nil {
+			http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+			return
+		}
+		if _, err := db.Exec("UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?", secret, principal.ID); err != nil {
+			http.Error(w, "Failed to store secret", http.StatusInternalServerError)
+			return
+		}
+
+		middleware.Audit(r, "totp_enroll", "")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(TOTPEnrollResponse{
+			Secret:     secret,
+			OTPAuthURI: auth.TOTPURI(issuer, email, secret),
+		})
+	}
+}
+
+// TOTPConfirmHandler activates a pending secret once the caller proves they
+// can generate codes from it, and issues fresh recovery codes
+func TOTPConfirmHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		principal, ok := totpPrincipal(w, r)
+		if !ok {
+			return
+		}
+
+		var req TOTPConfirmRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			apperrors.Write(w, apperrors.NewBadRequest("Invalid input", "code is required"))
+			return
+		}
+
+		var secret sql.NullString
+		var enabled bool
+		err := db.QueryRow("SELECT totp_secret, totp_enabled FROM users WHERE id = ?", principal.ID).Scan(&secret, &enabled)
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to query user", http.StatusInternalServerError)
+			return
+		}
+		if enabled {
+			apperrors.Write(w, apperrors.New(apperrors.ErrConflict, "Two-factor authentication already enabled", ""))
+			return
+		}
+		if !secret.Valid {
+			apperrors.Write(w, apperrors.NewBadRequest("Enrollment not started", "call /auth/totp/enroll first"))
+			return
+		}
+
+		step, ok := auth.ValidateTOTP(secret.String, req.Code, time.Now(), 0)
+		if !ok {
+			apperrors.Write(w, apperrors.NewBadRequest("Invalid two-factor code", ""))
+			return
+		}
+
+		codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+		if err != nil {
+			http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+			return
+		}
+		if err := enableTOTP(db, principal.ID, step, codes); err != nil {
+			http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+
+		middleware.Audit(r, "totp_enabled", "")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(TOTPConfirmResponse{RecoveryCodes: codes})
+	}
+}
+
+// totpPrincipal returns the caller, refusing API keys since enrollment
+// belongs to an interactive user
+func totpPrincipal(w http.ResponseWriter, r *http.Request) (*authz.Principal, bool) {
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		apperrors.Write(w, apperrors.NewUnauthorized("Authentication required", ""))
+		return nil, false
+	}
+	if principal.KeyID != 0 {
+		apperrors.Write(w, apperrors.NewForbidden("Access denied", "API keys cannot manage two-factor authentication"))
+		return nil, false
+	}
+	return principal, true
+}
+
+func enableTOTP(db *sql.DB, userID string, step int64, codes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE id = ?", step, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID, auth.HashRecoveryCode(code)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
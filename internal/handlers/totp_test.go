@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example.com/cursorrules-golang/internal/auth"
+	"example.com/cursorrules-golang/internal/authz"
+	"example.com/cursorrules-golang/internal/middleware"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tokenAMR(t *testing.T, token string) []string {
+	claims := &middleware.Claims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+	return claims.AMR
+}
+
+func TestTOTPEnrollmentAndLogin(t *testing.T) {
+	setPasswordIterations(t, 1000)
+	db := createTestDB(t)
+	hash, err := auth.HashPassword("s3cret")
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE users SET password_hash = ?, role = 'admin' WHERE id = 1", hash)
+	require.NoError(t, err)
+
+	admin := authz.NewPrincipal("1", authz.RoleAdmin)
+	loginHandler := LoginHandler(db, newTestGuard())
+
+	// Before enrollment a password alone logs in, without the mfa claim
+	w := login(loginHandler, "test@example.com", "s3cret", "10.0.0.1:1")
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp LoginResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, []string{middleware.AMRPassword}, tokenAMR(t, resp.Token))
+
+	// Enroll
+	w = httptest.NewRecorder()
+	TOTPEnrollHandler(db, "Users API").ServeHTTP(w, newUserRequest(http.MethodPost, "/auth/totp/enroll", "", admin))
+	require.Equal(t, http.StatusOK, w.Code)
+	var enroll TOTPEnrollResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&enroll))
+	assert.Contains(t, enroll.OTPAuthURI, "secret="+enroll.Secret)
+
+	// Confirm with a code from the previous period so login can use the current one
+	code, err := auth.TOTPCode(enroll.Secret, time.Now().Add(-30*time.Second))
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	TOTPConfirmHandler(db).ServeHTTP(w, newUserRequest(http.MethodPost, "/auth/totp/confirm", `{"code":"`+code+`"}`, admin))
+	require.Equal(t, http.StatusOK, w.Code)
+	var confirm TOTPConfirmResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&confirm))
+	require.Len(t, confirm.RecoveryCodes, recoveryCodeCount)
+
+	// Password alone is no longer enough
+	w = login(loginHandler, "test@example.com", "s3cret", "10.0.0.1:1")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "mfa_required")
+
+	// A valid code yields an mfa token
+	code, err = auth.TOTPCode(enroll.Secret, time.Now())
+	require.NoError(t, err)
+	w = loginWith(loginHandler, LoginRequest{Email: "test@example.com", Password: "s3cret", OTP: code})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, []string{middleware.AMRPassword, middleware.AMROTP, middleware.AMRMFA}, tokenAMR(t, resp.Token))
+
+	// The same code cannot be replayed
+	w = loginWith(loginHandler, LoginRequest{Email: "test@example.com", Password: "s3cret", OTP: code})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Recovery codes work exactly once
+	recovery := LoginRequest{Email: "test@example.com", Password: "s3cret", RecoveryCode: confirm.RecoveryCodes[0]}
+	w = loginWith(loginHandler, recovery)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, []string{middleware.AMRPassword, middleware.AMRMFA}, tokenAMR(t, resp.Token))
+	assert.Equal(t, http.StatusUnauthorized, loginWith(loginHandler, recovery).Code)
+}
+
+func TestTOTPRejectsAPIKeys(t *testing.T) {
+	db := createTestDB(t)
+	principal := &authz.Principal{ID: "1", Permissions: []authz.Permission{authz.UsersWrite}, KeyID: 4}
+
+	w := httptest.NewRecorder()
+	TOTPEnrollHandler(db, "Users API").ServeHTTP(w, newUserRequest(http.MethodPost, "/auth/totp/enroll", "", principal))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func loginWith(handler http.Handler, req LoginRequest) *httptest.ResponseRecorder {
+	return loginFrom(handler, req, "10.0.0.1:1")
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"example.com/cursorrules-golang/internal/authz"
+	apperrors "example.com/cursorrules-golang/internal/errors"
+	"example.com/cursorrules-golang/internal/middleware"
+	"example.com/cursorrules-golang/internal/quota"
+)
+
+// UsageResponse reports the caller's plan and quota consumption
+type UsageResponse struct {
+	Plan  string        `json:"plan"`
+	Usage []quota.Usage `json:"usage"`
+}
+
+// PlanRequest assigns a quota plan to a user
+type PlanRequest struct {
+	UserID int64  `json:"user_id"`
+	Plan   string `json:"plan"`
+}
+
+// UsageHandler reports the caller's quota usage for the current periods
+func UsageHandler(quotas *quota.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		principal, ok := authz.PrincipalFromContext(r.Context())
+		if !ok {
+			apperrors.Write(w, apperrors.NewUnauthorized("Authentication required", ""))
+			return
+		}
+
+		plan, usage, err := quotas.Usage(r.Context(), principal.ID)
+		if err != nil {
+			http.Error(w, "Failed to load usage", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UsageResponse{Plan: plan, Usage: usage})
+	}
+}
+
+// PlanHandler lets admins move a user to another configured quota plan
+func PlanHandler(quotas *quota.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		principal, ok := authz.PrincipalFromContext(r.Context())
+		if !ok {
+			apperrors.Write(w, apperrors.NewUnauthorized("Authentication required", ""))
+			return
+		}
+		if !principal.Has(authz.Admin) {
+			apperrors.Write(w, apperrors.NewForbidden("Insufficient permissions", "only admins may change plans"))
+			return
+		}
+
+		var req PlanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == 0 || req.Plan == "" {
+			apperrors.Write(w, apperrors.NewBadRequest("Invalid input", "user_id and plan are required"))
+			return
+		}
+
+		err := quotas.SetPlan(r.Context(), req.UserID, req.Plan)
+		switch {
+		case errors.Is(err, quota.ErrUnknownPlan):
+			apperrors.Write(w, apperrors.NewBadRequest("Invalid input", "unknown plan "+req.Plan))
+			return
+		case errors.Is(err, quota.ErrUnknownUser):
+			apperrors.Write(w, apperrors.New(apperrors.ErrNotFound, "User not found", ""))
+			return
+		case err != nil:
+			http.Error(w, "Failed to set plan", http.StatusInternalServerError)
+			return
+		}
+
+		middleware.Audit(r, "quota_plan_set", "user="+strconv.FormatInt(req.UserID, 10)+" plan="+req.Plan)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/cursorrules-golang/internal/authz"
+	"example.com/cursorrules-golang/internal/quota"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageHandler(t *testing.T) {
+	db := createTestDB(t)
+	quotas, err := quota.NewManager(db, quota.DefaultPlans())
+	require.NoError(t, err)
+	handler := UsageHandler(quotas)
+
+	_, err = quotas.Consume(newUserRequest(http.MethodGet, "/", "", nil).Context(), "1", "/users/search")
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newUserRequest(http.MethodGet, "/me/usage", "", authz.NewPrincipal("1", authz.RoleUser)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp UsageResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, "free", resp.Plan)
+	require.Len(t, resp.Usage, 2)
+	for _, usage := range resp.Usage {
+		assert.Equal(t, int64(1), usage.Used, usage.Name)
+		assert.Equal(t, usage.Max-1, usage.Remaining, usage.Name)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newUserRequest(http.MethodGet, "/me/usage", "", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestPlanHandler(t *testing.T) {
+	admin := authz.NewPrincipal("3", authz.RoleAdmin)
+
+	tests := []struct {
+		name           string
+		body           string
+		principal      *authz.Principal
+		expectedStatus int
+		expectedPlan   string
+	}{
+		{name: "admin sets plan", body: `{"user_id":1,"plan":"pro"}`, principal: admin, expectedStatus: http.StatusNoContent, expectedPlan: "pro"},
+		{name: "unknown plan", body: `{"user_id":1,"plan":"platinum"}`, principal: admin, expectedStatus: http.StatusBadRequest, expectedPlan: "free"},
+		{name: "unknown user", body: `{"user_id":99,"plan":"pro"}`, principal: admin, expectedStatus: http.StatusNotFound, expectedPlan: "free"},
+		{name: "missing fields", body: `{"plan":"pro"}`, principal: admin, expectedStatus: http.StatusBadRequest, expectedPlan: "free"},
+		{name: "user cannot set plan", body: `{"user_id":1,"plan":"pro"}`, principal: authz.NewPrincipal("1", authz.RoleUser), expectedStatus: http.StatusForbidden, expectedPlan: "free"},
+		{name: "unauthenticated", body: `{"user_id":1,"plan":"pro"}`, principal: nil, expectedStatus: http.StatusUnauthorized, expectedPlan: "free"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := createTestDB(t)
+			quotas, err := quota.NewManager(db, quota.DefaultPlans())
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			PlanHandler(quotas).ServeHTTP(w, newUserRequest(http.MethodPost, "/quota/plan", tt.body, tt.principal))
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			plan, _, err := quotas.Usage(context.Background(), "1")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedPlan, plan)
+		})
+	}
+}
@@ -1,28 +1,133 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"example.com/cursorrules-golang/internal/auth"
+	"example.com/cursorrules-golang/internal/authz"
+	apperrors "example.com/cursorrules-golang/internal/errors"
 	"example.com/cursorrules-golang/internal/models"
 )
 
-func UsersHandler(db *sql.DB) http.HandlerFunc {
+// ResourceUser is the authz resource type for user records
+const ResourceUser = "user"
+
+// userAccess lets admins act on any user and everyone else only on themselves
+var userAccess = authz.NewEngine().Register(ResourceUser,
+	authz.AllowAdmin,
+	authz.AllowOwner(authz.ActionRead, authz.ActionUpdate),
+)
+
+// userActions maps the methods served by UserHandler to authz actions
+var userActions = map[string]authz.Action{
+	http.MethodGet:    authz.ActionRead,
+	http.MethodPut:    authz.ActionUpdate,
+	http.MethodPatch:  authz.ActionUpdate,
+	http.MethodDelete: authz.ActionDelete,
+}
+
+// usersActions maps the methods served by UsersHandler to authz actions
+var usersActions = map[string]authz.Action{
+	http.MethodGet:  authz.ActionRead,
+	http.MethodPost: authz.ActionCreate,
+}
+
+// UserCreatedFunc is called after a user has been created
+type UserCreatedFunc func(ctx context.Context, user models.User)
+
+// UsersTag tags cached data derived from the users table
+const UsersTag = "users"
+
+// Invalidator drops cached data by tag, e.g. a SearchCache
+type Invalidator interface {
+	InvalidateTag(tag string) int
+}
+
+// InvalidateOnCreate drops cached user data from caches when a user is created
+func InvalidateOnCreate(caches ...Invalidator) UserCreatedFunc {
+	return func(context.Context, models.User) {
+		invalidateUsers(caches)
+	}
+}
+
+// invalidateUsers drops cached user data after a write to the users table
+func invalidateUsers(caches []Invalidator) {
+	for _, c := range caches {
+		c.InvalidateTag(UsersTag)
+	}
+}
+
+// UserChange describes an update or delete of a single user
+type UserChange struct {
+	ID int
+	// EmailChanged is set when the user's address was replaced
+	EmailChanged bool
+	// Deleted is set when the user was removed
+	Deleted bool
+}
+
+// UserChangedFunc is called after a user has been updated or deleted
+type UserChangedFunc func(ctx context.Context, change UserChange)
+
+// InvalidateOnChange drops cached user data from caches when a user is
+// updated or deleted
+func InvalidateOnChange(caches ...Invalidator) UserChangedFunc {
+	return func(context.Context, UserChange) {
+		invalidateUsers(caches)
+	}
+}
+
+// notifyChange calls every hook with change
+func notifyChange(ctx context.Context, onChange []UserChangedFunc, change UserChange) {
+	for _, fn := range onChange {
+		fn(ctx, change)
+	}
+}
+
+func UsersHandler(db *sql.DB, onCreate ...UserCreatedFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		action, ok := usersActions[r.Method]
+		if !ok {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizeCollection(w, r, action) {
+			return
+		}
+
 		switch r.Method {
 		case http.MethodGet:
 			getUsers(w, r, db)
 		case http.MethodPost:
-			createUser(w, r, db)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			createUser(w, r, db, onCreate)
 		}
 	}
 }
 
-func UserHandler(db *sql.DB) http.HandlerFunc {
+// authorizeCollection checks that the caller may act on the user collection
+// as a whole. The collection has no owner, so only admins pass.
+func authorizeCollection(w http.ResponseWriter, r *http.Request, action authz.Action) bool {
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		apperrors.Write(w, apperrors.NewUnauthorized("Authentication required", ""))
+		return false
+	}
+	if err := userAccess.Authorize(principal, action, authz.Resource{Type: ResourceUser}); err != nil {
+		apperrors.Write(w, apperrors.NewForbidden("Access denied",
+			"only admins may list, search or create users"))
+		return false
+	}
+	return true
+}
+
+// UserHandler serves a single user. Every hook in onChange is called after
+// an update or delete, e.g. to invalidate cached user data.
+func UserHandler(db *sql.DB, onChange ...UserChangedFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id, err := strconv.Atoi(r.URL.Path[len("/users/"):])
 		if err != nil {
@@ -30,13 +135,34 @@ func UserHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		action, ok := userActions[r.Method]
+		if !ok {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		principal, ok := authz.PrincipalFromContext(r.Context())
+		if !ok {
+			apperrors.Write(w, apperrors.NewUnauthorized("Authentication required", ""))
+			return
+		}
+
+		resource := authz.Resource{Type: ResourceUser, ID: strconv.Itoa(id), OwnerID: strconv.Itoa(id)}
+		if err := userAccess.Authorize(principal, action, resource); err != nil {
+			apperrors.Write(w, apperrors.NewForbidden("Access denied",
+				"you may only access your own user record"))
+			return
+		}
+
 		switch r.Method {
 		case http.MethodGet:
 			getUser(w, r, db, id)
 		case http.MethodPut:
-			updateUser(w, r, db, id)
+			updateUser(w, r, db, id, onChange)
+		case http.MethodPatch:
+			patchUser(w, r, db, id, onChange)
 		case http.MethodDelete:
-			deleteUser(w, r, db, id)
+			deleteUser(w, r, db, id, onChange)
 		default:
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
@@ -65,15 +191,41 @@ func getUsers(w http.ResponseWriter, _ *http.Request, db *sql.DB) {
 	json.NewEncoder(w).Encode(users)
 }
 
-func createUser(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+// CreateUserRequest represents the body of a user creation request
+type CreateUserRequest struct {
+	models.User
+	Password string `json:"password,omitempty"`
+	Role     string `json:"role,omitempty"`
+}
+
+func createUser(w http.ResponseWriter, r *http.Request, db *sql.DB, onCreate []UserCreatedFunc) {
+	var req CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
 
-	result, err := db.Exec("INSERT INTO users (name, email, age) VALUES (?, ?, ?)",
-		user.Name, user.Email, user.Age)
+	if req.Role == "" {
+		req.Role = authz.RoleUser
+	}
+	if authz.PermissionsForRole(req.Role) == nil {
+		apperrors.Write(w, apperrors.NewBadRequest("Invalid input", "unknown role "+req.Role))
+		return
+	}
+
+	var passwordHash sql.NullString
+	if req.Password != "" {
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			return
+		}
+		passwordHash = sql.NullString{String: hash, Valid: true}
+	}
+
+	user := req.User
+	result, err := db.Exec("INSERT INTO users (name, email, age, password_hash, role) VALUES (?, ?, ?, ?, ?)",
+		user.Name, user.Email, user.Age, passwordHash, req.Role)
 	if err != nil {
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
@@ -86,6 +238,10 @@ func createUser(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 
 	user.ID = int(id)
+	for _, fn := range onCreate {
+		fn(r.Context(), user)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(user)
@@ -107,31 +263,138 @@ func getUser(w http.ResponseWriter, _ *http.Request, db *sql.DB, id int) {
 	json.NewEncoder(w).Encode(user)
 }
 
-func updateUser(w http.ResponseWriter, r *http.Request, db *sql.DB, id int) {
+func updateUser(w http.ResponseWriter, r *http.Request, db *sql.DB, id int, onChange []UserChangedFunc) {
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
 
-	_, err := db.Exec("UPDATE users SET name = ?, email = ?, age = ? WHERE id = ?",
-		user.Name, user.Email, user.Age, id)
+	// Read the old address in the same transaction as the write, so that
+	// EmailChanged is never computed from a stale read
+	tx, err := db.BeginTx(r.Context(), nil)
 	if err != nil {
 		http.Error(w, "Failed to update user", http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
+
+	oldEmail, ok := currentEmail(w, r, tx, id)
+	if !ok {
+		return
+	}
+
+	// A changed address has not been verified yet
+	_, err = tx.ExecContext(r.Context(), `UPDATE users SET name = ?,
+		email_verified = CASE WHEN email = ? THEN email_verified ELSE 0 END,
+		email = ?, age = ? WHERE id = ?`,
+		user.Name, user.Email, user.Email, user.Age, id)
+	if err != nil {
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+	notifyChange(r.Context(), onChange, UserChange{ID: id, EmailChanged: user.Email != oldEmail})
 
 	user.ID = id
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
 
-func deleteUser(w http.ResponseWriter, _ *http.Request, db *sql.DB, id int) {
-	_, err := db.Exec("DELETE FROM users WHERE id = ?", id)
+func patchUser(w http.ResponseWriter, r *http.Request, db *sql.DB, id int, onChange []UserChangedFunc) {
+	var patch models.UserPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	change := UserChange{ID: id}
+	if patch.Email != nil {
+		oldEmail, ok := currentEmail(w, r, tx, id)
+		if !ok {
+			return
+		}
+		change.EmailChanged = *patch.Email != oldEmail
+	}
+
+	var sets []string
+	var args []interface{}
+	if patch.Name != nil {
+		sets = append(sets, "name = ?")
+		args = append(args, *patch.Name)
+	}
+	if patch.Email != nil {
+		// A changed address has not been verified yet
+		sets = append(sets, "email_verified = CASE WHEN email = ? THEN email_verified ELSE 0 END", "email = ?")
+		args = append(args, *patch.Email, *patch.Email)
+	}
+	if patch.Age != nil {
+		sets = append(sets, "age = ?")
+		args = append(args, *patch.Age)
+	}
+
+	if len(sets) > 0 {
+		args = append(args, id)
+		result, err := tx.ExecContext(r.Context(), "UPDATE users SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...)
+		if err != nil {
+			http.Error(w, "Failed to update user", http.StatusInternalServerError)
+			return
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+	if len(sets) > 0 {
+		notifyChange(r.Context(), onChange, change)
+	}
+
+	getUser(w, r, db, id)
+}
+
+func deleteUser(w http.ResponseWriter, r *http.Request, db *sql.DB, id int, onChange []UserChangedFunc) {
+	result, err := db.Exec("DELETE FROM users WHERE id = ?", id)
 	if err != nil {
 		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
 		return
 	}
+	if n, err := result.RowsAffected(); err != nil {
+		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+		return
+	} else if n == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	notifyChange(r.Context(), onChange, UserChange{ID: id, Deleted: true})
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// currentEmail returns the user's address within the update's transaction,
+// writing the error response and reporting false when it cannot be read
+func currentEmail(w http.ResponseWriter, r *http.Request, tx *sql.Tx, id int) (string, bool) {
+	var email string
+	err := tx.QueryRowContext(r.Context(), "SELECT email FROM users WHERE id = ?", id).Scan(&email)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return "", false
+	} else if err != nil {
+		http.Error(w, "Failed to query user", http.StatusInternalServerError)
+		return "", false
+	}
+	return email, true
+}
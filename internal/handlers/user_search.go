@@ -1,21 +1,85 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 
+	"example.com/cursorrules-golang/internal/authz"
 	"example.com/cursorrules-golang/internal/cache"
 	"example.com/cursorrules-golang/internal/metrics"
 	"example.com/cursorrules-golang/internal/models"
 )
 
-// SearchUsersHandler handles user search requests with pagination
-func SearchUsersHandler(db *sql.DB, cache *cache.Cache) http.HandlerFunc {
+// SearchCache holds search responses keyed by their query parameters. It is
+// satisfied by an in-process cache.TypedCache and by a cache.Shared over a
+// remote backend.
+type SearchCache interface {
+	GetOrLoad(ctx context.Context, key string, load cache.LoadFunc[models.PaginatedResponse], opts cache.LoadOptions[models.PaginatedResponse]) (models.PaginatedResponse, error)
+	InvalidateTag(tag string) int
+}
+
+// searchLoadOptions caches results for 5 minutes, serving them for another
+// minute while they are refreshed. Searches matching nobody are cached
+// briefly so that repeated misses do not all reach the database.
+var searchLoadOptions = cache.LoadOptions[models.PaginatedResponse]{
+	TTL:         5 * time.Minute,
+	StaleTTL:    time.Minute,
+	NegativeTTL: 30 * time.Second,
+	IsEmpty:     func(response models.PaginatedResponse) bool { return response.Pagination.TotalItems == 0 },
+	Tags:        []string{UsersTag},
+}
+
+// userOverhead approximates the fixed size of a models.User: two ints and
+// two string headers
+const userOverhead = 48
+
+// SearchResponseSize estimates the memory a cached search response takes
+func SearchResponseSize(key string, response models.PaginatedResponse) int64 {
+	// The key, the pagination fields and slice and interface headers
+	size := int64(len(key) + 128)
+	if users, ok := response.Data.([]models.User); ok {
+		for _, user := range users {
+			size += int64(userOverhead + len(user.Name) + len(user.Email))
+		}
+	}
+	return size
+}
+
+// SearchResponseCodec encodes search responses as JSON for cache snapshots,
+// decoding their data back into users so that SearchResponseSize still
+// measures them
+type SearchResponseCodec struct{}
+
+// Marshal encodes response as JSON
+func (SearchResponseCodec) Marshal(response models.PaginatedResponse) ([]byte, error) {
+	return json.Marshal(response)
+}
+
+// Unmarshal decodes a response encoded by Marshal
+func (SearchResponseCodec) Unmarshal(data []byte, response *models.PaginatedResponse) error {
+	var users []models.User
+	response.Data = &users
+	if err := json.Unmarshal(data, response); err != nil {
+		return err
+	}
+	response.Data = users
+	return nil
+}
+
+// SearchUsersHandler handles user search requests with pagination. Results
+// are tagged with UsersTag, so user writes must invalidate the cache.
+func SearchUsersHandler(db *sql.DB, cache SearchCache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeCollection(w, r, authz.ActionRead) {
+			return
+		}
+
 		start := time.Now()
 		metrics := metrics.GetMetrics()
 
@@ -35,96 +99,93 @@ func SearchUsersHandler(db *sql.DB, cache *cache.Cache) http.HandlerFunc {
 		params.SortBy = query.Get("sort_by")
 		params.SortOrder = strings.ToLower(query.Get("sort_order"))
 
-		// Try to get from cache first
 		cacheKey := fmt.Sprintf("users:search:%v", params)
-		if cached, found := cache.Get(cacheKey); found {
-			if response, ok := cached.(models.PaginatedResponse); ok {
-				metrics.RecordRequest(time.Since(start), true)
-				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode(response)
-				return
-			}
-		}
-
-		// Build the SQL query
-		baseQuery := "SELECT id, name, email, age FROM users WHERE 1=1"
-		countQuery := "SELECT COUNT(*) FROM users WHERE 1=1"
-		var conditions []string
-		var args []interface{}
-
-		if params.Search != "" {
-			switch params.SearchBy {
-			case "name":
-				conditions = append(conditions, "name LIKE ?")
-				args = append(args, "%"+params.Search+"%")
-			case "email":
-				conditions = append(conditions, "email LIKE ?")
-				args = append(args, "%"+params.Search+"%")
-			}
+		response, err := cache.GetOrLoad(r.Context(), cacheKey, func(ctx context.Context) (models.PaginatedResponse, error) {
+			return searchUsers(ctx, db, params)
+		}, searchLoadOptions)
+		if err != nil {
+			log.Printf("user search failed: %v", err)
+			metrics.RecordRequest(time.Since(start), false)
+			http.Error(w, "Failed to search users", http.StatusInternalServerError)
+			return
 		}
 
-		if len(conditions) > 0 {
-			whereClause := " AND " + strings.Join(conditions, " AND ")
-			baseQuery += whereClause
-			countQuery += whereClause
-		}
+		metrics.RecordRequest(time.Since(start), true)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
 
-		// Add sorting
-		if params.SortBy != "" {
-			baseQuery += fmt.Sprintf(" ORDER BY %s %s", params.SortBy,
-				strings.ToUpper(params.SortOrder))
+// searchUsers runs a search against the database
+func searchUsers(ctx context.Context, db *sql.DB, params models.QueryParams) (models.PaginatedResponse, error) {
+	var response models.PaginatedResponse
+
+	// Build the SQL query
+	baseQuery := "SELECT id, name, email, age FROM users WHERE 1=1"
+	countQuery := "SELECT COUNT(*) FROM users WHERE 1=1"
+	var conditions []string
+	var args []interface{}
+
+	if params.Search != "" {
+		switch params.SearchBy {
+		case "name":
+			conditions = append(conditions, "name LIKE ?")
+			args = append(args, "%"+params.Search+"%")
+		case "email":
+			conditions = append(conditions, "email LIKE ?")
+			args = append(args, "%"+params.Search+"%")
 		}
+	}
 
-		// Add pagination
-		offset := (params.Page - 1) * params.PageSize
-		baseQuery += fmt.Sprintf(" LIMIT %d OFFSET %d", params.PageSize, offset)
+	if len(conditions) > 0 {
+		whereClause := " AND " + strings.Join(conditions, " AND ")
+		baseQuery += whereClause
+		countQuery += whereClause
+	}
 
-		// Get total count
-		var totalItems int
-		err := db.QueryRow(countQuery, args...).Scan(&totalItems)
-		if err != nil {
-			metrics.RecordRequest(time.Since(start), false)
-			http.Error(w, "Failed to count users", http.StatusInternalServerError)
-			return
-		}
+	// Add sorting
+	if params.SortBy != "" {
+		baseQuery += fmt.Sprintf(" ORDER BY %s %s", params.SortBy,
+			strings.ToUpper(params.SortOrder))
+	}
 
-		// Execute the main query
-		rows, err := db.Query(baseQuery, args...)
-		if err != nil {
-			metrics.RecordRequest(time.Since(start), false)
-			http.Error(w, "Failed to query users", http.StatusInternalServerError)
-			return
-		}
-		defer rows.Close()
-
-		var users []models.User
-		for rows.Next() {
-			var user models.User
-			if err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Age); err != nil {
-				metrics.RecordRequest(time.Since(start), false)
-				http.Error(w, "Failed to scan user", http.StatusInternalServerError)
-				return
-			}
-			users = append(users, user)
-		}
+	// Add pagination
+	offset := (params.Page - 1) * params.PageSize
+	baseQuery += fmt.Sprintf(" LIMIT %d OFFSET %d", params.PageSize, offset)
 
-		// Prepare paginated response
-		totalPages := (totalItems + params.PageSize - 1) / params.PageSize
-		response := models.PaginatedResponse{
-			Data: users,
-		}
-		response.Pagination.CurrentPage = params.Page
-		response.Pagination.PageSize = params.PageSize
-		response.Pagination.TotalItems = totalItems
-		response.Pagination.TotalPages = totalPages
-		response.Pagination.HasNext = params.Page < totalPages
-		response.Pagination.HasPrevious = params.Page > 1
+	// Get total count
+	var totalItems int
+	if err := db.QueryRowContext(ctx, countQuery, args...).Scan(&totalItems); err != nil {
+		return response, fmt.Errorf("count users: %w", err)
+	}
 
-		// Cache the response
-		cache.Set(cacheKey, response, 5*time.Minute)
+	// Execute the main query
+	rows, err := db.QueryContext(ctx, baseQuery, args...)
+	if err != nil {
+		return response, fmt.Errorf("query users: %w", err)
+	}
+	defer rows.Close()
 
-		metrics.RecordRequest(time.Since(start), true)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Age); err != nil {
+			return response, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return response, fmt.Errorf("query users: %w", err)
 	}
+
+	// Prepare paginated response
+	totalPages := (totalItems + params.PageSize - 1) / params.PageSize
+	response.Data = users
+	response.Pagination.CurrentPage = params.Page
+	response.Pagination.PageSize = params.PageSize
+	response.Pagination.TotalItems = totalItems
+	response.Pagination.TotalPages = totalPages
+	response.Pagination.HasNext = params.Page < totalPages
+	response.Pagination.HasPrevious = params.Page > 1
+	return response, nil
 }
@@ -8,9 +8,12 @@ import (
 	"testing"
 	"time"
 
+	"example.com/cursorrules-golang/internal/authz"
 	"example.com/cursorrules-golang/internal/cache"
+	"example.com/cursorrules-golang/internal/database"
 	"example.com/cursorrules-golang/internal/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // ErrorResponse represents an error response
@@ -90,7 +93,7 @@ func TestSearchUsersHandler(t *testing.T) {
 				url += "&page_size=" + tt.pageSize
 			}
 
-			req := httptest.NewRequest(http.MethodGet, url, nil)
+			req := newUserRequest(http.MethodGet, url, "", authz.NewPrincipal("1", authz.RoleAdmin))
 			w := httptest.NewRecorder()
 
 			// Execute request
@@ -117,16 +120,114 @@ func TestSearchUsersHandler(t *testing.T) {
 	}
 }
 
+// searchNames runs a search through handler and returns the matching names
+func searchNames(t *testing.T, handler http.Handler, query string) []string {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newUserRequest(http.MethodGet, "/users/search?search_by=name&search="+query, "",
+		authz.NewPrincipal("1", authz.RoleAdmin)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Data []models.User `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	names := []string{}
+	for _, user := range response.Data {
+		names = append(names, user.Name)
+	}
+	return names
+}
+
+func TestSearchUsersReadAfterWrite(t *testing.T) {
+	admin := authz.NewPrincipal("1", authz.RoleAdmin)
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		expected []string
+	}{
+		{name: "create", method: http.MethodPost, target: "/users",
+			body: `{"name":"new_user","email":"new@example.com","age":20}`, expected: []string{"test_user", "new_user"}},
+		{name: "update", method: http.MethodPut, target: "/users/1",
+			body: `{"name":"renamed","email":"test@example.com","age":25}`, expected: []string{}},
+		{name: "patch", method: http.MethodPatch, target: "/users/1",
+			body: `{"name":"patched_user"}`, expected: []string{"patched_user"}},
+		{name: "delete", method: http.MethodDelete, target: "/users/1", expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := createTestDB(t)
+			cache := createTestCache(t)
+			search := SearchUsersHandler(db, cache)
+			write := http.Handler(UserHandler(db, InvalidateOnChange(cache)))
+			if tt.target == "/users" {
+				write = UsersHandler(db, InvalidateOnCreate(cache))
+			}
+
+			// Prime the cache
+			require.Equal(t, []string{"test_user"}, searchNames(t, search, "user"))
+
+			w := httptest.NewRecorder()
+			write.ServeHTTP(w, newUserRequest(tt.method, tt.target, tt.body, admin))
+			require.Less(t, w.Code, 300)
+
+			assert.Equal(t, tt.expected, searchNames(t, search, "user"))
+		})
+	}
+}
+
+func TestSearchUsersDoesNotCacheResultRacingWrite(t *testing.T) {
+	cache := createTestCache(t)
+
+	// A write between taking the versions and storing the result must win
+	versions := cache.TagVersions(UsersTag)
+	cache.InvalidateTag(UsersTag)
+	assert.False(t, cache.SetTagged("users:search:stale", models.PaginatedResponse{}, time.Minute, versions))
+}
+
+func TestSearchResponseSizeGrowsWithPage(t *testing.T) {
+	page := func(n int) models.PaginatedResponse {
+		users := make([]models.User, n)
+		for i := range users {
+			users[i] = models.User{ID: i, Name: "user", Email: "user@example.com"}
+		}
+		return models.PaginatedResponse{Data: users}
+	}
+
+	small := SearchResponseSize("users:search:small", page(10))
+	large := SearchResponseSize("users:search:large", page(1000))
+	assert.Greater(t, small, int64(0))
+	assert.Greater(t, large, 50*small)
+}
+
+func TestSearchResponseCodecRoundTrip(t *testing.T) {
+	response := models.PaginatedResponse{Data: []models.User{{ID: 1, Name: "user", Email: "user@example.com", Age: 30}}}
+	response.Pagination.TotalItems = 1
+
+	var codec SearchResponseCodec
+	data, err := codec.Marshal(response)
+	require.NoError(t, err)
+	var decoded models.PaginatedResponse
+	require.NoError(t, codec.Unmarshal(data, &decoded))
+
+	// The users come back typed, so restored responses are measured alike
+	assert.Equal(t, response, decoded)
+	assert.Equal(t, SearchResponseSize("key", response), SearchResponseSize("key", decoded))
+}
+
 // BenchmarkSearchUsers runs performance tests for the search handler
 func BenchmarkSearchUsers(b *testing.B) {
 	// Setup test environment
 	db := createTestDB(b)
 	cache := createTestCache(b)
 	handler := SearchUsersHandler(db, cache)
+	admin := authz.NewPrincipal("1", authz.RoleAdmin)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		req := httptest.NewRequest(http.MethodGet, "/users/search?search=test&search_by=name", nil)
+		req := newUserRequest(http.MethodGet, "/users/search?search=test&search_by=name", "", admin)
 		w := httptest.NewRecorder()
 		handler.ServeHTTP(w, req)
 	}
@@ -139,6 +240,8 @@ func createTestDB(t testing.TB) *sql.DB {
 	if err != nil {
 		t.Fatalf("Failed to create test database: %v", err)
 	}
+	// Each connection to :memory: is a separate database, so pin the pool to one
+	db.SetMaxOpenConns(1)
 
 	// Create test tables and data
 	if err := setupTestDB(db); err != nil {
@@ -148,11 +251,13 @@ func createTestDB(t testing.TB) *sql.DB {
 	return db
 }
 
-func createTestCache(_ testing.TB) *cache.Cache {
-	return cache.New(cache.Config{
+func createTestCache(t testing.TB) *cache.TypedCache[string, models.PaginatedResponse] {
+	c := cache.NewTyped[string, models.PaginatedResponse](cache.Config{
 		MaxItems:        100,
 		CleanupInterval: time.Minute,
 	})
+	t.Cleanup(func() { c.Close() })
+	return c
 }
 
 func setupTestDB(db *sql.DB) error {
@@ -169,6 +274,11 @@ func setupTestDB(db *sql.DB) error {
 		return err
 	}
 
+	// Add the columns introduced by later migrations
+	if err := database.Migrate(db); err != nil {
+		return err
+	}
+
 	// Insert test data
 	_, err = db.Exec(`
 		INSERT INTO users (name, email, age) VALUES
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/cursorrules-golang/internal/authz"
+	"example.com/cursorrules-golang/internal/models"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newUserRequest builds a request carrying the given principal, or none when nil
+func newUserRequest(method, target, body string, principal *authz.Principal) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if principal != nil {
+		req = req.WithContext(authz.WithPrincipal(req.Context(), principal))
+	}
+	return req
+}
+
+// createUserFixtures adds a second regular user and an admin next to test_user (id 1)
+func createUserFixtures(t *testing.T, db *sql.DB) {
+	_, err := db.Exec(`
+		INSERT INTO users (name, email, age) VALUES
+		('other_user', 'other@example.com', 30),
+		('admin_user', 'admin@example.com', 40)
+	`)
+	require.NoError(t, err)
+}
+
+func TestUsersHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+	}{
+		{name: "list users", method: http.MethodGet, expectedStatus: http.StatusOK},
+		{name: "create user", method: http.MethodPost, body: `{"name":"new_user","email":"new@example.com","age":20}`, expectedStatus: http.StatusCreated},
+		{name: "create user with invalid body", method: http.MethodPost, body: `{`, expectedStatus: http.StatusBadRequest},
+		{name: "unsupported method", method: http.MethodDelete, expectedStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := createTestDB(t)
+			handler := UsersHandler(db)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, newUserRequest(tt.method, "/users", tt.body, authz.NewPrincipal("1", authz.RoleAdmin)))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestUsersHandlerListAndCreate(t *testing.T) {
+	db := createTestDB(t)
+	handler := UsersHandler(db)
+	principal := authz.NewPrincipal("1", authz.RoleAdmin)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newUserRequest(http.MethodPost, "/users",
+		`{"name":"new_user","email":"new@example.com","age":20}`, principal))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var created models.User
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.Equal(t, 2, created.ID)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newUserRequest(http.MethodGet, "/users", "", principal))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var users []models.User
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&users))
+	assert.Len(t, users, 2)
+}
+
+func TestUserHandlerOwnership(t *testing.T) {
+	owner := authz.NewPrincipal("1", authz.RoleUser)
+	stranger := authz.NewPrincipal("2", authz.RoleUser)
+	admin := authz.NewPrincipal("3", authz.RoleAdmin)
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		principal      *authz.Principal
+		expectedStatus int
+	}{
+		{name: "owner gets self", method: http.MethodGet, target: "/users/1", principal: owner, expectedStatus: http.StatusOK},
+		{name: "stranger cannot get", method: http.MethodGet, target: "/users/1", principal: stranger, expectedStatus: http.StatusForbidden},
+		{name: "admin gets anyone", method: http.MethodGet, target: "/users/1", principal: admin, expectedStatus: http.StatusOK},
+		{name: "admin gets missing user", method: http.MethodGet, target: "/users/99", principal: admin, expectedStatus: http.StatusNotFound},
+
+		{name: "owner puts self", method: http.MethodPut, target: "/users/1", body: `{"name":"renamed","email":"test@example.com","age":26}`, principal: owner, expectedStatus: http.StatusOK},
+		{name: "stranger cannot put", method: http.MethodPut, target: "/users/1", body: `{"name":"x","email":"x@example.com","age":1}`, principal: stranger, expectedStatus: http.StatusForbidden},
+		{name: "admin puts anyone", method: http.MethodPut, target: "/users/1", body: `{"name":"renamed","email":"test@example.com","age":26}`, principal: admin, expectedStatus: http.StatusOK},
+		{name: "put with invalid body", method: http.MethodPut, target: "/users/1", body: `{`, principal: owner, expectedStatus: http.StatusBadRequest},
+
+		{name: "owner patches self", method: http.MethodPatch, target: "/users/1", body: `{"age":27}`, principal: owner, expectedStatus: http.StatusOK},
+		{name: "stranger cannot patch", method: http.MethodPatch, target: "/users/1", body: `{"age":27}`, principal: stranger, expectedStatus: http.StatusForbidden},
+		{name: "admin patches anyone", method: http.MethodPatch, target: "/users/1", body: `{"age":27}`, principal: admin, expectedStatus: http.StatusOK},
+		{name: "admin patches missing user", method: http.MethodPatch, target: "/users/99", body: `{"age":27}`, principal: admin, expectedStatus: http.StatusNotFound},
+		{name: "patch with invalid body", method: http.MethodPatch, target: "/users/1", body: `{`, principal: owner, expectedStatus: http.StatusBadRequest},
+
+		{name: "owner cannot delete self", method: http.MethodDelete, target: "/users/1", principal: owner, expectedStatus: http.StatusForbidden},
+		{name: "stranger cannot delete", method: http.MethodDelete, target: "/users/1", principal: stranger, expectedStatus: http.StatusForbidden},
+		{name: "admin deletes anyone", method: http.MethodDelete, target: "/users/1", principal: admin, expectedStatus: http.StatusNoContent},
+		{name: "admin deletes missing user", method: http.MethodDelete, target: "/users/99", principal: admin, expectedStatus: http.StatusNotFound},
+
+		{name: "unauthenticated request", method: http.MethodGet, target: "/users/1", principal: nil, expectedStatus: http.StatusUnauthorized},
+		{name: "invalid user id", method: http.MethodGet, target: "/users/abc", principal: owner, expectedStatus: http.StatusBadRequest},
+		{name: "unsupported method", method: http.MethodPost, target: "/users/1", principal: admin, expectedStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := createTestDB(t)
+			createUserFixtures(t, db)
+			handler := UserHandler(db)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, newUserRequest(tt.method, tt.target, tt.body, tt.principal))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestUserCollectionRequiresAdmin(t *testing.T) {
+	user := authz.NewPrincipal("1", authz.RoleUser)
+	admin := authz.NewPrincipal("3", authz.RoleAdmin)
+	create := `{"name":"new_user","email":"new@example.com","age":20}`
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		principal      *authz.Principal
+		expectedStatus int
+	}{
+		{name: "user cannot list", method: http.MethodGet, target: "/users", principal: user, expectedStatus: http.StatusForbidden},
+		{name: "admin lists", method: http.MethodGet, target: "/users", principal: admin, expectedStatus: http.StatusOK},
+		{name: "unauthenticated list", method: http.MethodGet, target: "/users", principal: nil, expectedStatus: http.StatusUnauthorized},
+
+		{name: "user cannot create", method: http.MethodPost, target: "/users", body: create, principal: user, expectedStatus: http.StatusForbidden},
+		{name: "admin creates", method: http.MethodPost, target: "/users", body: create, principal: admin, expectedStatus: http.StatusCreated},
+		{name: "unauthenticated create", method: http.MethodPost, target: "/users", body: create, principal: nil, expectedStatus: http.StatusUnauthorized},
+
+		{name: "user cannot search", method: http.MethodGet, target: "/users/search?search=user", principal: user, expectedStatus: http.StatusForbidden},
+		{name: "admin searches", method: http.MethodGet, target: "/users/search?search=user", principal: admin, expectedStatus: http.StatusOK},
+		{name: "unauthenticated search", method: http.MethodGet, target: "/users/search?search=user", principal: nil, expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := createTestDB(t)
+			createUserFixtures(t, db)
+			handler := http.Handler(UsersHandler(db))
+			if strings.HasPrefix(tt.target, "/users/search") {
+				handler = SearchUsersHandler(db, createTestCache(t))
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, newUserRequest(tt.method, tt.target, tt.body, tt.principal))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusForbidden {
+				assert.NotContains(t, w.Body.String(), "other@example.com")
+			}
+		})
+	}
+}
+
+func TestPatchUserUpdatesOnlyGivenFields(t *testing.T) {
+	db := createTestDB(t)
+	handler := UserHandler(db)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newUserRequest(http.MethodPatch, "/users/1", `{"age":31}`,
+		authz.NewPrincipal("1", authz.RoleUser)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var user models.User
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&user))
+	assert.Equal(t, models.User{ID: 1, Name: "test_user", Email: "test@example.com", Age: 31}, user)
+}
+
+func TestDeleteUserRemovesRecord(t *testing.T) {
+	db := createTestDB(t)
+	var changes []UserChange
+	handler := UserHandler(db, func(_ context.Context, change UserChange) {
+		changes = append(changes, change)
+	})
+	admin := authz.NewPrincipal("3", authz.RoleAdmin)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newUserRequest(http.MethodDelete, "/users/1", "", admin))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, []UserChange{{ID: 1, Deleted: true}}, changes)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newUserRequest(http.MethodGet, "/users/1", "", admin))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Deleting it again changes nothing, so no hook runs
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newUserRequest(http.MethodDelete, "/users/1", "", admin))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Len(t, changes, 1)
+}
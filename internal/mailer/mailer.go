@@ -0,0 +1,124 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message represents a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPConfig configures delivery through an SMTP relay
+type SMTPConfig struct {
+	// Addr is the relay address as host:port
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// SMTPMailer sends messages through an SMTP relay
+type SMTPMailer struct {
+	config SMTPConfig
+	send   func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSMTPMailer creates a mailer for the relay in config
+func NewSMTPMailer(config SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{
+		config: config,
+		send:   smtp.SendMail,
+	}
+}
+
+// Send delivers the message. net/smtp does not support cancellation, so ctx
+// is only checked before dialing.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		host := m.config.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, host)
+	}
+
+	return m.send(m.config.Addr, auth, m.config.From, []string{msg.To}, format(m.config.From, msg))
+}
+
+// SinkMailer writes messages to an io.Writer instead of delivering them.
+// It is meant for development and tests.
+type SinkMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewSinkMailer creates a mailer that writes to w
+func NewSinkMailer(w io.Writer) *SinkMailer {
+	return &SinkMailer{w: w}
+}
+
+// NewFileMailer creates a mailer that appends messages to the file at path
+func NewFileMailer(path string) (*SinkMailer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewSinkMailer(f), nil
+}
+
+// NewLogMailer creates a mailer that writes messages to the standard logger
+func NewLogMailer() *SinkMailer {
+	return NewSinkMailer(log.Writer())
+}
+
+// Send writes the message followed by a separator line
+func (m *SinkMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.w, "%s\n----\n", format("", msg))
+	return err
+}
+
+// headerReplacer strips line breaks so values cannot inject extra headers
+var headerReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+// format renders an RFC 5322 message
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", headerReplacer.Replace(from))
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", headerReplacer.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerReplacer.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	return []byte(b.String())
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"net/smtp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSinkMailer(t *testing.T) {
+	var out bytes.Buffer
+	m := NewSinkMailer(&out)
+
+	err := m.Send(context.Background(), Message{
+		To:      "ann@example.com",
+		Subject: "Hello\r\nBcc: evil@example.com",
+		Body:    "Body text",
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, out.String(), "To: ann@example.com\r\n")
+	assert.Contains(t, out.String(), "Subject: Hello  Bcc: evil@example.com\r\n", "line breaks cannot inject headers")
+	assert.Contains(t, out.String(), "\r\n\r\nBody text")
+}
+
+func TestSMTPMailer(t *testing.T) {
+	var gotAddr, gotFrom string
+	var gotTo []string
+	var gotAuth smtp.Auth
+
+	m := NewSMTPMailer(SMTPConfig{Addr: "smtp.example.com:587", From: "noreply@example.com", Username: "u", Password: "p"})
+	m.send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		gotAddr, gotAuth, gotFrom, gotTo = addr, a, from, to
+		return nil
+	}
+
+	require.NoError(t, m.Send(context.Background(), Message{To: "ann@example.com", Subject: "Hi", Body: "x"}))
+	assert.Equal(t, "smtp.example.com:587", gotAddr)
+	assert.Equal(t, "noreply@example.com", gotFrom)
+	assert.Equal(t, []string{"ann@example.com"}, gotTo)
+	assert.NotNil(t, gotAuth)
+}
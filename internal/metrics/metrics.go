@@ -21,9 +21,11 @@ type Metrics struct {
 
 	// Rate limiting metrics
 	RateLimitExceeded uint64
+	LoadShed          uint64
 
 	// Authentication metrics
-	AuthFailures uint64
+	AuthFailures         uint64
+	AuthFailuresByReason map[string]uint64
 
 	// Last update timestamp
 	LastUpdated time.Time
@@ -31,15 +33,17 @@ type Metrics struct {
 
 // MetricsSnapshot represents a snapshot of metrics without mutex
 type MetricsSnapshot struct {
-	TotalRequests       uint64
-	SuccessfulRequests  uint64
-	FailedRequests      uint64
-	AverageResponseTime float64
-	MinResponseTime     float64
-	MaxResponseTime     float64
-	RateLimitExceeded   uint64
-	AuthFailures        uint64
-	LastUpdated         time.Time
+	TotalRequests        uint64
+	SuccessfulRequests   uint64
+	FailedRequests       uint64
+	AverageResponseTime  float64
+	MinResponseTime      float64
+	MaxResponseTime      float64
+	RateLimitExceeded    uint64
+	LoadShed             uint64
+	AuthFailures         uint64
+	AuthFailuresByReason map[string]uint64
+	LastUpdated          time.Time
 }
 
 var (
@@ -51,8 +55,9 @@ var (
 func GetMetrics() *Metrics {
 	once.Do(func() {
 		defaultMetrics = &Metrics{
-			MinResponseTime: float64(^uint64(0) >> 1), // Initialize with max value
-			LastUpdated:     time.Now(),
+			MinResponseTime:      float64(^uint64(0) >> 1), // Initialize with max value
+			AuthFailuresByReason: make(map[string]uint64),
+			LastUpdated:          time.Now(),
 		}
 	})
 	return defaultMetrics
@@ -92,6 +97,14 @@ func (m *Metrics) RecordRateLimit() {
 	m.LastUpdated = time.Now()
 }
 
+// RecordLoadShed records a request rejected because the server was overloaded
+func (m *Metrics) RecordLoadShed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.LoadShed++
+	m.LastUpdated = time.Now()
+}
+
 // RecordAuthFailure records an authentication failure
 func (m *Metrics) RecordAuthFailure() {
 	m.mu.Lock()
@@ -100,20 +113,36 @@ func (m *Metrics) RecordAuthFailure() {
 	m.LastUpdated = time.Now()
 }
 
+// RecordAuthFailureReason records an authentication failure along with why it failed
+func (m *Metrics) RecordAuthFailureReason(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.AuthFailures++
+	m.AuthFailuresByReason[reason]++
+	m.LastUpdated = time.Now()
+}
+
 // GetSnapshot returns a copy of the current metrics without the mutex
 func (m *Metrics) GetSnapshot() MetricsSnapshot {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	byReason := make(map[string]uint64, len(m.AuthFailuresByReason))
+	for reason, count := range m.AuthFailuresByReason {
+		byReason[reason] = count
+	}
+
 	return MetricsSnapshot{
-		TotalRequests:       m.TotalRequests,
-		SuccessfulRequests:  m.SuccessfulRequests,
-		FailedRequests:      m.FailedRequests,
-		AverageResponseTime: m.AverageResponseTime,
-		MinResponseTime:     m.MinResponseTime,
-		MaxResponseTime:     m.MaxResponseTime,
-		RateLimitExceeded:   m.RateLimitExceeded,
-		AuthFailures:        m.AuthFailures,
-		LastUpdated:         m.LastUpdated,
+		TotalRequests:        m.TotalRequests,
+		SuccessfulRequests:   m.SuccessfulRequests,
+		FailedRequests:       m.FailedRequests,
+		AverageResponseTime:  m.AverageResponseTime,
+		MinResponseTime:      m.MinResponseTime,
+		MaxResponseTime:      m.MaxResponseTime,
+		RateLimitExceeded:    m.RateLimitExceeded,
+		LoadShed:             m.LoadShed,
+		AuthFailures:         m.AuthFailures,
+		AuthFailuresByReason: byReason,
+		LastUpdated:          m.LastUpdated,
 	}
 }
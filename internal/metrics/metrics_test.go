@@ -111,3 +111,17 @@ func TestConcurrentAccess(t *testing.T) {
 	assert.Equal(t, uint64(numGoroutines*numOperations), snapshot.TotalRequests)
 	assert.Equal(t, uint64(numGoroutines*numOperations/2), snapshot.SuccessfulRequests)
 }
+
+func TestAuthFailureReasons(t *testing.T) {
+	metrics := GetMetrics()
+	before := metrics.GetSnapshot()
+
+	metrics.RecordAuthFailureReason("expired")
+	metrics.RecordAuthFailureReason("expired")
+	metrics.RecordAuthFailureReason("bad_signature")
+
+	snapshot := metrics.GetSnapshot()
+	assert.Equal(t, before.AuthFailures+3, snapshot.AuthFailures)
+	assert.Equal(t, before.AuthFailuresByReason["expired"]+2, snapshot.AuthFailuresByReason["expired"])
+	assert.Equal(t, before.AuthFailuresByReason["bad_signature"]+1, snapshot.AuthFailuresByReason["bad_signature"])
+}
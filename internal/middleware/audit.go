@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"example.com/cursorrules-golang/internal/authz"
+)
+
+// ClientIP returns the address of the client as resolved by RealIP, falling
+// back to the connection's remote address without its port
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey{}).(string); ok {
+		return ip
+	}
+	return remoteHost(r)
+}
+
+// Audit logs a security-relevant event together with the client and principal behind it
+func Audit(r *http.Request, event, detail string) {
+	subject := "-"
+	if principal, ok := authz.PrincipalFromContext(r.Context()); ok {
+		subject = principal.ID
+	}
+	log.Printf("audit event=%s ip=%s principal=%s %s %s %s", event, ClientIP(r), subject, r.Method, r.URL.Path, detail)
+}
@@ -1,62 +1,196 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
+	"example.com/cursorrules-golang/internal/authz"
+	apperrors "example.com/cursorrules-golang/internal/errors"
+	"example.com/cursorrules-golang/internal/metrics"
 )
 
-var (
-	secretKey = []byte("your-secret-key") // TODO: Move to configuration
+// authRealm is advertised in WWW-Authenticate challenges
+const authRealm = "api"
+
+// RFC 6750 error codes
+const (
+	bearerInvalidRequest    = "invalid_request"
+	bearerInvalidToken      = "invalid_token"
+	bearerInsufficientScope = "insufficient_scope"
+)
+
+// Authentication failure reasons reported to metrics
+const (
+	AuthReasonMissing       = "missing"
+	AuthReasonMalformed     = "malformed"
+	AuthReasonExpired       = "expired"
+	AuthReasonBadSignature  = "bad_signature"
+	AuthReasonInvalidClaims = "invalid_claims"
+	AuthReasonInvalidAPIKey = "invalid_api_key"
 )
 
-type Claims struct {
-	UserID string `json:"user_id"`
-	Role   string `json:"role"`
-	jwt.StandardClaims
+type claimsContextKey struct{}
+
+// ClaimsFromContext returns the validated token claims stored by AuthMiddleware
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(*Claims)
+	return claims, ok
+}
+
+// APIKeyVerifier resolves an API key to the principal it acts as
+type APIKeyVerifier interface {
+	Verify(ctx context.Context, key string) (*authz.Principal, error)
+}
+
+// Authenticator accepts either a bearer JWT or, when configured, an X-API-Key header
+type Authenticator struct {
+	apiKeys APIKeyVerifier
+	now     func() time.Time
+}
+
+// NewAuthenticator creates an authenticator. apiKeys may be nil to accept JWTs only.
+func NewAuthenticator(apiKeys APIKeyVerifier) *Authenticator {
+	return &Authenticator{
+		apiKeys: apiKeys,
+		now:     time.Now,
+	}
 }
 
 // AuthMiddleware handles JWT authentication
 func AuthMiddleware(next http.Handler) http.Handler {
+	return NewAuthenticator(nil).Middleware(next)
+}
+
+// authFailure describes why a request could not be authenticated
+type authFailure struct {
+	status int
+	reason string
+	// code is the RFC 6750 error code; empty when no credentials were presented
+	code        string
+	description string
+}
+
+// Middleware authenticates the request and stores the principal in its context
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract token from Authorization header
 		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
+		apiKey := r.Header.Get("X-API-Key")
+
+		var ctx context.Context
+		var failure *authFailure
+		switch {
+		case authHeader != "":
+			ctx, failure = a.authenticateToken(r.Context(), authHeader)
+		case apiKey != "" && a.apiKeys != nil:
+			ctx, failure = a.authenticateAPIKey(r.Context(), apiKey)
+		default:
+			failure = &authFailure{status: http.StatusUnauthorized, reason: AuthReasonMissing, description: "authentication required"}
+		}
+
+		if failure != nil {
+			metrics.GetMetrics().RecordAuthFailureReason(failure.reason)
+			Audit(r, "auth_failure", "reason="+failure.reason)
+			writeAuthFailure(w, failure)
 			return
 		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-		claims := &Claims{}
+func (a *Authenticator) authenticateToken(ctx context.Context, authHeader string) (context.Context, *authFailure) {
+	tokenString, failure := parseBearer(authHeader)
+	if failure != nil {
+		return nil, failure
+	}
 
-		// Parse and validate token
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return secretKey, nil
-		})
+	claims, err := parseToken(tokenString, a.now())
+	if err != nil {
+		return nil, &authFailure{status: http.StatusUnauthorized, reason: tokenFailureReason(err), code: bearerInvalidToken, description: err.Error()}
+	}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
-			return
-		}
+	// Token is valid, expose the caller to downstream handlers
+	principal := authz.NewPrincipal(claims.UserID, claims.Role)
+	principal.MFA = claims.MFA()
 
-		// Token is valid, proceed with request
-		next.ServeHTTP(w, r)
-	})
+	ctx = context.WithValue(ctx, claimsContextKey{}, claims)
+	return authz.WithPrincipal(ctx, principal), nil
+}
+
+func (a *Authenticator) authenticateAPIKey(ctx context.Context, apiKey string) (context.Context, *authFailure) {
+	principal, err := a.apiKeys.Verify(ctx, apiKey)
+	if err != nil {
+		return nil, &authFailure{status: http.StatusUnauthorized, reason: AuthReasonInvalidAPIKey, code: bearerInvalidToken, description: "API key is invalid, expired or revoked"}
+	}
+	return authz.WithPrincipal(ctx, principal), nil
+}
+
+// parseBearer extracts the token from an "Authorization: Bearer <token>" header
+func parseBearer(header string) (string, *authFailure) {
+	scheme, token, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", &authFailure{status: http.StatusUnauthorized, reason: AuthReasonMalformed, description: "unsupported authorization scheme"}
+	}
+	if !isB64Token(token) {
+		return "", &authFailure{status: http.StatusBadRequest, reason: AuthReasonMalformed, code: bearerInvalidRequest, description: "malformed bearer credentials"}
+	}
+	return token, nil
+}
+
+// tokenFailureReason classifies a parseToken error for metrics
+func tokenFailureReason(err error) string {
+	switch err {
+	case errTokenMalformed:
+		return AuthReasonMalformed
+	case errTokenSignature:
+		return AuthReasonBadSignature
+	case errTokenExpired:
+		return AuthReasonExpired
+	default:
+		return AuthReasonInvalidClaims
+	}
 }
 
-// GenerateToken creates a new JWT token
-func GenerateToken(userID, role string) (string, error) {
-	claims := Claims{
-		UserID: userID,
-		Role:   role,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
-			IssuedAt:  time.Now().Unix(),
-		},
+// isB64Token reports whether s matches the RFC 6750 b64token grammar
+func isB64Token(s string) bool {
+	trimmed := strings.TrimRight(s, "=")
+	if trimmed == "" {
+		return false
+	}
+	for _, c := range trimmed {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~', c == '+', c == '/':
+		default:
+			return false
+		}
 	}
+	return true
+}
+
+func writeAuthFailure(w http.ResponseWriter, f *authFailure) {
+	w.Header().Set("WWW-Authenticate", bearerChallenge(f.code, f.description, ""))
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secretKey)
+	message := "Authentication required"
+	if f.code != "" {
+		message = "Invalid credentials"
+	}
+	apperrors.Write(w, apperrors.New(f.status, message, f.description))
+}
+
+// bearerChallenge builds a WWW-Authenticate value per RFC 6750 section 3
+func bearerChallenge(code, description, scope string) string {
+	challenge := fmt.Sprintf("Bearer realm=%q", authRealm)
+	if code != "" {
+		challenge += fmt.Sprintf(", error=%q", code)
+		if description != "" {
+			challenge += fmt.Sprintf(", error_description=%q", description)
+		}
+	}
+	if scope != "" {
+		challenge += fmt.Sprintf(", scope=%q", scope)
+	}
+	return challenge
 }
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/cursorrules-golang/internal/authz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubKeyVerifier map[string]*authz.Principal
+
+func (s stubKeyVerifier) Verify(_ context.Context, key string) (*authz.Principal, error) {
+	if p, ok := s[key]; ok {
+		return p, nil
+	}
+	return nil, errors.New("unknown key")
+}
+
+func TestAuthenticatorPrincipal(t *testing.T) {
+	keys := stubKeyVerifier{
+		"ak_good_key": {ID: "9", Permissions: []authz.Permission{authz.UsersRead}, KeyID: 3},
+	}
+	token, err := GenerateToken("9", authz.RoleUser)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		headers        map[string]string
+		expectedStatus int
+		expectedKeyID  int64
+	}{
+		{name: "bearer token", headers: map[string]string{"Authorization": "Bearer " + token}, expectedStatus: http.StatusOK},
+		{name: "api key", headers: map[string]string{"X-API-Key": "ak_good_key"}, expectedStatus: http.StatusOK, expectedKeyID: 3},
+		{name: "unknown api key", headers: map[string]string{"X-API-Key": "ak_bad_key"}, expectedStatus: http.StatusUnauthorized},
+		{name: "no credentials", expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *authz.Principal
+			handler := NewAuthenticator(keys).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = authz.PrincipalFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusOK {
+				require.NotNil(t, got)
+				assert.Equal(t, "9", got.ID)
+				assert.Equal(t, tt.expectedKeyID, got.KeyID)
+			}
+		})
+	}
+}
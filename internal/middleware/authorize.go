@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"example.com/cursorrules-golang/internal/authz"
+	apperrors "example.com/cursorrules-golang/internal/errors"
+)
+
+// Policy maps HTTP methods to the permissions required to call them.
+// Methods missing from the policy are answered with 405 Method Not Allowed.
+type Policy map[string][]authz.Permission
+
+// Authorize enforces the policy against the principal stored by AuthMiddleware
+func Authorize(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := authz.PrincipalFromContext(r.Context())
+			if !ok {
+				writeAuthFailure(w, &authFailure{status: http.StatusUnauthorized, description: "authentication required"})
+				return
+			}
+
+			required, ok := policy[r.Method]
+			if !ok {
+				writeMethodNotAllowed(w, r, Access{Policy: policy}.Allow())
+				return
+			}
+
+			if !principal.HasAll(required...) {
+				if principal.HasAll(withoutMFA(required)...) {
+					apperrors.Write(w, apperrors.NewForbidden("Multi-factor authentication required",
+						"log in again with a one-time code to use this route"))
+					return
+				}
+				w.Header().Set("WWW-Authenticate", bearerChallenge(bearerInsufficientScope,
+					"the token lacks the required scope", joinScopes(required)))
+				apperrors.Write(w, apperrors.NewForbidden("Insufficient permissions",
+					"requires "+joinPermissions(required)))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeMethodNotAllowed answers a request whose method the route does not serve
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request, allow string) {
+	w.Header().Set("Allow", allow)
+	apperrors.Write(w, apperrors.NewMethodNotAllowed("Method not allowed",
+		"method "+r.Method+" is not supported by this resource"))
+}
+
+func joinPermissions(perms []authz.Permission) string {
+	names := make([]string, len(perms))
+	for i, perm := range perms {
+		names[i] = string(perm)
+	}
+	return strings.Join(names, ", ")
+}
+
+// joinScopes formats permissions as an RFC 6750 space-delimited scope list
+func joinScopes(perms []authz.Permission) string {
+	names := make([]string, len(perms))
+	for i, perm := range perms {
+		names[i] = string(perm)
+	}
+	return strings.Join(names, " ")
+}
+
+func withoutMFA(perms []authz.Permission) []authz.Permission {
+	rest := make([]authz.Permission, 0, len(perms))
+	for _, perm := range perms {
+		if perm != authz.MFA {
+			rest = append(rest, perm)
+		}
+	}
+	return rest
+}
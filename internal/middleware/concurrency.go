@@ -0,0 +1,263 @@
+package middleware
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"example.com/cursorrules-golang/internal/authz"
+	apperrors "example.com/cursorrules-golang/internal/errors"
+	"example.com/cursorrules-golang/internal/metrics"
+)
+
+// Priority orders requests competing for a concurrency slot
+type Priority int
+
+// Request priorities
+const (
+	// PriorityNormal requests are queued in arrival order
+	PriorityNormal Priority = iota
+	// PriorityHigh requests are admitted before any normal request and may
+	// displace queued normal requests when the queue is full
+	PriorityHigh
+	// PriorityCritical requests bypass the limiter entirely
+	PriorityCritical
+)
+
+// PriorityFunc assigns a priority to a request
+type PriorityFunc func(r *http.Request) Priority
+
+// DefaultPriority never sheds health probes and serves admins first
+func DefaultPriority(r *http.Request) Priority {
+	if r.Pattern == "/health" {
+		return PriorityCritical
+	}
+	if principal, ok := authz.PrincipalFromContext(r.Context()); ok && principal.Has(authz.Admin) {
+		return PriorityHigh
+	}
+	return PriorityNormal
+}
+
+// ConcurrencyConfig configures a ConcurrencyLimiter
+type ConcurrencyConfig struct {
+	// InitialLimit is the number of requests served at once before any adaptation
+	InitialLimit int
+	// MinLimit and MaxLimit bound the adaptive limit
+	MinLimit int
+	MaxLimit int
+	// QueueSize is how many requests may wait for a slot
+	QueueSize int
+	// QueueTimeout is how long a request waits before it is shed
+	QueueTimeout time.Duration
+	// TargetLatency is the latency above which the limit is decreased. The
+	// limit stays fixed when it is zero.
+	TargetLatency time.Duration
+	// Backoff multiplies the limit when latency exceeds the target
+	Backoff float64
+	// RetryAfter is sent to shed clients
+	RetryAfter time.Duration
+	// Priority assigns request priorities, DefaultPriority when nil
+	Priority PriorityFunc
+}
+
+// ConcurrencyLimiter bounds the number of requests in flight. Requests beyond
+// the limit wait in a bounded queue and are shed with 503 when the queue is
+// full or they wait too long. The limit adapts to observed latency: it grows
+// by one per limit's worth of requests completed while saturated, and shrinks
+// multiplicatively, at most once per TargetLatency, while requests are slow.
+type ConcurrencyLimiter struct {
+	cfg          ConcurrencyConfig
+	mu           sync.Mutex
+	limit        float64
+	inFlight     int
+	queues       [PriorityCritical]*list.List
+	lastDecrease time.Time
+	now          func() time.Time
+}
+
+// waiter is a queued request. admitted is set, and ready closed, once it holds a slot.
+type waiter struct {
+	ready    chan struct{}
+	admitted bool
+}
+
+// NewConcurrencyLimiter creates a limiter, filling in defaults for unset fields
+func NewConcurrencyLimiter(cfg ConcurrencyConfig) *ConcurrencyLimiter {
+	if cfg.InitialLimit <= 0 {
+		cfg.InitialLimit = 32
+	}
+	if cfg.MinLimit <= 0 {
+		cfg.MinLimit = 1
+	}
+	if cfg.MaxLimit < cfg.InitialLimit {
+		cfg.MaxLimit = cfg.InitialLimit
+	}
+	if cfg.QueueTimeout <= 0 {
+		cfg.QueueTimeout = time.Second
+	}
+	if cfg.Backoff <= 0 || cfg.Backoff >= 1 {
+		cfg.Backoff = 0.9
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = time.Second
+	}
+	if cfg.Priority == nil {
+		cfg.Priority = DefaultPriority
+	}
+
+	cl := &ConcurrencyLimiter{
+		cfg:   cfg,
+		limit: float64(cfg.InitialLimit),
+		now:   time.Now,
+	}
+	for i := range cl.queues {
+		cl.queues[i] = list.New()
+	}
+	return cl
+}
+
+// Limit middleware applies the concurrency limit. Install it through
+// Router.Use after rate limiting, so that priorities can see the principal
+// and rejected requests never occupy a slot.
+func (cl *ConcurrencyLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		priority := cl.cfg.Priority(r)
+		if priority >= PriorityCritical {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !cl.acquire(r, priority) {
+			cl.shed(w)
+			return
+		}
+
+		start := cl.now()
+		defer func() { cl.release(cl.now().Sub(start)) }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stats reports the current limit, requests in flight and queued requests
+func (cl *ConcurrencyLimiter) Stats() map[string]interface{} {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return map[string]interface{}{
+		"limit":     int(cl.limit),
+		"in_flight": cl.inFlight,
+		"queued":    cl.queued(),
+	}
+}
+
+// acquire takes a slot, waiting in the queue if necessary. It reports false
+// when the request should be shed.
+func (cl *ConcurrencyLimiter) acquire(r *http.Request, priority Priority) bool {
+	cl.mu.Lock()
+	if cl.inFlight < int(cl.limit) && cl.queued() == 0 {
+		cl.inFlight++
+		cl.mu.Unlock()
+		return true
+	}
+
+	if cl.queued() >= cl.cfg.QueueSize && !cl.displace(priority) {
+		cl.mu.Unlock()
+		return false
+	}
+	w := &waiter{ready: make(chan struct{})}
+	element := cl.queues[priority].PushBack(w)
+	cl.mu.Unlock()
+
+	timer := time.NewTimer(cl.cfg.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return w.admitted
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if w.admitted {
+		// The slot was granted while we were giving up; hand it on
+		cl.inFlight--
+		cl.dispatch()
+		return false
+	}
+	select {
+	case <-w.ready:
+		// Already displaced by a higher priority request
+	default:
+		cl.queues[priority].Remove(element)
+	}
+	return false
+}
+
+// displace sheds the most recently queued request of lower priority to make
+// room. The caller holds cl.mu.
+func (cl *ConcurrencyLimiter) displace(priority Priority) bool {
+	for p := PriorityNormal; p < priority; p++ {
+		if back := cl.queues[p].Back(); back != nil {
+			cl.queues[p].Remove(back)
+			close(back.Value.(*waiter).ready)
+			return true
+		}
+	}
+	return false
+}
+
+// release frees a slot, adapts the limit to the request's latency and admits
+// queued requests
+func (cl *ConcurrencyLimiter) release(latency time.Duration) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	saturated := cl.inFlight >= int(cl.limit)
+	cl.inFlight--
+
+	if target := cl.cfg.TargetLatency; target > 0 {
+		now := cl.now()
+		if latency > target {
+			if now.Sub(cl.lastDecrease) >= target {
+				cl.limit = math.Max(float64(cl.cfg.MinLimit), cl.limit*cl.cfg.Backoff)
+				cl.lastDecrease = now
+			}
+		} else if saturated {
+			cl.limit = math.Min(float64(cl.cfg.MaxLimit), cl.limit+1/cl.limit)
+		}
+	}
+
+	cl.dispatch()
+}
+
+// dispatch admits queued requests, highest priority first, while slots are
+// free. The caller holds cl.mu.
+func (cl *ConcurrencyLimiter) dispatch() {
+	for p := PriorityCritical - 1; p >= PriorityNormal; p-- {
+		queue := cl.queues[p]
+		for cl.inFlight < int(cl.limit) && queue.Len() > 0 {
+			w := queue.Remove(queue.Front()).(*waiter)
+			w.admitted = true
+			cl.inFlight++
+			close(w.ready)
+		}
+	}
+}
+
+// queued counts waiting requests. The caller holds cl.mu.
+func (cl *ConcurrencyLimiter) queued() int {
+	n := 0
+	for _, queue := range cl.queues {
+		n += queue.Len()
+	}
+	return n
+}
+
+func (cl *ConcurrencyLimiter) shed(w http.ResponseWriter) {
+	metrics.GetMetrics().RecordLoadShed()
+	w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(cl.cfg.RetryAfter), 10))
+	apperrors.Write(w, apperrors.NewServiceUnavailable("Server is overloaded", "retry later"))
+}
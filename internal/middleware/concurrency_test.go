@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingHandler holds every request until release is closed
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{started: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (h *blockingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.started <- struct{}{}
+	<-h.release
+}
+
+// serveAsync runs a request in the background and returns its eventual recorder
+func serveAsync(handler http.Handler, header string) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Priority", header)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		done <- w
+	}()
+	return done
+}
+
+func headerPriority(r *http.Request) Priority {
+	switch r.Header.Get("X-Priority") {
+	case "high":
+		return PriorityHigh
+	case "critical":
+		return PriorityCritical
+	}
+	return PriorityNormal
+}
+
+// waitQueued waits until n requests are queued
+func waitQueued(t *testing.T, cl *ConcurrencyLimiter, n int) {
+	t.Helper()
+	assert.Eventually(t, func() bool { return cl.Stats()["queued"] == n }, time.Second, time.Millisecond)
+}
+
+func TestConcurrencyLimiterQueuesAndSheds(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyConfig{
+		InitialLimit: 1, QueueSize: 1, QueueTimeout: time.Minute, RetryAfter: 2 * time.Second,
+		Priority: headerPriority,
+	})
+	h := newBlockingHandler()
+	handler := cl.Limit(h)
+
+	first := serveAsync(handler, "")
+	<-h.started
+	second := serveAsync(handler, "")
+	waitQueued(t, cl, 1)
+
+	w := <-serveAsync(handler, "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	close(h.release)
+	assert.Equal(t, http.StatusOK, (<-first).Code)
+	assert.Equal(t, http.StatusOK, (<-second).Code)
+	assert.Equal(t, 0, cl.Stats()["in_flight"])
+}
+
+func TestConcurrencyLimiterQueueTimeout(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyConfig{
+		InitialLimit: 1, QueueSize: 1, QueueTimeout: 10 * time.Millisecond, Priority: headerPriority,
+	})
+	h := newBlockingHandler()
+	handler := cl.Limit(h)
+
+	first := serveAsync(handler, "")
+	<-h.started
+	assert.Equal(t, http.StatusServiceUnavailable, (<-serveAsync(handler, "")).Code)
+	assert.Equal(t, 0, cl.Stats()["queued"])
+
+	close(h.release)
+	assert.Equal(t, http.StatusOK, (<-first).Code)
+}
+
+func TestConcurrencyLimiterPriorities(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyConfig{
+		InitialLimit: 1, QueueSize: 1, QueueTimeout: time.Minute, Priority: headerPriority,
+	})
+	h := newBlockingHandler()
+	handler := cl.Limit(h)
+
+	first := serveAsync(handler, "")
+	<-h.started
+	normal := serveAsync(handler, "")
+	waitQueued(t, cl, 1)
+
+	// A high priority request displaces the queued normal one
+	high := serveAsync(handler, "high")
+	assert.Equal(t, http.StatusServiceUnavailable, (<-normal).Code)
+	waitQueued(t, cl, 1)
+
+	// Critical requests bypass the limit altogether
+	critical := serveAsync(handler, "critical")
+	<-h.started
+
+	close(h.release)
+	for _, done := range []<-chan *httptest.ResponseRecorder{first, high, critical} {
+		assert.Equal(t, http.StatusOK, (<-done).Code)
+	}
+}
+
+func TestConcurrencyLimiterAdaptsToLatency(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cl := NewConcurrencyLimiter(ConcurrencyConfig{
+		InitialLimit: 10, MinLimit: 2, MaxLimit: 20, TargetLatency: 100 * time.Millisecond, Backoff: 0.5,
+	})
+	cl.now = func() time.Time { return now }
+
+	// Slow requests halve the limit, at most once per target latency
+	cl.inFlight = 2
+	cl.release(time.Second)
+	cl.release(time.Second)
+	assert.Equal(t, 5, cl.Stats()["limit"])
+
+	now = now.Add(time.Second)
+	for i := 0; i < 5; i++ {
+		cl.inFlight = 1
+		cl.release(time.Second)
+		now = now.Add(time.Second)
+	}
+	assert.Equal(t, 2, cl.Stats()["limit"])
+
+	// Fast requests completed while saturated grow it by roughly one per limit's worth
+	for i := 0; i < 3; i++ {
+		cl.inFlight = int(cl.limit)
+		cl.release(time.Millisecond)
+	}
+	assert.Equal(t, 3, cl.Stats()["limit"])
+
+	// Fast requests with spare capacity leave it alone
+	cl.inFlight = 1
+	cl.release(time.Millisecond)
+	assert.Equal(t, 3, cl.Stats()["limit"])
+}
+
+func TestConcurrencyLimiterUnderLoad(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyConfig{InitialLimit: 4, QueueSize: 100, QueueTimeout: time.Second})
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	handler := cl.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, peak, 4)
+	assert.Equal(t, 0, cl.Stats()["in_flight"])
+}
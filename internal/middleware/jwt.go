@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JWTConfig controls how tokens are issued and validated
+type JWTConfig struct {
+	Secret []byte
+	// Issuer, when set, is written to issued tokens and required on incoming ones
+	Issuer string
+	// Audience, when set, is written to issued tokens and required on incoming ones
+	Audience string
+	// Leeway tolerates clock skew when checking exp, nbf and iat
+	Leeway time.Duration
+	// TTL is the lifetime of issued tokens
+	TTL time.Duration
+}
+
+var jwtConfig = JWTConfig{
+	Secret: []byte("your-secret-key"), // development default, override with ConfigureJWT
+	Leeway: 30 * time.Second,
+	TTL:    24 * time.Hour,
+}
+
+// ConfigureJWT replaces the token configuration. Call it once at startup.
+func ConfigureJWT(cfg JWTConfig) {
+	if len(cfg.Secret) == 0 {
+		cfg.Secret = jwtConfig.Secret
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	jwtConfig = cfg
+}
+
+// Authentication method references (RFC 8176) recorded in the amr claim
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+)
+
+type Claims struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+	// AMR lists how the user authenticated, e.g. ["pwd", "otp", "mfa"]
+	AMR []string `json:"amr,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// MFA reports whether the token was issued after multi-factor authentication
+func (c *Claims) MFA() bool {
+	for _, method := range c.AMR {
+		if method == AMRMFA {
+			return true
+		}
+	}
+	return false
+}
+
+// Token validation failures
+var (
+	errTokenMalformed     = errors.New("token is malformed")
+	errTokenSignature     = errors.New("token signature is invalid")
+	errTokenExpired       = errors.New("token is expired")
+	errTokenNotYetValid   = errors.New("token is not valid yet")
+	errTokenIssuedLater   = errors.New("token was issued in the future")
+	errTokenWrongIssuer   = errors.New("token issuer is not accepted")
+	errTokenWrongAudience = errors.New("token audience is not accepted")
+)
+
+var tokenParser = jwt.NewParser(
+	jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+	// Claims are checked by validateClaims so that leeway applies
+	jwt.WithoutClaimsValidation(),
+)
+
+// parseToken verifies the token signature and its registered claims
+func parseToken(tokenString string, now time.Time) (*Claims, error) {
+	claims := &Claims{}
+	_, err := tokenParser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtConfig.Secret, nil
+	})
+
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorSignatureInvalid != 0 {
+		return nil, errTokenSignature
+	} else if err != nil {
+		return nil, errTokenMalformed
+	}
+
+	if err := validateClaims(claims, now); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func validateClaims(claims *Claims, now time.Time) error {
+	leeway := jwtConfig.Leeway
+
+	if !claims.VerifyExpiresAt(now.Add(-leeway), true) {
+		return errTokenExpired
+	}
+	if !claims.VerifyNotBefore(now.Add(leeway), false) {
+		return errTokenNotYetValid
+	}
+	if !claims.VerifyIssuedAt(now.Add(leeway), false) {
+		return errTokenIssuedLater
+	}
+	if jwtConfig.Issuer != "" && !claims.VerifyIssuer(jwtConfig.Issuer, true) {
+		return errTokenWrongIssuer
+	}
+	if jwtConfig.Audience != "" && !claims.VerifyAudience(jwtConfig.Audience, true) {
+		return errTokenWrongAudience
+	}
+	return nil
+}
+
+// GenerateToken creates a new JWT token recording the given authentication methods
+func GenerateToken(userID, role string, amr ...string) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		UserID: userID,
+		Role:   role,
+		AMR:    amr,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			Issuer:    jwtConfig.Issuer,
+			ExpiresAt: jwt.NewNumericDate(now.Add(jwtConfig.TTL)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	if jwtConfig.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{jwtConfig.Audience}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtConfig.Secret)
+}
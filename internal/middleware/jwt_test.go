@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withJWTConfig swaps the package configuration for the duration of a test
+func withJWTConfig(t *testing.T, cfg JWTConfig) {
+	previous := jwtConfig
+	ConfigureJWT(cfg)
+	t.Cleanup(func() { jwtConfig = previous })
+}
+
+func signClaims(t *testing.T, secret string, claims jwt.RegisteredClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserID: "1", Role: "user", RegisteredClaims: claims})
+	signed, err := token.SignedString([]byte(secret))
+	require.NoError(t, err)
+	return signed
+}
+
+func TestTokenValidation(t *testing.T) {
+	withJWTConfig(t, JWTConfig{
+		Secret:   []byte("test-secret"),
+		Issuer:   "users-api",
+		Audience: "users",
+		Leeway:   time.Minute,
+	})
+
+	now := time.Now()
+	valid := jwt.RegisteredClaims{
+		Issuer:    "users-api",
+		Audience:  jwt.ClaimStrings{"users"},
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+	}
+	with := func(modify func(c *jwt.RegisteredClaims)) jwt.RegisteredClaims {
+		c := valid
+		modify(&c)
+		return c
+	}
+
+	tests := []struct {
+		name           string
+		header         string
+		expectedStatus int
+		expectedError  string
+	}{
+		{name: "valid token", header: "Bearer " + signClaims(t, "test-secret", valid), expectedStatus: http.StatusOK},
+		{name: "scheme is case insensitive", header: "bearer " + signClaims(t, "test-secret", valid), expectedStatus: http.StatusOK},
+		{name: "missing scheme", header: signClaims(t, "test-secret", valid), expectedStatus: http.StatusUnauthorized},
+		{name: "basic scheme", header: "Basic dXNlcjpwYXNz", expectedStatus: http.StatusUnauthorized},
+		{name: "empty bearer", header: "Bearer ", expectedStatus: http.StatusBadRequest, expectedError: bearerInvalidRequest},
+		{name: "bearer with extra parts", header: "Bearer a b", expectedStatus: http.StatusBadRequest, expectedError: bearerInvalidRequest},
+		{name: "bad signature", header: "Bearer " + signClaims(t, "other-secret", valid), expectedStatus: http.StatusUnauthorized, expectedError: bearerInvalidToken},
+		{name: "garbage token", header: "Bearer abc.def.ghi", expectedStatus: http.StatusUnauthorized, expectedError: bearerInvalidToken},
+		{name: "expired", header: "Bearer " + signClaims(t, "test-secret", with(func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-2 * time.Minute))
+		})), expectedStatus: http.StatusUnauthorized, expectedError: bearerInvalidToken},
+		{name: "expired within leeway", header: "Bearer " + signClaims(t, "test-secret", with(func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-30 * time.Second))
+		})), expectedStatus: http.StatusOK},
+		{name: "missing expiry", header: "Bearer " + signClaims(t, "test-secret", with(func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = nil
+		})), expectedStatus: http.StatusUnauthorized, expectedError: bearerInvalidToken},
+		{name: "not yet valid", header: "Bearer " + signClaims(t, "test-secret", with(func(c *jwt.RegisteredClaims) {
+			c.NotBefore = jwt.NewNumericDate(now.Add(5 * time.Minute))
+		})), expectedStatus: http.StatusUnauthorized, expectedError: bearerInvalidToken},
+		{name: "not before within leeway", header: "Bearer " + signClaims(t, "test-secret", with(func(c *jwt.RegisteredClaims) {
+			c.NotBefore = jwt.NewNumericDate(now.Add(30 * time.Second))
+		})), expectedStatus: http.StatusOK},
+		{name: "wrong issuer", header: "Bearer " + signClaims(t, "test-secret", with(func(c *jwt.RegisteredClaims) {
+			c.Issuer = "someone-else"
+		})), expectedStatus: http.StatusUnauthorized, expectedError: bearerInvalidToken},
+		{name: "wrong audience", header: "Bearer " + signClaims(t, "test-secret", with(func(c *jwt.RegisteredClaims) {
+			c.Audience = jwt.ClaimStrings{"billing"}
+		})), expectedStatus: http.StatusUnauthorized, expectedError: bearerInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", tt.header)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus != http.StatusOK {
+				challenge := w.Header().Get("WWW-Authenticate")
+				assert.Contains(t, challenge, `Bearer realm="api"`)
+				if tt.expectedError != "" {
+					assert.Contains(t, challenge, `error="`+tt.expectedError+`"`)
+				} else {
+					assert.NotContains(t, challenge, "error=")
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateTokenUsesConfiguredClaims(t *testing.T) {
+	withJWTConfig(t, JWTConfig{Secret: []byte("test-secret"), Issuer: "users-api", Audience: "users"})
+
+	token, err := GenerateToken("5", "admin")
+	require.NoError(t, err)
+
+	claims, err := parseToken(token, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "5", claims.UserID)
+	assert.Equal(t, "users-api", claims.Issuer)
+	assert.Equal(t, jwt.ClaimStrings{"users"}, claims.Audience)
+}
@@ -7,7 +7,7 @@ import (
 
 func Logging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL)
+		log.Printf("%s %s %s", ClientIP(r), r.Method, r.URL)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"example.com/cursorrules-golang/internal/authz"
+	apperrors "example.com/cursorrules-golang/internal/errors"
+	"example.com/cursorrules-golang/internal/metrics"
+	"example.com/cursorrules-golang/internal/quota"
+)
+
+// QuotaEnforcer counts a request against its subject's quota
+type QuotaEnforcer interface {
+	Consume(ctx context.Context, subject, route string) (quota.Result, error)
+}
+
+// Quota middleware enforces the caller's plan quotas and reports the tightest
+// one in X-Quota-* headers, with the reset given in seconds like
+// RateLimit-Reset. Unauthenticated requests are not counted. Install it
+// through Router.Use after the rate and concurrency limiters, so that requests
+// rejected there do not use up quota. Routes registered with one of the exempt
+// patterns are never counted.
+func Quota(enforcer QuotaEnforcer, exempt ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := authz.PrincipalFromContext(r.Context())
+			if !ok || slices.Contains(exempt, r.Pattern) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res, err := enforcer.Consume(r.Context(), principal.ID, r.Pattern)
+			if err != nil {
+				// Quotas are billing limits, not protection; keep serving
+				log.Printf("quota error, allowing request: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			usage, limited := res.Tightest()
+			if limited {
+				h := w.Header()
+				h.Set("X-Quota-Limit", strconv.FormatInt(usage.Max, 10))
+				h.Set("X-Quota-Remaining", strconv.FormatInt(usage.Remaining, 10))
+				h.Set("X-Quota-Reset", strconv.FormatInt(ceilSeconds(time.Until(usage.ResetsAt)), 10))
+			}
+			if !res.Allowed {
+				metrics.GetMetrics().RecordRateLimit()
+				w.Header().Set("Retry-After", w.Header().Get("X-Quota-Reset"))
+				apperrors.Write(w, apperrors.NewTooManyRequests("Quota exhausted",
+					"the "+string(usage.Period)+" "+usage.Name+" quota of the "+res.Plan+" plan is used up").
+					WithReason(ReasonQuotaExhausted))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
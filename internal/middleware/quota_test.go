@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example.com/cursorrules-golang/internal/authz"
+	apperrors "example.com/cursorrules-golang/internal/errors"
+	"example.com/cursorrules-golang/internal/quota"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubEnforcer struct {
+	res     quota.Result
+	err     error
+	subject string
+	route   string
+}
+
+func (s *stubEnforcer) Consume(ctx context.Context, subject, route string) (quota.Result, error) {
+	s.subject, s.route = subject, route
+	return s.res, s.err
+}
+
+func serveQuota(enforcer QuotaEnforcer, principal *authz.Principal, exempt ...string) *httptest.ResponseRecorder {
+	router := NewRouter(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(authz.WithPrincipal(r.Context(), principal)))
+		})
+	})
+	router.Use(Quota(enforcer, exempt...))
+	router.Handle("/users/search", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		Authenticated(http.MethodGet))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/search", nil))
+	return w
+}
+
+func TestQuotaMiddleware(t *testing.T) {
+	alice := authz.NewPrincipal("7", authz.RoleUser)
+	usage := quota.Usage{
+		Limit:     quota.Limit{Name: "searches", Route: "/users/search", Period: quota.Monthly, Max: 100},
+		Used:      40,
+		Remaining: 60,
+		ResetsAt:  time.Now().Add(time.Hour),
+	}
+
+	t.Run("allowed requests carry quota headers", func(t *testing.T) {
+		enforcer := &stubEnforcer{res: quota.Result{Allowed: true, Plan: "free", Usage: []quota.Usage{usage}}}
+		w := serveQuota(enforcer, alice)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "7", enforcer.subject)
+		assert.Equal(t, "/users/search", enforcer.route)
+		assert.Equal(t, "100", w.Header().Get("X-Quota-Limit"))
+		assert.Equal(t, "60", w.Header().Get("X-Quota-Remaining"))
+		assert.Equal(t, "3600", w.Header().Get("X-Quota-Reset"))
+	})
+
+	t.Run("exhausted quotas are rejected distinctly from rate limits", func(t *testing.T) {
+		exhausted := usage
+		exhausted.Used, exhausted.Remaining = 100, 0
+		w := serveQuota(&stubEnforcer{res: quota.Result{Plan: "free", Usage: []quota.Usage{exhausted}}}, alice)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "3600", w.Header().Get("Retry-After"))
+
+		var body apperrors.AppError
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, ReasonQuotaExhausted, body.Reason)
+		assert.Equal(t, "Quota exhausted", body.Message)
+	})
+
+	t.Run("unlimited plans send no headers", func(t *testing.T) {
+		w := serveQuota(&stubEnforcer{res: quota.Result{Allowed: true, Plan: "unlimited"}}, alice)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("X-Quota-Limit"))
+	})
+
+	t.Run("exempt routes are not counted", func(t *testing.T) {
+		enforcer := &stubEnforcer{res: quota.Result{Plan: "free", Usage: []quota.Usage{usage}}}
+		w := serveQuota(enforcer, alice, "/users/search")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, enforcer.subject)
+	})
+
+	t.Run("enforcer errors fail open", func(t *testing.T) {
+		w := serveQuota(&stubEnforcer{err: errors.New("database is locked")}, alice)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
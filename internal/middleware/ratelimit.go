@@ -1,63 +1,293 @@
 package middleware
 
 import (
+	"context"
+	"hash/fnv"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
+
+	"example.com/cursorrules-golang/internal/authz"
+	apperrors "example.com/cursorrules-golang/internal/errors"
+	"example.com/cursorrules-golang/internal/metrics"
+	"example.com/cursorrules-golang/internal/ratelimit"
+)
+
+// KeyFunc picks the bucket a request is counted against
+type KeyFunc func(r *http.Request) string
+
+// PrincipalOrIPKey counts authenticated callers per API key or user and
+// everyone else per client IP
+func PrincipalOrIPKey(r *http.Request) string {
+	if principal, ok := authz.PrincipalFromContext(r.Context()); ok {
+		if principal.KeyID != 0 {
+			return "key:" + strconv.FormatInt(principal.KeyID, 10)
+		}
+		return "user:" + principal.ID
+	}
+	return "ip:" + ClientIP(r)
+}
+
+// IPKey counts every request per client IP, for limiters that run before
+// authentication
+func IPKey(r *http.Request) string {
+	return "ip:" + ClientIP(r)
+}
+
+const (
+	// rateLimiterShards spreads buckets over independently locked maps
+	rateLimiterShards = 32
+	// DefaultMaxKeys caps the number of buckets a RateLimiter tracks
+	DefaultMaxKeys = 100000
+	// evictionSamples is how many buckets are compared when one must be evicted
+	evictionSamples = 8
 )
 
-// RateLimiter implements a simple token bucket algorithm
+// bucket is the token bucket state of a single key
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateShard owns a slice of the key space
+type rateShard struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// RateLimiter implements a simple token bucket algorithm. Memory is bounded:
+// buckets that have refilled completely are indistinguishable from new ones
+// and are dropped by the janitor, and the number of tracked keys is capped.
 type RateLimiter struct {
-	rate       float64
-	bucketSize float64
-	mu         sync.Mutex
-	tokens     map[string]float64
-	lastRefill map[string]time.Time
+	rate         float64
+	bucketSize   float64
+	keyFunc      KeyFunc
+	maxKeysShard int
+	shards       [rateLimiterShards]rateShard
+	now          func() time.Time
+	stop         chan struct{}
+	stopOnce     sync.Once
 }
 
-// NewRateLimiter creates a new rate limiter
+// NewRateLimiter creates a new rate limiter keyed by PrincipalOrIPKey
 func NewRateLimiter(rate, bucketSize float64) *RateLimiter {
-	return &RateLimiter{
+	rl := &RateLimiter{
 		rate:       rate,
 		bucketSize: bucketSize,
-		tokens:     make(map[string]float64),
-		lastRefill: make(map[string]time.Time),
+		keyFunc:    PrincipalOrIPKey,
+		now:        time.Now,
+		stop:       make(chan struct{}),
+	}
+	for i := range rl.shards {
+		rl.shards[i].buckets = make(map[string]*bucket)
 	}
+	return rl.WithMaxKeys(DefaultMaxKeys)
 }
 
-// RateLimit middleware implements rate limiting
-func (rl *RateLimiter) RateLimit(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
-
-		rl.mu.Lock()
-		now := time.Now()
-		last, exists := rl.lastRefill[ip]
+// WithKeyFunc replaces the function that picks a request's bucket
+func (rl *RateLimiter) WithKeyFunc(keyFunc KeyFunc) *RateLimiter {
+	rl.keyFunc = keyFunc
+	return rl
+}
 
-		if !exists {
-			rl.tokens[ip] = rl.bucketSize
-			rl.lastRefill[ip] = now
-		} else {
-			elapsed := now.Sub(last).Seconds()
-			newTokens := elapsed * rl.rate
+// WithMaxKeys caps the number of tracked keys. When the cap is reached the
+// least recently seen of a few sampled buckets is evicted.
+func (rl *RateLimiter) WithMaxKeys(maxKeys int) *RateLimiter {
+	perShard := (maxKeys + rateLimiterShards - 1) / rateLimiterShards
+	if perShard < 1 {
+		perShard = 1
+	}
+	rl.maxKeysShard = perShard
+	return rl
+}
 
-			if tokens := rl.tokens[ip] + newTokens; tokens > rl.bucketSize {
-				rl.tokens[ip] = rl.bucketSize
-			} else {
-				rl.tokens[ip] = tokens
+// StartJanitor drops idle buckets every interval until Stop is called
+func (rl *RateLimiter) StartJanitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rl.sweep()
+			case <-rl.stop:
+				return
 			}
-			rl.lastRefill[ip] = now
 		}
+	}()
+}
 
-		if rl.tokens[ip] < 1 {
-			rl.mu.Unlock()
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-			return
+// Stop ends the janitor. It is safe to call more than once.
+func (rl *RateLimiter) Stop() {
+	rl.stopOnce.Do(func() { close(rl.stop) })
+}
+
+// Len returns the number of tracked keys
+func (rl *RateLimiter) Len() int {
+	n := 0
+	for i := range rl.shards {
+		shard := &rl.shards[i]
+		shard.mu.Lock()
+		n += len(shard.buckets)
+		shard.mu.Unlock()
+	}
+	return n
+}
+
+// sweep removes every bucket that has refilled completely
+func (rl *RateLimiter) sweep() {
+	now := rl.now()
+	for i := range rl.shards {
+		shard := &rl.shards[i]
+		shard.mu.Lock()
+		for key, b := range shard.buckets {
+			if rl.idle(b, now) {
+				delete(shard.buckets, key)
+			}
 		}
+		shard.mu.Unlock()
+	}
+}
+
+// idle reports whether the bucket would be full by now. Buckets that never
+// refill are never idle.
+func (rl *RateLimiter) idle(b *bucket, now time.Time) bool {
+	if rl.rate <= 0 {
+		return false
+	}
+	return b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.bucketSize
+}
+
+func (rl *RateLimiter) shard(key string) *rateShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &rl.shards[h.Sum32()%rateLimiterShards]
+}
 
-		rl.tokens[ip]--
-		rl.mu.Unlock()
+// evict makes room in a full shard. The caller holds shard.mu.
+func (rl *RateLimiter) evict(shard *rateShard, now time.Time) {
+	var victim string
+	var oldest time.Time
+	sampled := 0
+	// Map iteration order is randomised, so this samples arbitrary buckets
+	for key, b := range shard.buckets {
+		if rl.idle(b, now) {
+			victim = key
+			break
+		}
+		if sampled == 0 || b.last.Before(oldest) {
+			victim, oldest = key, b.last
+		}
+		if sampled++; sampled == evictionSamples {
+			break
+		}
+	}
+	delete(shard.buckets, victim)
+}
 
+// RateLimit middleware implements rate limiting. Install it after
+// authentication so that callers are counted by identity.
+func (rl *RateLimiter) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !writeRateLimit(w, rl.take(rl.keyFunc(r))) {
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Allow takes a token from the key's bucket. It implements ratelimit.Limiter.
+func (rl *RateLimiter) Allow(ctx context.Context, key string) (ratelimit.Result, error) {
+	return rl.take(key), nil
+}
+
+// take refills the key's bucket and takes a token from it if one is available
+func (rl *RateLimiter) take(key string) ratelimit.Result {
+	shard := rl.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := rl.now()
+	b, exists := shard.buckets[key]
+
+	if !exists {
+		if len(shard.buckets) >= rl.maxKeysShard {
+			rl.evict(shard, now)
+		}
+		b = &bucket{tokens: rl.bucketSize, last: now}
+		shard.buckets[key] = b
+	} else {
+		elapsed := now.Sub(b.last).Seconds()
+		newTokens := elapsed * rl.rate
+
+		if tokens := b.tokens + newTokens; tokens > rl.bucketSize {
+			b.tokens = rl.bucketSize
+		} else {
+			b.tokens = tokens
+		}
+		b.last = now
+	}
+
+	res := ratelimit.Result{Limit: int(rl.bucketSize)}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else if rl.rate > 0 {
+		res.RetryAfter = secondsToDuration((1 - b.tokens) / rl.rate)
+	} else {
+		res.RetryAfter = neverReplenished
+	}
+
+	res.Remaining = int(math.Floor(b.tokens))
+	if rl.rate > 0 {
+		res.ResetAfter = secondsToDuration((rl.bucketSize - b.tokens) / rl.rate)
+	} else {
+		res.ResetAfter = neverReplenished
+	}
+	return res
+}
+
+// Reasons given in 429 responses
+const (
+	ReasonRateLimited    = "rate_limited"
+	ReasonQuotaExhausted = "quota_exhausted"
+)
+
+// neverReplenished marks the reset and retry times of buckets with a zero rate
+const neverReplenished = time.Duration(-1)
+
+// writeRateLimit sets the RateLimit-* headers described by the IETF
+// draft-ietf-httpapi-ratelimit-headers and rejects the request when it was
+// not allowed. It reports whether the request may proceed.
+func writeRateLimit(w http.ResponseWriter, res ratelimit.Result) bool {
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	if res.ResetAfter >= 0 {
+		h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(res.ResetAfter), 10))
+	}
+	if res.Allowed {
+		return true
+	}
+
+	metrics.GetMetrics().RecordRateLimit()
+	detail := "the request budget is exhausted"
+	if res.RetryAfter >= 0 {
+		retryAfter := ceilSeconds(res.RetryAfter)
+		h.Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+		detail = "retry after " + strconv.FormatInt(retryAfter, 10) + " seconds"
+	}
+	apperrors.Write(w, apperrors.NewTooManyRequests("Rate limit exceeded", detail).WithReason(ReasonRateLimited))
+	return false
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// ceilSeconds rounds up so that clients never retry too early
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
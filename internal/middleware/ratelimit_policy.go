@@ -0,0 +1,253 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/cursorrules-golang/internal/authz"
+	"example.com/cursorrules-golang/internal/ratelimit"
+)
+
+// Method classes accepted in RateLimitRule.Method besides literal methods
+const (
+	MethodClassRead  = "read"
+	MethodClassWrite = "write"
+)
+
+// RoleAnonymous matches requests without a principal in RateLimitRule.Role
+const RoleAnonymous = "anonymous"
+
+// RateLimitRule sets the budget for the requests it matches. Empty fields
+// match anything.
+type RateLimitRule struct {
+	// Pattern is the route pattern the handler was registered with, e.g. "/users/search"
+	Pattern string `json:"pattern,omitempty"`
+	// Method is an HTTP method, "read" (GET, HEAD) or "write" (everything else)
+	Method string `json:"method,omitempty"`
+	// Role is the principal's role, or "anonymous" for unauthenticated requests
+	Role string `json:"role,omitempty"`
+	// Rate is the number of tokens added per second
+	Rate float64 `json:"rate"`
+	// Burst is the bucket size
+	Burst float64 `json:"burst"`
+	// Exempt requests are never limited
+	Exempt bool `json:"exempt,omitempty"`
+}
+
+// RateLimitConfig is a policy table. The first matching rule applies and
+// Default covers requests no rule matches.
+type RateLimitConfig struct {
+	Default RateLimitRule   `json:"default"`
+	Rules   []RateLimitRule `json:"rules"`
+	// ExemptCIDRs lists internal callers that bypass rate limiting entirely
+	ExemptCIDRs []string `json:"exempt_cidrs,omitempty"`
+	// ExemptPaths lists request paths that bypass rate limiting, e.g. health
+	// probes. Unlike rule patterns they are matched against the URL path, so
+	// they also apply to a limiter installed in front of the router.
+	ExemptPaths []string `json:"exempt_paths,omitempty"`
+	// MaxKeys caps the buckets tracked per rule, DefaultMaxKeys when zero
+	MaxKeys int `json:"max_keys,omitempty"`
+	// Algorithm selects how budgets are enforced. The in-process token bucket
+	// is used when it is empty and no Store is set.
+	Algorithm ratelimit.Algorithm `json:"algorithm,omitempty"`
+	// Store holds limiter state shared between replicas. Rules with a zero
+	// rate always use in-process buckets.
+	Store ratelimit.Store `json:"-"`
+}
+
+// LoadRateLimitConfig reads a policy table from a JSON file
+func LoadRateLimitConfig(path string) (RateLimitConfig, error) {
+	var cfg RateLimitConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// PolicyRateLimiter applies per-route, per-method and per-role budgets.
+// Every rule has its own buckets, so a caller exhausting one route's budget
+// can still use the others.
+type PolicyRateLimiter struct {
+	rules    []RateLimitRule
+	limiters []ratelimit.Limiter
+	janitors []janitor
+	exempt   []netip.Prefix
+	paths    []string
+	keyFunc  KeyFunc
+}
+
+// janitor is implemented by limiters and stores that sweep expired state
+type janitor interface {
+	StartJanitor(interval time.Duration)
+	Stop()
+}
+
+// NewPolicyRateLimiter validates cfg and creates its buckets
+func NewPolicyRateLimiter(cfg RateLimitConfig) (*PolicyRateLimiter, error) {
+	exempt, err := parsePrefixes(cfg.ExemptCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("exempt_cidrs: %w", err)
+	}
+
+	store := cfg.Store
+	if store == nil && cfg.Algorithm != "" && cfg.Algorithm != ratelimit.AlgorithmTokenBucket {
+		store = ratelimit.NewMemoryStore()
+	}
+	algorithm := cfg.Algorithm
+	if algorithm == "" {
+		algorithm = ratelimit.AlgorithmTokenBucket
+	}
+
+	pl := &PolicyRateLimiter{exempt: exempt, paths: cfg.ExemptPaths, keyFunc: PrincipalOrIPKey}
+	if j, ok := store.(janitor); ok {
+		pl.janitors = append(pl.janitors, j)
+	}
+	// The default rule goes last and matches everything
+	defaultRule := cfg.Default
+	defaultRule.Pattern, defaultRule.Method, defaultRule.Role = "", "", ""
+	for i, rule := range append(append([]RateLimitRule{}, cfg.Rules...), defaultRule) {
+		if rule.Method != MethodClassRead && rule.Method != MethodClassWrite {
+			rule.Method = strings.ToUpper(rule.Method)
+		}
+		if !rule.Exempt && (rule.Rate < 0 || rule.Burst < 1) {
+			return nil, fmt.Errorf("rule %d: rate must be non-negative and burst at least 1", i)
+		}
+
+		var limiter ratelimit.Limiter
+		if store == nil || rule.Rate == 0 || rule.Exempt {
+			inProcess := NewRateLimiter(rule.Rate, rule.Burst)
+			if cfg.MaxKeys > 0 {
+				inProcess.WithMaxKeys(cfg.MaxKeys)
+			}
+			pl.janitors = append(pl.janitors, inProcess)
+			limiter = inProcess
+		} else {
+			// Rules are numbered so that their state does not collide in a shared store
+			shared, err := ratelimit.New(algorithm, prefixedStore{store, "rule" + strconv.Itoa(i) + ":"},
+				ratelimit.Limit{Rate: rule.Rate, Burst: int(rule.Burst)})
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+			limiter = shared
+		}
+		pl.rules = append(pl.rules, rule)
+		pl.limiters = append(pl.limiters, limiter)
+	}
+	return pl, nil
+}
+
+// prefixedStore namespaces the keys of a shared store
+type prefixedStore struct {
+	ratelimit.Store
+	prefix string
+}
+
+func (s prefixedStore) Update(ctx context.Context, key string, fn ratelimit.UpdateFunc) error {
+	return s.Store.Update(ctx, s.prefix+key, fn)
+}
+
+// WithKeyFunc replaces the function that picks a request's bucket
+func (pl *PolicyRateLimiter) WithKeyFunc(keyFunc KeyFunc) *PolicyRateLimiter {
+	pl.keyFunc = keyFunc
+	return pl
+}
+
+// StartJanitor drops idle buckets of every rule every interval until Stop is called
+func (pl *PolicyRateLimiter) StartJanitor(interval time.Duration) {
+	for _, j := range pl.janitors {
+		j.StartJanitor(interval)
+	}
+}
+
+// Stop ends the janitors
+func (pl *PolicyRateLimiter) Stop() {
+	for _, j := range pl.janitors {
+		j.Stop()
+	}
+}
+
+// RateLimit middleware limits requests by the first matching rule. Install
+// it through Router.Use so that the route pattern and principal are known,
+// or around the router keyed by IPKey so that requests failing
+// authentication, unmatched paths and preflights are limited too.
+func (pl *PolicyRateLimiter) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if pl.isExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		i := pl.match(r)
+		if pl.rules[i].Exempt {
+			next.ServeHTTP(w, r)
+			return
+		}
+		res, err := pl.limiters[i].Allow(r.Context(), pl.keyFunc(r))
+		if err != nil {
+			// An unavailable shared store must not take the API down with it
+			log.Printf("rate limit store error, allowing request: %v", err)
+		} else if !writeRateLimit(w, res) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (pl *PolicyRateLimiter) isExempt(r *http.Request) bool {
+	if slices.Contains(pl.paths, r.URL.Path) {
+		return true
+	}
+	if len(pl.exempt) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ClientIP(r))
+	return err == nil && containsAddr(pl.exempt, addr)
+}
+
+// match returns the index of the first rule matching the request. The
+// trailing default rule always matches.
+func (pl *PolicyRateLimiter) match(r *http.Request) int {
+	role := RoleAnonymous
+	if principal, ok := authz.PrincipalFromContext(r.Context()); ok {
+		role = principal.Role
+	}
+
+	for i, rule := range pl.rules {
+		if rule.Pattern != "" && rule.Pattern != r.Pattern {
+			continue
+		}
+		if rule.Method != "" && !methodMatches(rule.Method, r.Method) {
+			continue
+		}
+		if rule.Role != "" && rule.Role != role {
+			continue
+		}
+		return i
+	}
+	return len(pl.rules) - 1
+}
+
+func methodMatches(want, method string) bool {
+	switch want {
+	case MethodClassRead:
+		return method == http.MethodGet || method == http.MethodHead
+	case MethodClassWrite:
+		return method != http.MethodGet && method != http.MethodHead
+	default:
+		return want == method
+	}
+}
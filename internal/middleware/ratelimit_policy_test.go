@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"example.com/cursorrules-golang/internal/authz"
+	"example.com/cursorrules-golang/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPolicyRouter serves "/users" and "/health" behind the policy limiter.
+// Requests carrying an X-Role header are authenticated with that role.
+func newPolicyRouter(t *testing.T, cfg RateLimitConfig) *Router {
+	t.Helper()
+	limiter, err := NewPolicyRateLimiter(cfg)
+	require.NoError(t, err)
+
+	authenticate := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := authz.NewPrincipal("u-"+r.Header.Get("X-Role"), r.Header.Get("X-Role"))
+			next.ServeHTTP(w, r.WithContext(authz.WithPrincipal(r.Context(), principal)))
+		})
+	}
+	router := NewRouter(authenticate)
+	router.Use(limiter.RateLimit)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.Handle("/users", ok, Authenticated(http.MethodGet, http.MethodPost))
+	router.Handle("/health", ok, Public(http.MethodGet))
+	return router
+}
+
+func policyRequest(router http.Handler, method, target, role, remoteAddr string) int {
+	req := httptest.NewRequest(method, target, nil)
+	req.RemoteAddr = remoteAddr
+	if role != "" {
+		req.Header.Set("X-Role", role)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestPolicyRateLimiter(t *testing.T) {
+	cfg := RateLimitConfig{
+		Default: RateLimitRule{Rate: 0, Burst: 1},
+		Rules: []RateLimitRule{
+			{Pattern: "/health", Exempt: true},
+			{Pattern: "/users", Role: authz.RoleAdmin, Rate: 0, Burst: 5},
+			{Pattern: "/users", Method: MethodClassWrite, Rate: 0, Burst: 1},
+			{Pattern: "/users", Method: MethodClassRead, Rate: 0, Burst: 2},
+		},
+		ExemptCIDRs: []string{"10.0.0.0/8"},
+	}
+
+	t.Run("reads and writes have separate budgets", func(t *testing.T) {
+		router := newPolicyRouter(t, cfg)
+		assert.Equal(t, http.StatusOK, policyRequest(router, http.MethodGet, "/users", authz.RoleUser, "192.0.2.1:1"))
+		assert.Equal(t, http.StatusOK, policyRequest(router, http.MethodGet, "/users", authz.RoleUser, "192.0.2.1:1"))
+		assert.Equal(t, http.StatusTooManyRequests, policyRequest(router, http.MethodGet, "/users", authz.RoleUser, "192.0.2.1:1"))
+		assert.Equal(t, http.StatusOK, policyRequest(router, http.MethodPost, "/users", authz.RoleUser, "192.0.2.1:1"))
+		assert.Equal(t, http.StatusTooManyRequests, policyRequest(router, http.MethodPost, "/users", authz.RoleUser, "192.0.2.1:1"))
+	})
+
+	t.Run("role rules take precedence when listed first", func(t *testing.T) {
+		router := newPolicyRouter(t, cfg)
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusOK, policyRequest(router, http.MethodPost, "/users", authz.RoleAdmin, "192.0.2.1:1"))
+		}
+		assert.Equal(t, http.StatusTooManyRequests, policyRequest(router, http.MethodPost, "/users", authz.RoleAdmin, "192.0.2.1:1"))
+	})
+
+	t.Run("exempt routes and internal callers are never limited", func(t *testing.T) {
+		router := newPolicyRouter(t, cfg)
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, http.StatusOK, policyRequest(router, http.MethodGet, "/health", "", "192.0.2.1:1"))
+			assert.Equal(t, http.StatusOK, policyRequest(router, http.MethodPost, "/users", authz.RoleUser, "10.1.2.3:1"))
+		}
+	})
+
+	t.Run("unmatched requests fall back to the default", func(t *testing.T) {
+		router := newPolicyRouter(t, RateLimitConfig{Default: RateLimitRule{Rate: 0, Burst: 1}})
+		assert.Equal(t, http.StatusOK, policyRequest(router, http.MethodGet, "/health", "", "192.0.2.1:1"))
+		assert.Equal(t, http.StatusTooManyRequests, policyRequest(router, http.MethodGet, "/health", "", "192.0.2.1:1"))
+	})
+}
+
+func TestPreAuthRateLimit(t *testing.T) {
+	limiter, err := NewPolicyRateLimiter(RateLimitConfig{
+		Default:     RateLimitRule{Rate: 0, Burst: 3},
+		ExemptPaths: []string{"/health"},
+	})
+	require.NoError(t, err)
+	limiter.WithKeyFunc(IPKey)
+
+	// Every caller fails authentication
+	reject := func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	}
+	router := NewRouter(reject)
+	router.Handle("/users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), Authenticated(http.MethodGet))
+	router.Handle("/health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), Public(http.MethodGet))
+	handler := limiter.RateLimit(router)
+
+	// Failed authentication, preflights and unmatched paths share the budget
+	assert.Equal(t, http.StatusUnauthorized, policyRequest(handler, http.MethodGet, "/users", "", "192.0.2.1:1"))
+	assert.Equal(t, http.StatusNoContent, policyRequest(handler, http.MethodOptions, "/users", "", "192.0.2.1:1"))
+	assert.Equal(t, http.StatusNotFound, policyRequest(handler, http.MethodGet, "/missing", "", "192.0.2.1:1"))
+	assert.Equal(t, http.StatusTooManyRequests, policyRequest(handler, http.MethodGet, "/users", "", "192.0.2.1:1"))
+
+	// Probes are exempt by path, since no route pattern is known yet
+	assert.Equal(t, http.StatusOK, policyRequest(handler, http.MethodGet, "/health", "", "192.0.2.1:1"))
+
+	assert.Equal(t, http.StatusUnauthorized, policyRequest(handler, http.MethodGet, "/users", "", "192.0.2.2:1"))
+}
+
+func TestNewPolicyRateLimiterValidates(t *testing.T) {
+	_, err := NewPolicyRateLimiter(RateLimitConfig{Default: RateLimitRule{Rate: 1, Burst: 0}})
+	assert.Error(t, err)
+
+	_, err = NewPolicyRateLimiter(RateLimitConfig{
+		Default:     RateLimitRule{Rate: 1, Burst: 1},
+		ExemptCIDRs: []string{"not-a-cidr"},
+	})
+	assert.Error(t, err)
+}
+
+func TestLoadRateLimitConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimit.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"default": {"rate": 100, "burst": 1000},
+		"rules": [{"pattern": "/users/search", "method": "get", "rate": 5, "burst": 10}],
+		"exempt_cidrs": ["127.0.0.1"]
+	}`), 0o600))
+
+	cfg, err := LoadRateLimitConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, RateLimitRule{Rate: 100, Burst: 1000}, cfg.Default)
+	require.Len(t, cfg.Rules, 1)
+	assert.Equal(t, "/users/search", cfg.Rules[0].Pattern)
+	assert.Equal(t, []string{"127.0.0.1"}, cfg.ExemptCIDRs)
+
+	limiter, err := NewPolicyRateLimiter(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodGet, limiter.rules[0].Method)
+}
+
+type failingStore struct{}
+
+func (failingStore) Update(ctx context.Context, key string, fn ratelimit.UpdateFunc) error {
+	return errors.New("store unavailable")
+}
+
+func TestPolicyRateLimiterSharedStore(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	cfg := RateLimitConfig{
+		Default:   RateLimitRule{Rate: 0.001, Burst: 2},
+		Algorithm: ratelimit.AlgorithmGCRA,
+		Store:     store,
+	}
+
+	// Two replicas enforce one budget
+	first := newPolicyRouter(t, cfg)
+	second := newPolicyRouter(t, cfg)
+	assert.Equal(t, http.StatusOK, policyRequest(first, http.MethodGet, "/users", authz.RoleUser, "192.0.2.1:1"))
+	assert.Equal(t, http.StatusOK, policyRequest(second, http.MethodGet, "/users", authz.RoleUser, "192.0.2.1:1"))
+	assert.Equal(t, http.StatusTooManyRequests, policyRequest(first, http.MethodGet, "/users", authz.RoleUser, "192.0.2.1:1"))
+	assert.Equal(t, http.StatusTooManyRequests, policyRequest(second, http.MethodGet, "/users", authz.RoleUser, "192.0.2.1:1"))
+
+	t.Run("store errors fail open", func(t *testing.T) {
+		cfg.Store = failingStore{}
+		router := newPolicyRouter(t, cfg)
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusOK, policyRequest(router, http.MethodGet, "/users", authz.RoleUser, "192.0.2.1:1"))
+		}
+	})
+}
+
+func TestPolicyRateLimiterAlgorithms(t *testing.T) {
+	for _, algorithm := range []ratelimit.Algorithm{
+		ratelimit.AlgorithmTokenBucket, ratelimit.AlgorithmSlidingLog,
+		ratelimit.AlgorithmSlidingWindow, ratelimit.AlgorithmGCRA,
+	} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			router := newPolicyRouter(t, RateLimitConfig{
+				Default:   RateLimitRule{Rate: 0.001, Burst: 1},
+				Algorithm: algorithm,
+			})
+			assert.Equal(t, http.StatusOK, policyRequest(router, http.MethodGet, "/users", authz.RoleUser, "192.0.2.1:1"))
+			assert.Equal(t, http.StatusTooManyRequests, policyRequest(router, http.MethodGet, "/users", authz.RoleUser, "192.0.2.1:1"))
+		})
+	}
+
+	_, err := NewPolicyRateLimiter(RateLimitConfig{Default: RateLimitRule{Rate: 1, Burst: 1}, Algorithm: "leaky"})
+	assert.Error(t, err)
+}
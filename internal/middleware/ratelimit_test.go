@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"example.com/cursorrules-golang/internal/authz"
+	apperrors "example.com/cursorrules-golang/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rateLimitedRequest(handler http.Handler, remoteAddr string, principal *authz.Principal) int {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	if principal != nil {
+		req = req.WithContext(authz.WithPrincipal(req.Context(), principal))
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("ephemeral ports share the client bucket", func(t *testing.T) {
+		handler := NewRateLimiter(0, 2).RateLimit(ok)
+		assert.Equal(t, http.StatusOK, rateLimitedRequest(handler, "10.0.0.1:1111", nil))
+		assert.Equal(t, http.StatusOK, rateLimitedRequest(handler, "10.0.0.1:2222", nil))
+		assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(handler, "10.0.0.1:3333", nil))
+		assert.Equal(t, http.StatusOK, rateLimitedRequest(handler, "10.0.0.2:1111", nil))
+	})
+
+	t.Run("principals are limited independently of their address", func(t *testing.T) {
+		handler := NewRateLimiter(0, 1).RateLimit(ok)
+		alice := authz.NewPrincipal("alice", authz.RoleUser)
+		bob := authz.NewPrincipal("bob", authz.RoleUser)
+		aliceKey := &authz.Principal{ID: "alice", KeyID: 9}
+
+		assert.Equal(t, http.StatusOK, rateLimitedRequest(handler, "10.0.0.1:1", alice))
+		assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(handler, "10.0.0.9:1", alice))
+		assert.Equal(t, http.StatusOK, rateLimitedRequest(handler, "10.0.0.1:1", bob))
+		assert.Equal(t, http.StatusOK, rateLimitedRequest(handler, "10.0.0.1:1", aliceKey), "API keys have their own bucket")
+	})
+
+	t.Run("custom key function", func(t *testing.T) {
+		handler := NewRateLimiter(0, 1).WithKeyFunc(func(r *http.Request) string { return "global" }).RateLimit(ok)
+		assert.Equal(t, http.StatusOK, rateLimitedRequest(handler, "10.0.0.1:1", nil))
+		assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(handler, "10.0.0.2:1", nil))
+	})
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	handler := NewRateLimiter(0.5, 2).RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Reset"))
+	assert.Empty(t, w.Header().Get("Retry-After"))
+
+	send()
+	w = send()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "4", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var body apperrors.AppError
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, http.StatusTooManyRequests, body.Code)
+	assert.Equal(t, "Rate limit exceeded", body.Message)
+	assert.Equal(t, "retry after 2 seconds", body.Detail)
+	assert.Equal(t, ReasonRateLimited, body.Reason)
+}
+
+func TestRateLimitHeadersWithoutRefill(t *testing.T) {
+	handler := NewRateLimiter(0, 1).RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rateLimitedRequest(handler, "192.0.2.1:1", nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:1"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Reset"))
+	assert.Empty(t, w.Header().Get("Retry-After"))
+}
+
+func TestRateLimiterEvictsIdleBuckets(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rl := NewRateLimiter(1, 2)
+	rl.now = func() time.Time { return now }
+
+	rl.take("busy")
+	rl.take("busy")
+	rl.take("idle")
+	assert.Equal(t, 2, rl.Len())
+
+	// "idle" has refilled after one second, "busy" needs two
+	now = now.Add(time.Second)
+	rl.sweep()
+	assert.Equal(t, 1, rl.Len())
+
+	now = now.Add(time.Second)
+	rl.sweep()
+	assert.Equal(t, 0, rl.Len())
+}
+
+func TestRateLimiterCapsKeys(t *testing.T) {
+	rl := NewRateLimiter(0, 1).WithMaxKeys(rateLimiterShards)
+	for i := 0; i < 10000; i++ {
+		rl.take("ip:" + strconv.Itoa(i))
+	}
+	assert.LessOrEqual(t, rl.Len(), rateLimiterShards)
+}
+
+func TestRateLimiterJanitorStops(t *testing.T) {
+	rl := NewRateLimiter(1000, 1)
+	rl.StartJanitor(time.Millisecond)
+	rl.take("a")
+
+	assert.Eventually(t, func() bool { return rl.Len() == 0 }, time.Second, time.Millisecond)
+	rl.Stop()
+	rl.Stop()
+}
+
+func BenchmarkRateLimiterManyKeys(b *testing.B) {
+	keys := make([]string, 100000)
+	for i := range keys {
+		keys[i] = "ip:" + strconv.Itoa(i)
+	}
+	rl := NewRateLimiter(1e9, 1e9)
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Intn(len(keys))
+		for pb.Next() {
+			rl.take(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+func BenchmarkRateLimiterAtCapacity(b *testing.B) {
+	rl := NewRateLimiter(0, 1).WithMaxKeys(10000)
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Int()
+		for pb.Next() {
+			rl.take("ip:" + strconv.Itoa(i))
+			i++
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPContextKey struct{}
+
+// RealIP resolves the client address behind trusted reverse proxies
+type RealIP struct {
+	trusted []netip.Prefix
+}
+
+// NewRealIP creates a resolver that honours forwarding headers only when they
+// were added by a proxy inside one of the trusted CIDRs
+func NewRealIP(trustedCIDRs []string) (*RealIP, error) {
+	trusted, err := parsePrefixes(trustedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return &RealIP{trusted: trusted}, nil
+}
+
+// Middleware stores the resolved client address for ClientIP
+func (rip *RealIP) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPContextKey{}, rip.Resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Resolve returns the client address for the request. Forwarding headers are
+// read right to left, since only the entries appended by our own proxies can
+// be trusted; the first untrusted hop is the client.
+func (rip *RealIP) Resolve(r *http.Request) string {
+	remote := remoteHost(r)
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !rip.isTrusted(addr) {
+		return remote
+	}
+
+	hops := forwardedFor(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			// An unparsable hop cannot be trusted, nor can anything left of it
+			return remote
+		}
+		if !rip.isTrusted(hop) {
+			return hop.String()
+		}
+		remote = hop.String()
+	}
+	return remote
+}
+
+func (rip *RealIP) isTrusted(addr netip.Addr) bool {
+	return containsAddr(rip.trusted, addr)
+}
+
+// parsePrefixes parses CIDRs and bare addresses, skipping blank entries
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the client chain from the RFC 7239 Forwarded header,
+// or from X-Forwarded-For when Forwarded is absent
+func forwardedFor(r *http.Request) []string {
+	var hops []string
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					name, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+					if ok && strings.EqualFold(name, "for") {
+						hops = append(hops, parseForwardedNode(v))
+					}
+				}
+			}
+		}
+		return hops
+	}
+
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseForwardedNode strips quoting, IPv6 brackets and ports from a
+// Forwarded "for" value such as "[2001:db8::1]:4711"
+func parseForwardedNode(v string) string {
+	v = strings.Trim(v, `"`)
+	if strings.HasPrefix(v, "[") {
+		if end := strings.Index(v, "]"); end > 0 {
+			return v[1:end]
+		}
+	}
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		return host
+	}
+	return v
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealIPResolve(t *testing.T) {
+	rip, err := NewRealIP([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "untrusted peer cannot spoof",
+			remoteAddr: "203.0.113.9:4000",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "trusted proxy without headers",
+			remoteAddr: "10.1.2.3:4000",
+			want:       "10.1.2.3",
+		},
+		{
+			name:       "rightmost untrusted hop wins",
+			remoteAddr: "10.1.2.3:4000",
+			headers:    map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7, 192.168.1.1"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "all hops trusted falls back to the leftmost",
+			remoteAddr: "10.1.2.3:4000",
+			headers:    map[string]string{"X-Forwarded-For": "10.9.9.9, 192.168.1.1"},
+			want:       "10.9.9.9",
+		},
+		{
+			name:       "garbage hop stops the walk",
+			remoteAddr: "10.1.2.3:4000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.7, not-an-ip"},
+			want:       "10.1.2.3",
+		},
+		{
+			name:       "forwarded header with ipv6 and port",
+			remoteAddr: "[fd00::1]:4000",
+			headers:    map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https, for=10.0.0.5`},
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "forwarded takes precedence over x-forwarded-for",
+			remoteAddr: "10.1.2.3:4000",
+			headers: map[string]string{
+				"Forwarded":       "for=198.51.100.7",
+				"X-Forwarded-For": "6.6.6.6",
+			},
+			want: "198.51.100.7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			assert.Equal(t, tt.want, rip.Resolve(req))
+		})
+	}
+}
+
+func TestRealIPMiddleware(t *testing.T) {
+	rip, err := NewRealIP([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	var got string
+	handler := rip.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientIP(r)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "198.51.100.7", got)
+
+	t.Run("rate limiter buckets by resolved address", func(t *testing.T) {
+		limited := rip.Middleware(NewRateLimiter(0, 1).RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+		send := func(client string) int {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("X-Forwarded-For", client)
+			w := httptest.NewRecorder()
+			limited.ServeHTTP(w, req)
+			return w.Code
+		}
+		assert.Equal(t, http.StatusOK, send("198.51.100.7"))
+		assert.Equal(t, http.StatusOK, send("198.51.100.8"))
+		assert.Equal(t, http.StatusTooManyRequests, send("198.51.100.7"))
+	})
+}
+
+func TestNewRealIPRejectsInvalidCIDR(t *testing.T) {
+	_, err := NewRealIP([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+}
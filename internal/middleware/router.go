@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Access describes the authentication a route requires
+type Access struct {
+	// Public routes are served without credentials
+	Public bool
+	// Policy lists the methods the route serves and the permissions each requires
+	Policy Policy
+}
+
+// Public allows anyone to call the given methods
+func Public(methods ...string) Access {
+	return Access{Public: true, Policy: methodPolicy(methods)}
+}
+
+// Authenticated allows any authenticated caller to use the given methods
+func Authenticated(methods ...string) Access {
+	return Access{Policy: methodPolicy(methods)}
+}
+
+// Scoped requires callers to hold the permissions listed per method
+func Scoped(policy Policy) Access {
+	return Access{Policy: policy}
+}
+
+// Allow returns the value of the Allow header for the route
+func (a Access) Allow() string {
+	methods := []string{http.MethodOptions}
+	for method := range a.Policy {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+func methodPolicy(methods []string) Policy {
+	policy := make(Policy, len(methods))
+	for _, method := range methods {
+		policy[method] = nil
+	}
+	return policy
+}
+
+// Router registers handlers together with their authentication requirements
+type Router struct {
+	mux          *http.ServeMux
+	authenticate func(http.Handler) http.Handler
+	middleware   []func(http.Handler) http.Handler
+}
+
+// NewRouter creates a router that uses authenticate for non-public routes
+func NewRouter(authenticate func(http.Handler) http.Handler) *Router {
+	return &Router{
+		mux:          http.NewServeMux(),
+		authenticate: authenticate,
+	}
+}
+
+// Use adds middleware that runs after authentication, so it can see the
+// principal. It applies to routes registered after the call.
+func (rt *Router) Use(middleware ...func(http.Handler) http.Handler) {
+	rt.middleware = append(rt.middleware, middleware...)
+}
+
+// Handle registers a handler with the access it requires.
+// OPTIONS preflights are answered without authentication, and methods the
+// route does not list get 405 before authentication is attempted.
+func (rt *Router) Handle(pattern string, handler http.Handler, access Access) {
+	for i := len(rt.middleware) - 1; i >= 0; i-- {
+		handler = rt.middleware[i](handler)
+	}
+	if !access.Public {
+		handler = rt.authenticate(Authorize(access.Policy)(handler))
+	}
+
+	allow := access.Allow()
+	rt.mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Allow", allow)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if _, ok := access.Policy[r.Method]; !ok {
+			writeMethodNotAllowed(w, r, allow)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// HandleFunc registers a handler function with the access it requires
+func (rt *Router) HandleFunc(pattern string, handler http.HandlerFunc, access Access) {
+	rt.Handle(pattern, handler, access)
+}
+
+// ServeHTTP dispatches the request to the matching route
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/cursorrules-golang/internal/authz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterAccess(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	router := NewRouter(AuthMiddleware)
+	router.Handle("/health", ok, Public(http.MethodGet))
+	router.Handle("/profile", ok, Authenticated(http.MethodGet))
+	router.Handle("/admin", ok, Scoped(Policy{http.MethodGet: {authz.Admin}}))
+	router.Handle("/destroy", ok, Scoped(Policy{http.MethodDelete: {authz.Admin, authz.MFA}}))
+
+	userToken, err := GenerateToken("1", authz.RoleUser)
+	require.NoError(t, err)
+	adminToken, err := GenerateToken("2", authz.RoleAdmin)
+	require.NoError(t, err)
+	mfaAdminToken, err := GenerateToken("2", authz.RoleAdmin, AMRPassword, AMROTP, AMRMFA)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{name: "public route without token", method: http.MethodGet, path: "/health", expectedStatus: http.StatusOK},
+		{name: "authenticated route without token", method: http.MethodGet, path: "/profile", expectedStatus: http.StatusUnauthorized},
+		{name: "authenticated route with token", method: http.MethodGet, path: "/profile", token: userToken, expectedStatus: http.StatusOK},
+		{name: "public route with unlisted method", method: http.MethodDelete, path: "/health", expectedStatus: http.StatusMethodNotAllowed},
+		{name: "authenticated route with unlisted method", method: http.MethodPost, path: "/profile", token: userToken, expectedStatus: http.StatusMethodNotAllowed},
+		{name: "unlisted method without token", method: http.MethodPost, path: "/profile", expectedStatus: http.StatusMethodNotAllowed},
+		{name: "scoped route without permission", method: http.MethodGet, path: "/admin", token: userToken, expectedStatus: http.StatusForbidden},
+		{name: "scoped route with permission", method: http.MethodGet, path: "/admin", token: adminToken, expectedStatus: http.StatusOK},
+		{name: "destructive route requires mfa", method: http.MethodDelete, path: "/destroy", token: adminToken, expectedStatus: http.StatusForbidden},
+		{name: "destructive route with mfa", method: http.MethodDelete, path: "/destroy", token: mfaAdminToken, expectedStatus: http.StatusOK},
+		{name: "preflight skips authentication", method: http.MethodOptions, path: "/admin", expectedStatus: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestRouterPreflightAllowHeader(t *testing.T) {
+	router := NewRouter(AuthMiddleware)
+	router.Handle("/users", http.NotFoundHandler(), Scoped(Policy{
+		http.MethodGet:  {authz.UsersRead},
+		http.MethodPost: {authz.UsersWrite},
+	}))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/users", nil))
+
+	assert.Equal(t, "GET, OPTIONS, POST", w.Header().Get("Allow"))
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	router := NewRouter(AuthMiddleware)
+	router.Handle("/health", http.NotFoundHandler(), Public(http.MethodGet))
+	router.Handle("/users", http.NotFoundHandler(), Scoped(Policy{
+		http.MethodGet:  {authz.UsersRead},
+		http.MethodPost: {authz.UsersWrite},
+	}))
+
+	token, err := GenerateToken("1", authz.RoleUser)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		allow string
+	}{
+		{name: "public route", path: "/health", allow: "GET, OPTIONS"},
+		{name: "scoped route", path: "/users", token: token, allow: "GET, OPTIONS, POST"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+			assert.Equal(t, tt.allow, w.Header().Get("Allow"))
+		})
+	}
+}
@@ -6,3 +6,10 @@ type User struct {
 	Email string `json:"email"`
 	Age   int    `json:"age"`
 }
+
+// UserPatch represents a partial update to a user; nil fields are left unchanged
+type UserPatch struct {
+	Name  *string `json:"name,omitempty"`
+	Email *string `json:"email,omitempty"`
+	Age   *int    `json:"age,omitempty"`
+}
@@ -0,0 +1,257 @@
+// Package quota enforces hard daily and monthly request quotas per user.
+// Usage is counted in the database, so it survives restarts and is shared
+// between replicas.
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Period is the interval after which a quota resets
+type Period string
+
+// Quota periods, measured in UTC
+const (
+	Daily   Period = "daily"
+	Monthly Period = "monthly"
+)
+
+// DefaultPlan is the plan of users without one
+const DefaultPlan = "free"
+
+// ErrUnknownPlan is returned when assigning a plan that is not configured
+var ErrUnknownPlan = errors.New("quota: unknown plan")
+
+// ErrUnknownUser is returned when assigning a plan to a user that does not exist
+var ErrUnknownUser = errors.New("quota: unknown user")
+
+// Limit caps the requests made in a period, optionally to a single route
+type Limit struct {
+	Name string `json:"name"`
+	// Route is the route pattern the limit counts, or empty for every route
+	Route  string `json:"route,omitempty"`
+	Period Period `json:"period"`
+	Max    int64  `json:"max"`
+}
+
+// Plan is a named set of limits. A plan without limits is unlimited.
+type Plan struct {
+	Name   string  `json:"name"`
+	Limits []Limit `json:"limits"`
+}
+
+// Usage reports the consumption of one limit in the current period
+type Usage struct {
+	Limit
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// Result is the outcome of counting a request
+type Result struct {
+	Allowed bool
+	Plan    string
+	// Usage covers every limit the request counted against, tightest first
+	Usage []Usage
+}
+
+// Tightest returns the usage with the fewest remaining requests, if any
+func (r Result) Tightest() (Usage, bool) {
+	if len(r.Usage) == 0 {
+		return Usage{}, false
+	}
+	return r.Usage[0], true
+}
+
+// DefaultPlans are used when no plan file is configured
+func DefaultPlans() map[string]Plan {
+	return map[string]Plan{
+		"free": {Name: "free", Limits: []Limit{
+			{Name: "requests", Period: Daily, Max: 10000},
+			{Name: "searches", Route: "/users/search", Period: Monthly, Max: 1000},
+		}},
+		"pro": {Name: "pro", Limits: []Limit{
+			{Name: "requests", Period: Daily, Max: 1000000},
+			{Name: "searches", Route: "/users/search", Period: Monthly, Max: 100000},
+		}},
+		"unlimited": {Name: "unlimited"},
+	}
+}
+
+// LoadPlans reads a JSON array of plans
+func LoadPlans(path string) (map[string]Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []Plan
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	plans := make(map[string]Plan, len(list))
+	for _, plan := range list {
+		plans[plan.Name] = plan
+	}
+	return plans, nil
+}
+
+// Manager counts requests against the plan of each user
+type Manager struct {
+	db    *sql.DB
+	plans map[string]Plan
+	now   func() time.Time
+}
+
+// NewManager validates plans and creates a manager storing usage in db
+func NewManager(db *sql.DB, plans map[string]Plan) (*Manager, error) {
+	if _, ok := plans[DefaultPlan]; !ok {
+		return nil, fmt.Errorf("quota: the %q plan must be configured", DefaultPlan)
+	}
+	for name, plan := range plans {
+		seen := make(map[string]bool)
+		for _, limit := range plan.Limits {
+			if limit.Max <= 0 || (limit.Period != Daily && limit.Period != Monthly) || seen[limit.Name] {
+				return nil, fmt.Errorf("quota: plan %q has an invalid limit %q", name, limit.Name)
+			}
+			seen[limit.Name] = true
+		}
+	}
+	return &Manager{db: db, plans: plans, now: time.Now}, nil
+}
+
+// Consume counts a request by subject to route against every applicable
+// limit of its plan. Nothing is counted when any limit is exhausted.
+func (m *Manager) Consume(ctx context.Context, subject, route string) (Result, error) {
+	plan, err := m.planFor(ctx, subject)
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{Allowed: true, Plan: plan.Name}
+
+	var limits []Limit
+	for _, limit := range plan.Limits {
+		if limit.Route == "" || limit.Route == route {
+			limits = append(limits, limit)
+		}
+	}
+	if len(limits) == 0 {
+		return res, nil
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	now := m.now().UTC()
+	for _, limit := range limits {
+		start, end := periodBounds(limit.Period, now)
+		usage := Usage{Limit: limit, ResetsAt: end}
+
+		// The increment only applies while the limit has room, which keeps
+		// concurrent replicas from overshooting it
+		err := tx.QueryRowContext(ctx,
+			`INSERT INTO quota_usage (subject, name, period_start, used) VALUES (?, ?, ?, 1)
+			ON CONFLICT (subject, name, period_start) DO UPDATE SET used = used + 1 WHERE used < ?
+			RETURNING used`,
+			subject, limit.Name, start, limit.Max).Scan(&usage.Used)
+		if err == sql.ErrNoRows {
+			usage.Used = limit.Max
+			return Result{Plan: plan.Name, Usage: []Usage{usage}}, nil
+		} else if err != nil {
+			return Result{}, err
+		}
+
+		usage.Remaining = limit.Max - usage.Used
+		res.Usage = append(res.Usage, usage)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Result{}, err
+	}
+	sort.SliceStable(res.Usage, func(i, j int) bool { return res.Usage[i].Remaining < res.Usage[j].Remaining })
+	return res, nil
+}
+
+// Usage reports the subject's plan and its consumption in the current periods
+func (m *Manager) Usage(ctx context.Context, subject string) (string, []Usage, error) {
+	plan, err := m.planFor(ctx, subject)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := m.now().UTC()
+	usage := []Usage{}
+	for _, limit := range plan.Limits {
+		start, end := periodBounds(limit.Period, now)
+		u := Usage{Limit: limit, ResetsAt: end}
+		err := m.db.QueryRowContext(ctx,
+			"SELECT used FROM quota_usage WHERE subject = ? AND name = ? AND period_start = ?",
+			subject, limit.Name, start).Scan(&u.Used)
+		if err != nil && err != sql.ErrNoRows {
+			return "", nil, err
+		}
+		u.Remaining = limit.Max - u.Used
+		usage = append(usage, u)
+	}
+	return plan.Name, usage, nil
+}
+
+// SetPlan assigns a configured plan to a user
+func (m *Manager) SetPlan(ctx context.Context, userID int64, plan string) error {
+	if _, ok := m.plans[plan]; !ok {
+		return ErrUnknownPlan
+	}
+	result, err := m.db.ExecContext(ctx, "UPDATE users SET plan = ? WHERE id = ?", plan, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUnknownUser
+	}
+	return nil
+}
+
+// Prune deletes counters from periods that ended before the previous month
+func (m *Manager) Prune(ctx context.Context) error {
+	previous, _ := periodBounds(Monthly, m.now().UTC().AddDate(0, -1, 0))
+	_, err := m.db.ExecContext(ctx, "DELETE FROM quota_usage WHERE period_start < ?", previous)
+	return err
+}
+
+// planFor looks up the subject's plan, falling back to DefaultPlan for
+// unknown users and plans that are no longer configured
+func (m *Manager) planFor(ctx context.Context, subject string) (Plan, error) {
+	var name string
+	err := m.db.QueryRowContext(ctx, "SELECT plan FROM users WHERE id = ?", subject).Scan(&name)
+	if err != nil && err != sql.ErrNoRows {
+		return Plan{}, err
+	}
+	if plan, ok := m.plans[name]; ok {
+		return plan, nil
+	}
+	return m.plans[DefaultPlan], nil
+}
+
+// periodBounds returns the key of the period containing t and when it ends
+func periodBounds(period Period, t time.Time) (string, time.Time) {
+	y, mo, d := t.Date()
+	if period == Monthly {
+		start := time.Date(y, mo, 1, 0, 0, 0, 0, time.UTC)
+		return start.Format("2006-01-02"), start.AddDate(0, 1, 0)
+	}
+	start := time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+	return start.Format("2006-01-02"), start.AddDate(0, 0, 1)
+}
@@ -0,0 +1,182 @@
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/cursorrules-golang/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPlans = map[string]Plan{
+	"free": {Name: "free", Limits: []Limit{
+		{Name: "requests", Period: Daily, Max: 5},
+		{Name: "searches", Route: "/users/search", Period: Monthly, Max: 2},
+	}},
+	"unlimited": {Name: "unlimited"},
+}
+
+func createTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		email TEXT NOT NULL UNIQUE,
+		age INTEGER NOT NULL
+	)`)
+	require.NoError(t, err)
+	require.NoError(t, database.Migrate(db))
+	_, err = db.Exec(`INSERT INTO users (name, email, age) VALUES ('alice', 'alice@example.com', 30)`)
+	require.NoError(t, err)
+	return db
+}
+
+func newTestManager(t *testing.T, db *sql.DB, now *time.Time) *Manager {
+	m, err := NewManager(db, testPlans)
+	require.NoError(t, err)
+	m.now = func() time.Time { return *now }
+	return m
+}
+
+func TestConsume(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC)
+	m := newTestManager(t, createTestDB(t), &now)
+
+	// Searches count against both limits and the monthly one is tightest
+	res, err := m.Consume(ctx, "1", "/users/search")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, "free", res.Plan)
+	tightest, ok := res.Tightest()
+	require.True(t, ok)
+	assert.Equal(t, "searches", tightest.Name)
+	assert.Equal(t, int64(1), tightest.Remaining)
+	assert.Equal(t, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), tightest.ResetsAt)
+
+	res, err = m.Consume(ctx, "1", "/users/search")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	res, err = m.Consume(ctx, "1", "/users/search")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	tightest, _ = res.Tightest()
+	assert.Equal(t, "searches", tightest.Name)
+
+	// The rejected search was not counted against the daily limit
+	_, usage, err := m.Usage(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), usage[0].Used)
+	assert.Equal(t, int64(2), usage[1].Used)
+
+	// Other routes still work until the daily limit runs out
+	for i := 0; i < 3; i++ {
+		res, err = m.Consume(ctx, "1", "/users")
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+	}
+	res, err = m.Consume(ctx, "1", "/users")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	// The daily limit resets at midnight UTC, the monthly one does not
+	now = now.Add(2 * time.Hour)
+	res, err = m.Consume(ctx, "1", "/users")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, err = m.Consume(ctx, "1", "/users/search")
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+}
+
+func TestUsageSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	db := createTestDB(t)
+
+	_, err := newTestManager(t, db, &now).Consume(ctx, "1", "/users")
+	require.NoError(t, err)
+
+	plan, usage, err := newTestManager(t, db, &now).Usage(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, "free", plan)
+	assert.Equal(t, int64(1), usage[0].Used)
+	assert.Equal(t, int64(4), usage[0].Remaining)
+}
+
+func TestPlans(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	m := newTestManager(t, createTestDB(t), &now)
+
+	assert.ErrorIs(t, m.SetPlan(ctx, 1, "platinum"), ErrUnknownPlan)
+	assert.ErrorIs(t, m.SetPlan(ctx, 99, "unlimited"), ErrUnknownUser)
+	require.NoError(t, m.SetPlan(ctx, 1, "unlimited"))
+	for i := 0; i < 10; i++ {
+		res, err := m.Consume(ctx, "1", "/users/search")
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		_, limited := res.Tightest()
+		assert.False(t, limited)
+	}
+
+	// Unknown subjects get the default plan
+	plan, _, err := m.Usage(ctx, "42")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPlan, plan)
+
+	_, err = NewManager(nil, map[string]Plan{"pro": {Name: "pro"}})
+	assert.Error(t, err)
+	_, err = NewManager(nil, map[string]Plan{"free": {Name: "free", Limits: []Limit{{Name: "x", Period: "weekly", Max: 1}}}})
+	assert.Error(t, err)
+}
+
+func TestConcurrentConsumeNeverOvershoots(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	m := newTestManager(t, createTestDB(t), &now)
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := m.Consume(context.Background(), "1", "/users")
+			if assert.NoError(t, err) && res.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(5), allowed.Load())
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 8, 10, 12, 0, 0, 0, time.UTC)
+	db := createTestDB(t)
+	m := newTestManager(t, db, &now)
+
+	_, err := m.Consume(ctx, "1", "/users/search")
+	require.NoError(t, err)
+
+	now = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	_, err = m.Consume(ctx, "1", "/users/search")
+	require.NoError(t, err)
+	require.NoError(t, m.Prune(ctx))
+
+	var rows int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM quota_usage").Scan(&rows))
+	assert.Equal(t, 2, rows)
+}
@@ -0,0 +1,152 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// NewTokenBucket creates a limiter that refills Burst tokens at Rate per second
+func NewTokenBucket(store Store, limit Limit) (Limiter, error) {
+	return newStoreLimiter(store, limit, AlgorithmTokenBucket, tokenBucketStep)
+}
+
+// tokenBucketStep keeps [tokens as float64 bits, last refill in unix nanoseconds]
+func tokenBucketStep(state []int64, now time.Time, limit Limit) ([]int64, time.Duration, Result) {
+	burst := float64(limit.Burst)
+	tokens := burst
+	if len(state) == 2 {
+		elapsed := now.Sub(time.Unix(0, state[1])).Seconds()
+		tokens = math.Min(burst, math.Float64frombits(uint64(state[0]))+math.Max(elapsed, 0)*limit.Rate)
+	}
+
+	var res Result
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+	res.Remaining = int(tokens)
+	res.ResetAfter = seconds((burst - tokens) / limit.Rate)
+
+	// Once full, a bucket is indistinguishable from a missing one
+	return []int64{int64(math.Float64bits(tokens)), now.UnixNano()}, res.ResetAfter, res
+}
+
+// NewSlidingLog creates a limiter that remembers the time of every allowed
+// request in the window. It is exact but stores up to Burst timestamps per key.
+func NewSlidingLog(store Store, limit Limit) (Limiter, error) {
+	return newStoreLimiter(store, limit, AlgorithmSlidingLog, slidingLogStep)
+}
+
+// slidingLogStep keeps the unix nanosecond timestamps of requests in the window, oldest first
+func slidingLogStep(state []int64, now time.Time, limit Limit) ([]int64, time.Duration, Result) {
+	window := limit.window()
+	cutoff := now.Add(-window).UnixNano()
+	log := state[:0]
+	for _, ts := range state {
+		if ts > cutoff {
+			log = append(log, ts)
+		}
+	}
+
+	var res Result
+	if len(log) < limit.Burst {
+		log = append(log, now.UnixNano())
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Unix(0, log[0]).Add(window).Sub(now)
+	}
+	res.Remaining = limit.Burst - len(log)
+	res.ResetAfter = time.Unix(0, log[len(log)-1]).Add(window).Sub(now)
+	return log, res.ResetAfter, res
+}
+
+// NewSlidingWindow creates a limiter that approximates a sliding window by
+// weighting the previous fixed window's count. It stores three numbers per key.
+func NewSlidingWindow(store Store, limit Limit) (Limiter, error) {
+	return newStoreLimiter(store, limit, AlgorithmSlidingWindow, slidingWindowStep)
+}
+
+// slidingWindowStep keeps [window start in unix nanoseconds, previous count, current count]
+func slidingWindowStep(state []int64, now time.Time, limit Limit) ([]int64, time.Duration, Result) {
+	window := limit.window().Nanoseconds()
+	start := now.UnixNano() - now.UnixNano()%window
+
+	var prev, curr int64
+	if len(state) == 3 {
+		switch state[0] {
+		case start:
+			prev, curr = state[1], state[2]
+		case start - window:
+			prev = state[2]
+		}
+	}
+
+	elapsed := float64(now.UnixNano()-start) / float64(window)
+	estimate := float64(prev)*(1-elapsed) + float64(curr)
+	burst := float64(limit.Burst)
+
+	var res Result
+	if estimate+1 <= burst {
+		curr++
+		estimate++
+		res.Allowed = true
+	} else {
+		// Wait until the previous window's weight has decayed enough. When the
+		// current window alone is over budget, it becomes the previous window
+		// and has to decay in turn.
+		from, weighted, fixed := start, prev, curr
+		if float64(curr)+1 > burst {
+			from, weighted, fixed = start+window, curr, 0
+		}
+		decay := 1 - (burst-1-float64(fixed))/float64(weighted)
+		retryAt := from + int64(math.Ceil(float64(window)*decay))
+		res.RetryAfter = time.Duration(retryAt - now.UnixNano())
+	}
+	res.Remaining = int(math.Max(0, burst-estimate))
+
+	// Both counts have decayed away two windows after this one started
+	ttl := time.Duration(start + 2*window - now.UnixNano())
+	res.ResetAfter = ttl
+	if curr == 0 {
+		res.ResetAfter = time.Duration(start + window - now.UnixNano())
+	}
+	return []int64{start, prev, curr}, ttl, res
+}
+
+// NewGCRA creates a limiter using the generic cell rate algorithm, which
+// behaves like a token bucket but stores a single timestamp per key
+func NewGCRA(store Store, limit Limit) (Limiter, error) {
+	return newStoreLimiter(store, limit, AlgorithmGCRA, gcraStep)
+}
+
+// gcraStep keeps the theoretical arrival time in unix nanoseconds
+func gcraStep(state []int64, now time.Time, limit Limit) ([]int64, time.Duration, Result) {
+	interval := limit.interval()
+	tolerance := interval * time.Duration(limit.Burst)
+
+	tat := now
+	if len(state) == 1 {
+		if stored := time.Unix(0, state[0]); stored.After(now) {
+			tat = stored
+		}
+	}
+
+	var res Result
+	next := tat.Add(interval)
+	if allowAt := next.Add(-tolerance); now.Before(allowAt) {
+		res.RetryAfter = allowAt.Sub(now)
+	} else {
+		tat = next
+		res.Allowed = true
+	}
+	res.ResetAfter = tat.Sub(now)
+	res.Remaining = int((tolerance - res.ResetAfter) / interval)
+	return []int64{tat.UnixNano()}, res.ResetAfter, res
+}
+
+func seconds(s float64) time.Duration {
+	// Round up so that waiting this long is always enough
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
@@ -0,0 +1,151 @@
+// Package ratelimit provides rate limiting algorithms whose state lives in a
+// Store, so that several replicas can enforce one shared limit.
+package ratelimit
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidLimit is returned for limits that cannot be enforced
+var ErrInvalidLimit = errors.New("ratelimit: rate must be positive and burst at least 1")
+
+// Limit allows Burst requests at once, replenished at Rate per second. Window
+// based algorithms allow Burst requests per Burst/Rate seconds.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) validate() error {
+	if l.Rate <= 0 || l.Burst < 1 {
+		return ErrInvalidLimit
+	}
+	return nil
+}
+
+// window is the period over which Burst requests are allowed
+func (l Limit) window() time.Duration {
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+// interval is the time it takes to replenish one request
+func (l Limit) interval() time.Duration {
+	return time.Duration(float64(time.Second) / l.Rate)
+}
+
+// Result describes the outcome of a single request. Limiters whose budget
+// never replenishes report negative ResetAfter and RetryAfter.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is how long until the full budget is available again
+	ResetAfter time.Duration
+	// RetryAfter is how long until the next request would be allowed. It is
+	// zero for allowed requests.
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether the request counted against key may proceed
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// UpdateFunc computes the next state of a key from the current one, which is
+// nil when the key has no state or its state expired. The new state is kept
+// for ttl.
+type UpdateFunc func(state []byte) (next []byte, ttl time.Duration, err error)
+
+// Store holds limiter state. Update must apply fn atomically with respect to
+// every other Update of the same key, including those of other processes
+// sharing the store.
+type Store interface {
+	Update(ctx context.Context, key string, fn UpdateFunc) error
+}
+
+// Algorithm names a rate limiting algorithm
+type Algorithm string
+
+// Supported algorithms
+const (
+	AlgorithmTokenBucket   Algorithm = "token_bucket"
+	AlgorithmSlidingLog    Algorithm = "sliding_log"
+	AlgorithmSlidingWindow Algorithm = "sliding_window"
+	AlgorithmGCRA          Algorithm = "gcra"
+)
+
+// New creates a limiter running algorithm against store
+func New(algorithm Algorithm, store Store, limit Limit) (Limiter, error) {
+	switch algorithm {
+	case AlgorithmTokenBucket:
+		return NewTokenBucket(store, limit)
+	case AlgorithmSlidingLog:
+		return NewSlidingLog(store, limit)
+	case AlgorithmSlidingWindow:
+		return NewSlidingWindow(store, limit)
+	case AlgorithmGCRA:
+		return NewGCRA(store, limit)
+	default:
+		return nil, fmt.Errorf("ratelimit: unknown algorithm %q", algorithm)
+	}
+}
+
+// stepFunc advances an algorithm's state for one request at now
+type stepFunc func(state []int64, now time.Time, limit Limit) (next []int64, ttl time.Duration, res Result)
+
+// storeLimiter runs a step function against a Store. State is encoded as a
+// sequence of int64s.
+type storeLimiter struct {
+	store Store
+	limit Limit
+	name  Algorithm
+	step  stepFunc
+	now   func() time.Time
+}
+
+func newStoreLimiter(store Store, limit Limit, name Algorithm, step stepFunc) (*storeLimiter, error) {
+	if err := limit.validate(); err != nil {
+		return nil, err
+	}
+	return &storeLimiter{store: store, limit: limit, name: name, step: step, now: time.Now}, nil
+}
+
+// Allow counts a request against key
+func (l *storeLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	var res Result
+	err := l.store.Update(ctx, string(l.name)+":"+key, func(state []byte) ([]byte, time.Duration, error) {
+		next, ttl, r := l.step(decodeState(state), l.now(), l.limit)
+		res = r
+		return encodeState(next), ttl, nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	res.Limit = l.limit.Burst
+	return res, nil
+}
+
+func encodeState(values []int64) []byte {
+	b := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint64(b[8*i:], uint64(v))
+	}
+	return b
+}
+
+// decodeState returns nil for missing or corrupt state, which limiters treat
+// as a fresh key
+func decodeState(b []byte) []int64 {
+	if len(b) == 0 || len(b)%8 != 0 {
+		return nil
+	}
+	values := make([]int64, len(b)/8)
+	for i := range values {
+		values[i] = int64(binary.BigEndian.Uint64(b[8*i:]))
+	}
+	return values
+}
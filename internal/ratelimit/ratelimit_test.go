@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/cursorrules-golang/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var algorithms = []Algorithm{AlgorithmTokenBucket, AlgorithmSlidingLog, AlgorithmSlidingWindow, AlgorithmGCRA}
+
+// clock is a manually advanced time source
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func openTestDB(t *testing.T, dsn string) *sql.DB {
+	db, err := sql.Open("sqlite3", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, database.Migrate(db))
+	return db
+}
+
+func newTestStores(t *testing.T, c *clock) map[string]Store {
+	memory := NewMemoryStore()
+	memory.now = c.now
+
+	db := openTestDB(t, ":memory:")
+	db.SetMaxOpenConns(1)
+	sqlite := NewSQLiteStore(db)
+	sqlite.now = c.now
+
+	return map[string]Store{"memory": memory, "sqlite": sqlite}
+}
+
+func newTestLimiter(t *testing.T, algorithm Algorithm, store Store, limit Limit, c *clock) Limiter {
+	limiter, err := New(algorithm, store, limit)
+	require.NoError(t, err)
+	limiter.(*storeLimiter).now = c.now
+	return limiter
+}
+
+func TestLimiters(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Burst: 3}
+
+	for _, algorithm := range algorithms {
+		c := &clock{t: time.Unix(1700000000, 0)}
+		for name, store := range newTestStores(t, c) {
+			t.Run(string(algorithm)+"/"+name, func(t *testing.T) {
+				limiter := newTestLimiter(t, algorithm, store, limit, c)
+
+				for i := 0; i < limit.Burst; i++ {
+					res, err := limiter.Allow(ctx, "alice")
+					require.NoError(t, err)
+					assert.True(t, res.Allowed, "request %d", i)
+					assert.Equal(t, limit.Burst, res.Limit)
+					assert.Equal(t, limit.Burst-1-i, res.Remaining)
+				}
+
+				res, err := limiter.Allow(ctx, "alice")
+				require.NoError(t, err)
+				assert.False(t, res.Allowed)
+				assert.Equal(t, 0, res.Remaining)
+				assert.Greater(t, res.RetryAfter, time.Duration(0))
+				assert.LessOrEqual(t, res.RetryAfter, 3*time.Second)
+				assert.GreaterOrEqual(t, res.ResetAfter, res.RetryAfter)
+
+				// Other keys have their own budget
+				res, err = limiter.Allow(ctx, "bob")
+				require.NoError(t, err)
+				assert.True(t, res.Allowed)
+
+				c.advance(res.RetryAfter + 3*time.Second)
+				res, err = limiter.Allow(ctx, "alice")
+				require.NoError(t, err)
+				assert.True(t, res.Allowed)
+			})
+		}
+	}
+}
+
+func TestRetryAfterIsAccurate(t *testing.T) {
+	ctx := context.Background()
+
+	for _, algorithm := range algorithms {
+		t.Run(string(algorithm), func(t *testing.T) {
+			c := &clock{t: time.Unix(1700000000, 0)}
+			store := NewMemoryStore()
+			store.now = c.now
+			limiter := newTestLimiter(t, algorithm, store, Limit{Rate: 2, Burst: 4}, c)
+
+			var res Result
+			for res.Allowed || res.Limit == 0 {
+				var err error
+				res, err = limiter.Allow(ctx, "k")
+				require.NoError(t, err)
+				c.advance(100 * time.Millisecond)
+			}
+
+			c.advance(res.RetryAfter - 100*time.Millisecond)
+			res, err := limiter.Allow(ctx, "k")
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+		})
+	}
+}
+
+func TestReplicasShareSQLiteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.db")
+	limit := Limit{Rate: 0.001, Burst: 20}
+
+	for _, algorithm := range algorithms {
+		t.Run(string(algorithm), func(t *testing.T) {
+			var replicas []Limiter
+			for i := 0; i < 3; i++ {
+				limiter, err := New(algorithm, NewSQLiteStore(openTestDB(t, path)), limit)
+				require.NoError(t, err)
+				replicas = append(replicas, limiter)
+			}
+
+			var allowed atomic.Int64
+			var wg sync.WaitGroup
+			for _, limiter := range replicas {
+				for i := 0; i < 4; i++ {
+					wg.Add(1)
+					go func(limiter Limiter) {
+						defer wg.Done()
+						for j := 0; j < 10; j++ {
+							res, err := limiter.Allow(context.Background(), "shared")
+							if assert.NoError(t, err) && res.Allowed {
+								allowed.Add(1)
+							}
+						}
+					}(limiter)
+				}
+			}
+			wg.Wait()
+
+			assert.Equal(t, int64(limit.Burst), allowed.Load())
+		})
+	}
+}
+
+func TestInvalidLimits(t *testing.T) {
+	for _, limit := range []Limit{{Rate: 0, Burst: 1}, {Rate: 1, Burst: 0}} {
+		_, err := NewGCRA(NewMemoryStore(), limit)
+		assert.ErrorIs(t, err, ErrInvalidLimit)
+	}
+	_, err := New("leaky", NewMemoryStore(), Limit{Rate: 1, Burst: 1})
+	assert.Error(t, err)
+}
+
+func TestMemoryStoreSweepsExpiredState(t *testing.T) {
+	c := &clock{t: time.Unix(1700000000, 0)}
+	store := NewMemoryStore()
+	store.now = c.now
+	limiter := newTestLimiter(t, AlgorithmGCRA, store, Limit{Rate: 1, Burst: 2}, c)
+
+	_, err := limiter.Allow(context.Background(), "k")
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.Len())
+
+	c.advance(2 * time.Second)
+	store.sweep()
+	assert.Equal(t, 0, store.Len())
+}
@@ -0,0 +1,163 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps limiter state in process. It is shared by limiters in one
+// replica only.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	now     func() time.Time
+	janitor
+}
+
+type memoryEntry struct {
+	state   []byte
+	expires time.Time
+}
+
+// NewMemoryStore creates an empty in-process store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]memoryEntry),
+		now:     time.Now,
+		janitor: newJanitor(),
+	}
+}
+
+// Update applies fn to the state of key under the store's lock
+func (s *MemoryStore) Update(ctx context.Context, key string, fn UpdateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var state []byte
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expires) {
+		state = entry.state
+	}
+
+	next, ttl, err := fn(state)
+	if err != nil {
+		return err
+	}
+	s.entries[key] = memoryEntry{state: next, expires: now.Add(ttl)}
+	return nil
+}
+
+// Len returns the number of stored keys, including expired ones not yet swept
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// StartJanitor removes expired state every interval until Stop is called
+func (s *MemoryStore) StartJanitor(interval time.Duration) {
+	s.janitor.start(interval, s.sweep)
+}
+
+func (s *MemoryStore) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for key, entry := range s.entries {
+		if !now.Before(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// SQLiteStore keeps limiter state in the rate_limits table, so replicas
+// sharing the database share their limits
+type SQLiteStore struct {
+	db  *sql.DB
+	now func() time.Time
+	janitor
+}
+
+// NewSQLiteStore creates a store backed by db
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{
+		db:      db,
+		now:     time.Now,
+		janitor: newJanitor(),
+	}
+}
+
+// Update applies fn to the state of key inside a transaction
+func (s *SQLiteStore) Update(ctx context.Context, key string, fn UpdateFunc) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Writing first takes SQLite's write lock before the read, so concurrent
+	// updates queue up instead of failing to upgrade their read locks
+	now := s.now()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM rate_limits WHERE key = ? AND expires_at <= ?",
+		key, now.UnixNano()); err != nil {
+		return err
+	}
+
+	var state []byte
+	err = tx.QueryRowContext(ctx, "SELECT state FROM rate_limits WHERE key = ?", key).Scan(&state)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	next, ttl, err := fn(state)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO rate_limits (key, state, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET state = excluded.state, expires_at = excluded.expires_at`,
+		key, next, now.Add(ttl).UnixNano()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// StartJanitor removes expired state every interval until Stop is called
+func (s *SQLiteStore) StartJanitor(interval time.Duration) {
+	s.janitor.start(interval, func() {
+		s.db.Exec("DELETE FROM rate_limits WHERE expires_at <= ?", s.now().UnixNano())
+	})
+}
+
+// janitor runs a periodic sweep in the background
+type janitor struct {
+	stop     chan struct{}
+	stopOnce *sync.Once
+}
+
+func newJanitor() janitor {
+	return janitor{stop: make(chan struct{}), stopOnce: &sync.Once{}}
+}
+
+func (j janitor) start(interval time.Duration, sweep func()) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sweep()
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the janitor. It is safe to call more than once.
+func (j janitor) Stop() {
+	j.stopOnce.Do(func() { close(j.stop) })
+}
@@ -0,0 +1,190 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by Do after Close
+var ErrClosed = errors.New("resp: client closed")
+
+// Options configures a Client
+type Options struct {
+	// Password is sent with AUTH on every new connection when set
+	Password string
+	// MaxIdle is how many idle connections are kept for reuse
+	MaxIdle int
+	// DialTimeout bounds connecting. Commands are bounded by their context.
+	DialTimeout time.Duration
+}
+
+// Client sends commands to a single server over a pool of connections. It
+// is safe for concurrent use.
+type Client struct {
+	addr   string
+	opts   Options
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// conn is a connection with its buffered reader and writer. A connection
+// whose deadline was moved by a cancellation is broken and not reused.
+type conn struct {
+	net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	broken bool
+}
+
+// NewClient creates a client for the server at addr. Connections are made
+// when first needed.
+func NewClient(addr string, opts Options) *Client {
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = 8
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	return &Client{addr: addr, opts: opts}
+}
+
+// Do sends a command and returns its reply. Error replies are returned as
+// an Error; other errors mean the command may not have run.
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	cn, err := c.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cn.Close()
+	return cn.Do(ctx, args...)
+}
+
+// Conn takes a connection from the pool for commands that must share one,
+// such as WATCH and MULTI. Close returns it to the pool.
+func (c *Client) Conn(ctx context.Context) (*Conn, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{client: c, cn: cn}, nil
+}
+
+// Conn is a single connection taken from a Client's pool
+type Conn struct {
+	client *Client
+	cn     *conn
+}
+
+// Do sends a command on the connection and returns its reply, like Client.Do
+func (c *Conn) Do(ctx context.Context, args ...string) (interface{}, error) {
+	if c.cn == nil {
+		return nil, ErrClosed
+	}
+	reply, err := c.cn.do(ctx, args)
+	if err != nil {
+		// The connection is in an unknown state
+		c.cn.broken = true
+		return nil, err
+	}
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// Close returns the connection to the pool. Connections in a transaction or
+// watching keys must be reset with EXEC, DISCARD or UNWATCH first.
+func (c *Conn) Close() error {
+	if c.cn != nil {
+		c.client.put(c.cn)
+		c.cn = nil
+	}
+	return nil
+}
+
+// Close closes idle connections. Connections in use are closed when their
+// command completes.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// get takes an idle connection or dials a new one
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: c.opts.DialTimeout}
+	nc, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if c.opts.Password != "" {
+		reply, err := cn.do(ctx, []string{"AUTH", c.opts.Password})
+		if err == nil {
+			if e, ok := reply.(Error); ok {
+				err = e
+			}
+		}
+		if err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// put returns a connection to the pool, closing it if the pool is full
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || cn.broken || len(c.idle) >= c.opts.MaxIdle {
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// do runs one command, bounded by ctx's deadline and cancellation
+func (cn *conn) do(ctx context.Context, args []string) (interface{}, error) {
+	deadline, _ := ctx.Deadline()
+	cn.SetDeadline(deadline)
+
+	// Unblock the reader if ctx is cancelled before the reply arrives
+	stop := context.AfterFunc(ctx, func() { cn.SetDeadline(time.Unix(1, 0)) })
+
+	err := WriteCommand(cn.w, args...)
+	var reply interface{}
+	if err == nil {
+		reply, err = ReadReply(cn.r)
+	}
+	if !stop() {
+		cn.broken = true
+		if err != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return reply, err
+}
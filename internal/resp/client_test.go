@@ -0,0 +1,123 @@
+package resp_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"example.com/cursorrules-golang/internal/resp"
+	"example.com/cursorrules-golang/internal/resp/resptest"
+)
+
+func newClient(t *testing.T) *resp.Client {
+	t.Helper()
+	server, err := resptest.NewServer()
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	t.Cleanup(server.Close)
+	client := resp.NewClient(server.Addr, resp.Options{})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClientDo(t *testing.T) {
+	client := newClient(t)
+	ctx := context.Background()
+
+	if _, err := client.Do(ctx, "SET", "key", "value with\r\nnewline"); err != nil {
+		t.Fatalf("SET error = %v", err)
+	}
+	value, err := resp.Bytes(client.Do(ctx, "GET", "key"))
+	if err != nil || string(value) != "value with\r\nnewline" {
+		t.Errorf("GET = %q, %v", value, err)
+	}
+
+	n, err := resp.Int(client.Do(ctx, "INCR", "counter"))
+	if err != nil || n != 1 {
+		t.Errorf("INCR = %d, %v", n, err)
+	}
+
+	if _, err := resp.Bytes(client.Do(ctx, "GET", "missing")); !errors.Is(err, resp.ErrNil) {
+		t.Errorf("GET missing error = %v, want ErrNil", err)
+	}
+
+	_, err = client.Do(ctx, "INCR", "key")
+	var replyErr resp.Error
+	if !errors.As(err, &replyErr) {
+		t.Errorf("INCR of a string error = %v, want an error reply", err)
+	}
+
+	// The connection is still usable after an error reply
+	if _, err := client.Do(ctx, "PING"); err != nil {
+		t.Errorf("PING error = %v", err)
+	}
+}
+
+func TestClientTransaction(t *testing.T) {
+	client := newClient(t)
+	ctx := context.Background()
+
+	conn, err := client.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Conn() error = %v", err)
+	}
+	defer conn.Close()
+
+	conn.Do(ctx, "WATCH", "watched")
+	// Another connection modifies the watched key
+	if _, err := client.Do(ctx, "SET", "watched", "1"); err != nil {
+		t.Fatalf("SET error = %v", err)
+	}
+	conn.Do(ctx, "MULTI")
+	conn.Do(ctx, "SET", "result", "1")
+	reply, err := conn.Do(ctx, "EXEC")
+	if err != nil || reply != nil {
+		t.Errorf("EXEC = %v, %v, want a nil reply", reply, err)
+	}
+	if _, err := resp.Bytes(client.Do(ctx, "GET", "result")); !errors.Is(err, resp.ErrNil) {
+		t.Errorf("aborted transaction wrote its value, error = %v", err)
+	}
+}
+
+func TestClientCancellation(t *testing.T) {
+	// A server that accepts connections but never replies
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	client := resp.NewClient(listener.Addr().String(), resp.Options{})
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := client.Do(ctx, "PING"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Do() returned after %v", elapsed)
+	}
+}
+
+func TestClientClosed(t *testing.T) {
+	client := newClient(t)
+	client.Close()
+	if _, err := client.Do(context.Background(), "PING"); !errors.Is(err, resp.ErrClosed) {
+		t.Errorf("Do() after Close error = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,148 @@
+// Package resp is a minimal client for servers speaking the Redis
+// serialization protocol (RESP2), such as Redis, Valkey and KeyDB.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Error is an error reply sent by the server
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// ErrNil is returned by the reply helpers for a null reply, e.g. GET of a
+// missing key
+var ErrNil = errors.New("resp: nil reply")
+
+// WriteCommand writes a command as an array of bulk strings
+func WriteCommand(w *bufio.Writer, args ...string) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return w.Flush()
+}
+
+// ReadReply reads one reply. Simple strings are returned as string, bulk
+// strings as []byte, integers as int64, arrays as []interface{}, error
+// replies as Error and null replies as nil.
+func ReadReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("resp: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("resp: bad bulk length %q", line)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("resp: bad array length %q", line)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = ReadReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	default:
+		return nil, fmt.Errorf("resp: unexpected reply %q", line)
+	}
+}
+
+// readLine reads a CRLF terminated line without its terminator
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("resp: line not terminated by CRLF: %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// Bytes converts a bulk or simple string reply
+func Bytes(reply interface{}, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch reply := reply.(type) {
+	case []byte:
+		return reply, nil
+	case string:
+		return []byte(reply), nil
+	case nil:
+		return nil, ErrNil
+	default:
+		return nil, fmt.Errorf("resp: unexpected %T reply", reply)
+	}
+}
+
+// Int converts an integer reply
+func Int(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch reply := reply.(type) {
+	case int64:
+		return reply, nil
+	case nil:
+		return 0, ErrNil
+	default:
+		return 0, fmt.Errorf("resp: unexpected %T reply", reply)
+	}
+}
+
+// Strings converts an array reply of bulk strings
+func Strings(reply interface{}, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	array, ok := reply.([]interface{})
+	if !ok {
+		if reply == nil {
+			return nil, ErrNil
+		}
+		return nil, fmt.Errorf("resp: unexpected %T reply", reply)
+	}
+	strs := make([]string, len(array))
+	for i, element := range array {
+		b, err := Bytes(element, nil)
+		if err != nil {
+			return nil, err
+		}
+		strs[i] = string(b)
+	}
+	return strs, nil
+}
@@ -0,0 +1,427 @@
+// Package resptest provides an in-process RESP server for tests. It
+// implements the subset of Redis commands used in this repository.
+package resptest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/cursorrules-golang/internal/resp"
+)
+
+// Server is a RESP server listening on a local port
+type Server struct {
+	// Addr is the host:port the server listens on
+	Addr string
+
+	listener net.Listener
+	mu       sync.Mutex
+	data     map[string]*value
+	// versions counts modifications of each key for WATCH
+	versions map[string]uint64
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// session is the transaction state of a connection
+type session struct {
+	watched map[string]uint64
+	multi   bool
+	queued  [][]string
+}
+
+// value is a string or a set, expiring at expires unless it is zero
+type value struct {
+	str     []byte
+	set     map[string]struct{}
+	expires time.Time
+}
+
+// NewServer starts a server on a random local port
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		data:     make(map[string]*value),
+		versions: make(map[string]uint64),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Close stops the server, closes every connection and waits for them to finish
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Keys returns the number of live keys
+func (s *Server) Keys() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for key := range s.data {
+		if s.lookup(key) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+// handle serves commands on a connection until it is closed
+func (s *Server) handle(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	var sess session
+	for {
+		reply, err := resp.ReadReply(r)
+		if err != nil {
+			return
+		}
+		array, ok := reply.([]interface{})
+		if !ok || len(array) == 0 {
+			return
+		}
+		args := make([]string, len(array))
+		for i, arg := range array {
+			b, ok := arg.([]byte)
+			if !ok {
+				return
+			}
+			args[i] = string(b)
+		}
+
+		s.mu.Lock()
+		out := s.dispatch(&sess, args)
+		s.mu.Unlock()
+
+		writeReply(w, out)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// lookup returns a live key, dropping it if it has expired. The caller holds s.mu.
+func (s *Server) lookup(key string) *value {
+	v, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	if !v.expires.IsZero() && !time.Now().Before(v.expires) {
+		delete(s.data, key)
+		return nil
+	}
+	return v
+}
+
+// dispatch handles transaction commands and queues or runs the others. The
+// caller holds s.mu.
+func (s *Server) dispatch(sess *session, args []string) interface{} {
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "WATCH":
+		if sess.multi {
+			return resp.Error("ERR WATCH inside MULTI is not allowed")
+		}
+		if sess.watched == nil {
+			sess.watched = make(map[string]uint64)
+		}
+		for _, key := range args[1:] {
+			s.lookup(key)
+			sess.watched[key] = s.versions[key]
+		}
+		return "OK"
+	case "UNWATCH":
+		sess.watched = nil
+		return "OK"
+	case "MULTI":
+		if sess.multi {
+			return resp.Error("ERR MULTI calls can not be nested")
+		}
+		sess.multi = true
+		return "OK"
+	case "DISCARD":
+		if !sess.multi {
+			return resp.Error("ERR DISCARD without MULTI")
+		}
+		*sess = session{}
+		return "OK"
+	case "EXEC":
+		if !sess.multi {
+			return resp.Error("ERR EXEC without MULTI")
+		}
+		queued, watched := sess.queued, sess.watched
+		*sess = session{}
+		for key, version := range watched {
+			s.lookup(key)
+			if s.versions[key] != version {
+				return nil
+			}
+		}
+		replies := make([]interface{}, len(queued))
+		for i, q := range queued {
+			replies[i] = s.exec(strings.ToUpper(q[0]), q[1:])
+		}
+		return replies
+	}
+
+	if sess.multi {
+		sess.queued = append(sess.queued, args)
+		return "QUEUED"
+	}
+	return s.exec(cmd, args[1:])
+}
+
+// touch records a modification of key for WATCH. The caller holds s.mu.
+func (s *Server) touch(key string) {
+	s.versions[key]++
+}
+
+// exec runs a command. The caller holds s.mu.
+func (s *Server) exec(cmd string, args []string) interface{} {
+	arity := map[string]int{"PING": 0, "AUTH": 1, "GET": 1, "MGET": 1, "SET": 2, "INCR": 1,
+		"DEL": 1, "EXISTS": 1, "SADD": 2, "SMEMBERS": 1, "RENAME": 2, "PEXPIRE": 2, "PTTL": 1,
+		"FLUSHALL": 0}
+	min, ok := arity[cmd]
+	if !ok {
+		return resp.Error("ERR unknown command '" + cmd + "'")
+	}
+	if len(args) < min {
+		return resp.Error("ERR wrong number of arguments for '" + cmd + "' command")
+	}
+
+	switch cmd {
+	case "PING", "AUTH":
+		return "PONG"
+	case "GET":
+		v := s.lookup(args[0])
+		if v == nil {
+			return nil
+		}
+		if v.set != nil {
+			return wrongType
+		}
+		return v.str
+	case "MGET":
+		values := make([]interface{}, len(args))
+		for i, key := range args {
+			if v := s.lookup(key); v != nil && v.set == nil {
+				values[i] = v.str
+			}
+		}
+		return values
+	case "INCR":
+		v := s.lookup(args[0])
+		if v == nil {
+			v = &value{str: []byte("0")}
+			s.data[args[0]] = v
+		} else if v.set != nil {
+			return wrongType
+		}
+		n, err := strconv.ParseInt(string(v.str), 10, 64)
+		if err != nil {
+			return resp.Error("ERR value is not an integer or out of range")
+		}
+		n++
+		v.str = []byte(strconv.FormatInt(n, 10))
+		s.touch(args[0])
+		return n
+	case "SET":
+		v := &value{str: []byte(args[1])}
+		for i := 2; i < len(args); i++ {
+			option := strings.ToUpper(args[i])
+			if (option != "PX" && option != "EX") || i+1 >= len(args) {
+				return resp.Error("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				return resp.Error("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Millisecond
+			if option == "EX" {
+				unit = time.Second
+			}
+			v.expires = time.Now().Add(time.Duration(n) * unit)
+			i++
+		}
+		s.data[args[0]] = v
+		s.touch(args[0])
+		return "OK"
+	case "DEL", "EXISTS":
+		n := int64(0)
+		for _, key := range args {
+			if s.lookup(key) != nil {
+				n++
+				if cmd == "DEL" {
+					delete(s.data, key)
+					s.touch(key)
+				}
+			}
+		}
+		return n
+	case "SADD":
+		v := s.lookup(args[0])
+		if v == nil {
+			v = &value{set: make(map[string]struct{})}
+			s.data[args[0]] = v
+		} else if v.set == nil {
+			return wrongType
+		}
+		n := int64(0)
+		for _, member := range args[1:] {
+			if _, ok := v.set[member]; !ok {
+				v.set[member] = struct{}{}
+				n++
+			}
+		}
+		s.touch(args[0])
+		return n
+	case "SMEMBERS":
+		v := s.lookup(args[0])
+		if v == nil {
+			return []interface{}{}
+		}
+		if v.set == nil {
+			return wrongType
+		}
+		members := make([]interface{}, 0, len(v.set))
+		for member := range v.set {
+			members = append(members, []byte(member))
+		}
+		return members
+	case "RENAME":
+		v := s.lookup(args[0])
+		if v == nil {
+			return resp.Error("ERR no such key")
+		}
+		delete(s.data, args[0])
+		s.data[args[1]] = v
+		s.touch(args[0])
+		s.touch(args[1])
+		return "OK"
+	case "PEXPIRE":
+		return s.pexpire(args)
+	case "PTTL":
+		v := s.lookup(args[0])
+		switch {
+		case v == nil:
+			return int64(-2)
+		case v.expires.IsZero():
+			return int64(-1)
+		default:
+			return time.Until(v.expires).Milliseconds()
+		}
+	case "FLUSHALL":
+		for key := range s.data {
+			s.touch(key)
+		}
+		s.data = make(map[string]*value)
+		return "OK"
+	}
+	return nil
+}
+
+// pexpire implements PEXPIRE key milliseconds [NX|XX|GT|LT]. A key without
+// an expiry counts as expiring never for GT and LT.
+func (s *Server) pexpire(args []string) interface{} {
+	ms, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return resp.Error("ERR value is not an integer or out of range")
+	}
+	v := s.lookup(args[0])
+	if v == nil {
+		return int64(0)
+	}
+
+	expires := time.Now().Add(time.Duration(ms) * time.Millisecond)
+	if len(args) > 2 {
+		switch strings.ToUpper(args[2]) {
+		case "NX":
+			if !v.expires.IsZero() {
+				return int64(0)
+			}
+		case "XX":
+			if v.expires.IsZero() {
+				return int64(0)
+			}
+		case "GT":
+			if v.expires.IsZero() || !expires.After(v.expires) {
+				return int64(0)
+			}
+		case "LT":
+			if !v.expires.IsZero() && !expires.Before(v.expires) {
+				return int64(0)
+			}
+		default:
+			return resp.Error("ERR Unsupported option " + args[2])
+		}
+	}
+	v.expires = expires
+	s.touch(args[0])
+	return int64(1)
+}
+
+var wrongType = resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// writeReply encodes a reply produced by exec
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch reply := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		w.WriteString("+" + reply + "\r\n")
+	case resp.Error:
+		w.WriteString("-" + string(reply) + "\r\n")
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", reply)
+	case []byte:
+		fmt.Fprintf(w, "$%d\r\n", len(reply))
+		w.Write(reply)
+		w.WriteString("\r\n")
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(reply))
+		for _, element := range reply {
+			writeReply(w, element)
+		}
+	}
+}